@@ -0,0 +1,375 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	kindCounter   = "counter"
+	kindGauge     = "gauge"
+	kindHistogram = "histogram"
+)
+
+// DefaultBuckets are the latency buckets in seconds, the same as the Prometheus client defaults.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultRegistry is a registry to share across an application, the built-in middlewares only record into the registry
+// they are given.
+var DefaultRegistry = NewRegistry()
+
+type family interface {
+	describe() (name, help, kind string)
+	// layout returns the label names and, for histograms, the buckets of the series
+	layout() (labelNames []string, buckets []float64)
+	write(writer io.Writer)
+}
+
+// Registry holds the metric families and writes them in the Prometheus text exposition format.
+type Registry struct {
+	lock     *sync.RWMutex
+	families map[string]family
+}
+
+func NewRegistry() Registry {
+	return Registry{
+		lock:     new(sync.RWMutex),
+		families: make(map[string]family),
+	}
+}
+
+// Counter registers the counter or returns the registered one, it panics if name is taken by another kind of metric or
+// with other label names.
+func (r Registry) Counter(name, help string, labelNames ...string) CounterVec {
+	return r.register(name, kindCounter, func() family {
+		return CounterVec{newVec(name, help, kindCounter, labelNames)}
+	}).(CounterVec)
+}
+
+// Gauge registers the gauge or returns the registered one, it panics if name is taken by another kind of metric or with
+// other label names.
+func (r Registry) Gauge(name, help string, labelNames ...string) GaugeVec {
+	return r.register(name, kindGauge, func() family {
+		return GaugeVec{newVec(name, help, kindGauge, labelNames)}
+	}).(GaugeVec)
+}
+
+// Histogram registers the histogram or returns the registered one, DefaultBuckets are used if buckets is empty. It
+// panics if name is taken by another kind of metric or with other label names or buckets.
+func (r Registry) Histogram(name, help string, buckets []float64, labelNames ...string) HistogramVec {
+	return r.register(name, kindHistogram, func() family {
+		return newHistogramVec(name, help, buckets, labelNames)
+	}).(HistogramVec)
+}
+
+func (r Registry) register(name, kind string, create func() family) family {
+	f := create()
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if registered, exists := r.families[name]; exists {
+		if _, _, registeredKind := registered.describe(); registeredKind != kind {
+			panic(fmt.Errorf("metric %s is already registered as %s", name, registeredKind))
+		}
+		registeredLabelNames, registeredBuckets := registered.layout()
+		labelNames, buckets := f.layout()
+		if !slices.Equal(registeredLabelNames, labelNames) {
+			panic(fmt.Errorf("metric %s is already registered with labels %v", name, registeredLabelNames))
+		}
+		if !slices.Equal(registeredBuckets, buckets) {
+			panic(fmt.Errorf("metric %s is already registered with buckets %v", name, registeredBuckets))
+		}
+		return registered
+	}
+	r.families[name] = f
+	return f
+}
+
+// Expose writes every metric family sorted by name.
+func (r Registry) Expose(writer io.Writer) {
+	r.lock.RLock()
+	families := make([]family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.lock.RUnlock()
+	sort.Slice(families, func(i, j int) bool {
+		iName, _, _ := families[i].describe()
+		jName, _, _ := families[j].describe()
+		return iName < jName
+	})
+	for _, f := range families {
+		name, help, kind := f.describe()
+		fmt.Fprintf(writer, "# HELP %s %s\n# TYPE %s %s\n", name, escapeHelp(help), name, kind)
+		f.write(writer)
+	}
+}
+
+func (r Registry) String() string {
+	var builder strings.Builder
+	r.Expose(&builder)
+	return builder.String()
+}
+
+// vec holds the series of a counter or gauge keyed by their label values
+type vec struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+	lock       *sync.Mutex
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// valueFunc is read on scrape instead of value if set
+	valueFunc func() float64
+}
+
+func newVec(name, help, kind string, labelNames []string) vec {
+	return vec{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		lock:       new(sync.Mutex),
+		series:     make(map[string]*series),
+	}
+}
+
+func (v vec) describe() (string, string, string) {
+	return v.name, v.help, v.kind
+}
+
+func (v vec) layout() ([]string, []float64) {
+	return v.labelNames, nil
+}
+
+// withSeries runs cb with the series of labelValues under the lock
+func (v vec) withSeries(labelValues []string, cb func(s *series)) {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Errorf("metric %s expects %d label values but got %d", v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	s, exists := v.series[key]
+	if !exists {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		v.series[key] = s
+	}
+	cb(s)
+}
+
+func (v vec) write(writer io.Writer) {
+	v.lock.Lock()
+	keys := sortedKeys(v.series)
+	samples := make([]series, len(keys))
+	for i, key := range keys {
+		samples[i] = *v.series[key]
+	}
+	v.lock.Unlock()
+	for _, s := range samples {
+		value := s.value
+		if s.valueFunc != nil {
+			value = s.valueFunc()
+		}
+		fmt.Fprintf(writer, "%s%s %s\n", v.name, formatLabels(v.labelNames, s.labelValues), formatValue(value))
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (c CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds delta to the counter, negative deltas are ignored as counters only go up.
+func (c CounterVec) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		return
+	}
+	c.withSeries(labelValues, func(s *series) {
+		s.value += delta
+	})
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (g GaugeVec) Set(value float64, labelValues ...string) {
+	g.withSeries(labelValues, func(s *series) {
+		s.value = value
+	})
+}
+
+func (g GaugeVec) Add(delta float64, labelValues ...string) {
+	g.withSeries(labelValues, func(s *series) {
+		s.value += delta
+	})
+}
+
+func (g GaugeVec) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+func (g GaugeVec) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// SetFunc makes the gauge read its value from valueFunc on every scrape.
+func (g GaugeVec) SetFunc(valueFunc func() float64, labelValues ...string) {
+	g.withSeries(labelValues, func(s *series) {
+		s.valueFunc = valueFunc
+	})
+}
+
+type HistogramVec struct {
+	name       string
+	help       string
+	buckets    []float64
+	labelNames []string
+	lock       *sync.Mutex
+	series     map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	count       uint64
+	sum         float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labelNames []string) HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return HistogramVec{
+		name:       name,
+		help:       help,
+		buckets:    buckets,
+		labelNames: labelNames,
+		lock:       new(sync.Mutex),
+		series:     make(map[string]*histogramSeries),
+	}
+}
+
+func (h HistogramVec) describe() (string, string, string) {
+	return h.name, h.help, kindHistogram
+}
+
+func (h HistogramVec) layout() ([]string, []float64) {
+	return h.labelNames, h.buckets
+}
+
+func (h HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.labelNames) {
+		panic(fmt.Errorf("metric %s expects %d label values but got %d", h.name, len(h.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	s, exists := h.series[key]
+	if !exists {
+		s = &histogramSeries{
+			labelValues: append([]string(nil), labelValues...),
+			counts:      make([]uint64, len(h.buckets)),
+		}
+		h.series[key] = s
+	}
+	// counts are per bucket here and accumulated when written
+	if index := sort.SearchFloat64s(h.buckets, value); index < len(h.buckets) {
+		s.counts[index]++
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h HistogramVec) write(writer io.Writer) {
+	h.lock.Lock()
+	keys := sortedKeys(h.series)
+	samples := make([]histogramSeries, len(keys))
+	for i, key := range keys {
+		s := *h.series[key]
+		s.counts = append([]uint64(nil), s.counts...)
+		samples[i] = s
+	}
+	h.lock.Unlock()
+	bucketLabels := append(append([]string(nil), h.labelNames...), "le")
+	for _, s := range samples {
+		bucketValues := append(append([]string(nil), s.labelValues...), "")
+		var cumulative uint64
+		for i, bound := range h.buckets {
+			cumulative += s.counts[i]
+			bucketValues[len(bucketValues)-1] = formatValue(bound)
+			fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, bucketValues), cumulative)
+		}
+		bucketValues[len(bucketValues)-1] = "+Inf"
+		fmt.Fprintf(writer, "%s_bucket%s %d\n", h.name, formatLabels(bucketLabels, bucketValues), s.count)
+		labels := formatLabels(h.labelNames, s.labelValues)
+		fmt.Fprintf(writer, "%s_sum%s %s\n", h.name, labels, formatValue(s.sum))
+		fmt.Fprintf(writer, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	builder.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteByte(',')
+		}
+		fmt.Fprintf(&builder, `%s="%s"`, name, escapeLabelValue(values[i]))
+	}
+	builder.WriteByte('}')
+	return builder.String()
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExpose(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.Counter("requests_total", "Number of\nrequests.", "path", "method")
+	requests.Inc("/a", "GET")
+	requests.Add(2, "/a", "GET")
+	requests.Add(-1, "/a", "GET")
+	requests.Inc(`/b"\`+"\n", "POST")
+	size := registry.Gauge("size", "Size.")
+	size.SetFunc(func() float64 {
+		return 7
+	})
+	latency := registry.Histogram("latency_seconds", "Latency.", []float64{1, 0.1}, "path")
+	latency.Observe(0.05, "/a")
+	latency.Observe(0.5, "/a")
+	latency.Observe(5, "/a")
+	expected := `# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{path="/a",le="0.1"} 1
+latency_seconds_bucket{path="/a",le="1"} 2
+latency_seconds_bucket{path="/a",le="+Inf"} 3
+latency_seconds_sum{path="/a"} 5.55
+latency_seconds_count{path="/a"} 3
+# HELP requests_total Number of\nrequests.
+# TYPE requests_total counter
+requests_total{path="/a",method="GET"} 3
+requests_total{path="/b\"\\\n",method="POST"} 1
+# HELP size Size.
+# TYPE size gauge
+size 7
+`
+	if exposed := registry.String(); exposed != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, exposed)
+	}
+}
+
+func TestRegisterReturnsTheRegisteredMetric(t *testing.T) {
+	registry := NewRegistry()
+	registry.Gauge("in_flight", "In flight.").Inc()
+	registry.Gauge("in_flight", "In flight.").Inc()
+	if exposed := registry.String(); !strings.Contains(exposed, "in_flight 2\n") {
+		t.Fatalf("expected the gauge to be shared, got\n%s", exposed)
+	}
+	assertPanics(t, "registering another kind", func() {
+		registry.Counter("in_flight", "In flight.")
+	})
+	assertPanics(t, "registering other label names", func() {
+		registry.Gauge("in_flight", "In flight.", "service")
+	})
+	registry.Histogram("latency_seconds", "Latency.", []float64{1, .5}, "method")
+	registry.Histogram("latency_seconds", "Latency.", []float64{.5, 1}, "method").Observe(.7, "GET")
+	assertPanics(t, "registering other buckets", func() {
+		registry.Histogram("latency_seconds", "Latency.", nil, "method")
+	})
+	assertPanics(t, "registering other histogram label names", func() {
+		registry.Histogram("latency_seconds", "Latency.", []float64{.5, 1}, "path")
+	})
+	assertPanics(t, "missing label values", func() {
+		registry.Counter("requests_total", "Requests.", "method").Inc()
+	})
+}
+
+func assertPanics(t *testing.T, name string, f func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("expected %s to panic", name)
+		}
+	}()
+	f()
+}
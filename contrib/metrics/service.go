@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/store"
+)
+
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// NewMetricsService serves the metrics of registry at path in the Prometheus text format as the service id.
+func NewMetricsService(id, path string, registry Registry) (server.Service, error) {
+	return server.NewServiceBuilder().
+		Id(id).
+		WithRouteHandlers(server.PathHandlerBuilder(path).Get(func(r server.Request) (server.Response, server.ServiceError) {
+			var builder strings.Builder
+			registry.Expose(&builder)
+			return server.NewResponseWithContentType(http.StatusOK, builder.String(), ContentType), nil
+		})).
+		Build()
+}
+
+// RegisterKVStore exposes the size and capacity of kvStore labeled by name.
+func RegisterKVStore(registry Registry, name string, kvStore store.SizedKVStore) {
+	registry.Gauge("aghs_kv_store_size", "Number of records in the kv store.", "store").
+		SetFunc(func() float64 {
+			return float64(kvStore.Size())
+		}, name)
+	registry.Gauge("aghs_kv_store_capacity", "Maximum number of records the kv store holds.", "store").
+		SetFunc(func() float64 {
+			return float64(kvStore.Capacity())
+		}, name)
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+	"github.com/dlshle/aghs/store"
+)
+
+func TestMetricsService(t *testing.T) {
+	registry := NewRegistry()
+	kvStore := store.NewInMemoryKVStore(4).(store.SizedKVStore)
+	RegisterKVStore(registry, "sessions", kvStore)
+	service, err := NewMetricsService("admin-metrics", "/metrics", registry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if service.Id() != "admin-metrics" {
+		t.Fatalf("unexpected service id %s", service.Id())
+	}
+	other, err := NewMetricsService("public-metrics", "/metrics", NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// two metrics services can be served by one server
+	if _, err := server.NewBuilder().WithServices([]server.Service{service, other}).Build(); err != nil {
+		t.Fatal(err)
+	}
+	servertest.NewForService(t, service).Get("/metrics").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("Content-Type", ContentType).
+		AssertBodyContains(`aghs_kv_store_capacity{store="sessions"} 4`).
+		AssertBodyContains(`aghs_kv_store_size{store="sessions"} 0`)
+	if _, err := NewMetricsService("", "/metrics", registry); err == nil {
+		t.Fatal("expected the build error of an empty id")
+	}
+}
@@ -0,0 +1,177 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/gommon/logging"
+)
+
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Common Log Format: host ident user [time] "request" status bytes
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogCombined appends "referer" "user-agent" to the Common Log Format
+	AccessLogCombined
+	AccessLogJSON
+)
+
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+type AccessLogConfig struct {
+	Format AccessLogFormat
+	// Logger writes the access log at INFO level and WARN level for slow and 5xx requests
+	Logger logging.Logger
+	// SlowThreshold flags requests taking longer, 0 disables the flag
+	SlowThreshold time.Duration
+	// SampleRate is the fraction of requests to log, 0 logs all of them, slow and 5xx requests are always logged
+	SampleRate float64
+	// SkipPaths are not logged, e.g. health checks
+	SkipPaths []string
+}
+
+type AccessLogEntry struct {
+	Time          time.Time `json:"time"`
+	Method        string    `json:"method"`
+	Path          string    `json:"path"`
+	URI           string    `json:"uri"`
+	Protocol      string    `json:"protocol"`
+	UriPattern    string    `json:"uriPattern,omitempty"`
+	ServiceId     string    `json:"serviceId,omitempty"`
+	Status        int       `json:"status"`
+	Bytes         int       `json:"bytes"`
+	Latency       float64   `json:"latencyMs"`
+	RemoteAddress string    `json:"remoteAddress"`
+	TraceID       string    `json:"traceId,omitempty"`
+	Referer       string    `json:"referer,omitempty"`
+	UserAgent     string    `json:"userAgent,omitempty"`
+	Slow          bool      `json:"slow,omitempty"`
+}
+
+// AccessLogMiddleware logs every request once its response has been written, for streaming responses once the stream
+// has ended, install it before TracingMiddleware to log trace_id.
+func AccessLogMiddleware(config AccessLogConfig) server.Middleware {
+	if config.Logger == nil {
+		config.Logger = logging.GlobalLogger.WithPrefix("[AccessLog]")
+	}
+	skipPaths := make(map[string]bool, len(config.SkipPaths))
+	for _, path := range config.SkipPaths {
+		skipPaths[path] = true
+	}
+	return func(ctx server.MiddlewareContext) {
+		request := ctx.Request()
+		if skipPaths[request.Path()] {
+			ctx.Next()
+			return
+		}
+		start := time.Now()
+		ctx.Next()
+		latency := time.Since(start)
+		entry := newAccessLogEntry(ctx, start, latency)
+		entry.Slow = config.SlowThreshold > 0 && latency > config.SlowThreshold
+		notable := entry.Slow || entry.Status >= http.StatusInternalServerError
+		if !notable && config.SampleRate > 0 && rand.Float64() >= config.SampleRate {
+			return
+		}
+		// the request is recycled once the response is written
+		requestCtx := request.Context()
+		log := func() {
+			line := formatAccessLogEntry(config.Format, entry)
+			if entry.Slow || entry.Status >= http.StatusInternalServerError {
+				config.Logger.Warnf(requestCtx, "%s", line)
+			} else {
+				config.Logger.Infof(requestCtx, "%s", line)
+			}
+		}
+		written := server.OnResponseWritten(request, func(status int, bytes int64) {
+			if status != 0 {
+				entry.Status = status
+			}
+			entry.Bytes = int(bytes)
+			log()
+		})
+		if !written {
+			log()
+		}
+	}
+}
+
+func newAccessLogEntry(ctx server.MiddlewareContext, start time.Time, latency time.Duration) AccessLogEntry {
+	request := ctx.Request()
+	entry := AccessLogEntry{
+		Time:          start,
+		Method:        request.Method(),
+		Path:          request.Path(),
+		URI:           request.URI(),
+		Protocol:      request.Protocol(),
+		UriPattern:    request.UriPattern(),
+		Latency:       float64(latency.Microseconds()) / 1000,
+		RemoteAddress: request.RemoteAddress(),
+		TraceID:       request.ContextValues()[CtxKeyTraceID],
+		Referer:       request.Header().Get("Referer"),
+		UserAgent:     request.Header().Get("User-Agent"),
+	}
+	if service := request.MatchedService(); service != nil {
+		entry.ServiceId = service.Id()
+	}
+	if err := ctx.Error(); err != nil {
+		entry.Status = err.Code()
+	} else {
+		entry.Status = ctx.Response().Code()
+	}
+	return entry
+}
+
+func formatAccessLogEntry(format AccessLogFormat, entry AccessLogEntry) string {
+	if format == AccessLogJSON {
+		line, _ := json.Marshal(entry)
+		return string(line)
+	}
+	var builder strings.Builder
+	fmt.Fprintf(&builder, `%s - - [%s] "%s %s %s" %d %s`,
+		remoteHost(entry.RemoteAddress),
+		entry.Time.Format(clfTimeFormat),
+		entry.Method,
+		entry.URI,
+		entry.Protocol,
+		entry.Status,
+		clfBytes(entry.Bytes),
+	)
+	if format == AccessLogCombined {
+		fmt.Fprintf(&builder, ` "%s" "%s"`, clfValue(entry.Referer), clfValue(entry.UserAgent))
+	}
+	if entry.TraceID != "" {
+		fmt.Fprintf(&builder, " trace_id=%s", entry.TraceID)
+	}
+	if entry.Slow {
+		fmt.Fprintf(&builder, " slow latency=%.3fms", entry.Latency)
+	}
+	return builder.String()
+}
+
+func remoteHost(addr string) string {
+	if index := strings.LastIndex(addr, ":"); index > 0 {
+		return strings.Trim(addr[:index], "[]")
+	}
+	return addr
+}
+
+func clfBytes(bytes int) string {
+	if bytes == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", bytes)
+}
+
+func clfValue(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
@@ -0,0 +1,170 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+	"github.com/dlshle/gommon/logging"
+)
+
+// newAccessLogTestServer serves the files service behind the access log followed by middlewares
+func newAccessLogTestServer(t *testing.T, config AccessLogConfig, middlewares ...server.Middleware) (*servertest.Server, *bytes.Buffer) {
+	logs := new(bytes.Buffer)
+	config.Logger = logging.NewLevelLogger(logs, "", 0, logging.TRACE)
+	service := server.NewServiceBuilder().Id("files").
+		WithRouteHandlers(server.PathHandlerBuilder("/json/:id").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewResponse(http.StatusOK, map[string]string{"id": r.PathParams()["id"]}), nil
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/stream").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewStreamResponse(http.StatusOK, "text/plain", func(w server.StreamWriter) error {
+				for i := 0; i < 3; i++ {
+					if _, err := fmt.Fprintf(w, "chunk %d\n", i); err != nil {
+						return err
+					}
+				}
+				return nil
+			}), nil
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/reader").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewReaderResponse(http.StatusOK, "text/plain", strings.NewReader(strings.Repeat("x", 100))), nil
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/slow").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			time.Sleep(time.Millisecond * 20)
+			return server.NewPlainTextResponse(http.StatusOK, "slow"), nil
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/fail").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return nil, server.InternalError("database is down")
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/health").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewPlainTextResponse(http.StatusOK, "ok"), nil
+		})).
+		MustBuild()
+	return servertest.NewForService(t, service, append([]server.Middleware{AccessLogMiddleware(config)}, middlewares...)...), logs
+}
+
+func accessLogLines(logs *bytes.Buffer) []string {
+	output := strings.TrimSpace(logs.String())
+	logs.Reset()
+	if output == "" {
+		return nil
+	}
+	return strings.Split(output, "\n")
+}
+
+func TestAccessLogFormats(t *testing.T) {
+	cases := []struct {
+		format   AccessLogFormat
+		expected string
+	}{
+		{AccessLogCommon, `10.0.0.1 - - [%s] "GET /json/42?v=1 HTTP/1.1" 200 %d trace_id=0af7651916cd43dd8448eb211c80319c`},
+		{AccessLogCombined, `10.0.0.1 - - [%s] "GET /json/42?v=1 HTTP/1.1" 200 %d "https://example.com/" "tests \"quoted\"" trace_id=0af7651916cd43dd8448eb211c80319c`},
+	}
+	for _, c := range cases {
+		svr, logs := newAccessLogTestServer(t, AccessLogConfig{Format: c.format}, TracingMiddleware)
+		before := time.Now()
+		resp := svr.Get("/json/42?v=1").
+			RemoteAddr("10.0.0.1:1234").
+			Header("Referer", "https://example.com/").
+			Header("User-Agent", `tests "quoted"`).
+			Header("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01").
+			Do().AssertStatus(http.StatusOK)
+		lines := accessLogLines(logs)
+		if len(lines) != 1 {
+			t.Fatalf("expected one line, got %q", lines)
+		}
+		expected := fmt.Sprintf(c.expected, before.Format(clfTimeFormat), len(resp.Body()))
+		if lines[0] != expected && lines[0] != fmt.Sprintf(c.expected, time.Now().Format(clfTimeFormat), len(resp.Body())) {
+			t.Errorf("expected %s, got %s", expected, lines[0])
+		}
+	}
+}
+
+func TestAccessLogJSONFormat(t *testing.T) {
+	svr, logs := newAccessLogTestServer(t, AccessLogConfig{Format: AccessLogJSON})
+	resp := svr.Get("/json/42").RemoteAddr("10.0.0.1:1234").Do().AssertStatus(http.StatusOK)
+	lines := accessLogLines(logs)
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %q", lines)
+	}
+	var entry AccessLogEntry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Method != http.MethodGet || entry.Path != "/json/42" || entry.UriPattern != "/json/:id" || entry.ServiceId != "files" ||
+		entry.Status != http.StatusOK || entry.Bytes != len(resp.Body()) || entry.RemoteAddress != "10.0.0.1:1234" || entry.Slow {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+}
+
+func TestAccessLogBytes(t *testing.T) {
+	svr, logs := newAccessLogTestServer(t, AccessLogConfig{Format: AccessLogJSON})
+	cases := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{http.MethodGet, "/json/1", http.StatusOK},
+		{http.MethodGet, "/stream", http.StatusOK},
+		{http.MethodGet, "/reader", http.StatusOK},
+		{http.MethodHead, "/reader", http.StatusOK},
+		{http.MethodGet, "/fail", http.StatusInternalServerError},
+		{http.MethodGet, "/missing", http.StatusNotFound},
+	}
+	for _, c := range cases {
+		resp := svr.Request(c.method, c.path).Do().AssertStatus(c.status)
+		lines := accessLogLines(logs)
+		if len(lines) != 1 {
+			t.Fatalf("expected one line for %s %s, got %q", c.method, c.path, lines)
+		}
+		var entry AccessLogEntry
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if entry.Status != c.status || entry.Bytes != len(resp.Body()) {
+			t.Errorf("expected %d with %d bytes for %s %s, got %+v", c.status, len(resp.Body()), c.method, c.path, entry)
+		}
+		if c.method == http.MethodGet && entry.Bytes == 0 {
+			t.Errorf("expected the bytes of %s to be counted", c.path)
+		}
+	}
+}
+
+func TestAccessLogSkipsAndSamples(t *testing.T) {
+	svr, logs := newAccessLogTestServer(t, AccessLogConfig{
+		SlowThreshold: time.Millisecond * 10,
+		SampleRate:    1e-9,
+		SkipPaths:     []string{"/health"},
+	})
+	svr.Get("/health").Do().AssertStatus(http.StatusOK)
+	svr.Get("/json/1").Do().AssertStatus(http.StatusOK)
+	if lines := accessLogLines(logs); len(lines) != 0 {
+		t.Fatalf("expected the skipped and the sampled out requests not to be logged, got %q", lines)
+	}
+	// slow and 5xx requests are always logged
+	svr.Get("/slow").Do().AssertStatus(http.StatusOK)
+	svr.Get("/fail").Do().AssertStatus(http.StatusInternalServerError)
+	lines := accessLogLines(logs)
+	if len(lines) != 2 || !strings.Contains(lines[0], `"GET /slow HTTP/1.1" 200 4 slow latency=`) ||
+		!strings.Contains(lines[1], `"GET /fail HTTP/1.1" 500`) {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
+
+func TestAccessLogWithoutServer(t *testing.T) {
+	logs := new(bytes.Buffer)
+	middleware := AccessLogMiddleware(AccessLogConfig{Logger: logging.NewLevelLogger(logs, "", 0, logging.TRACE)})
+	request := servertest.NewRequest(http.MethodGet, "/students/1").RemoteAddr("10.0.0.1:1234").MustBuild()
+	// the response size is unknown when the request is not served by a Server
+	servertest.RunMiddlewareWithResponse(middleware, request, server.NewResponse(http.StatusOK, "ok"), nil)
+	lines := accessLogLines(logs)
+	if len(lines) != 1 || !strings.HasSuffix(lines[0], `"GET /students/1 HTTP/1.1" 200 -`) {
+		t.Fatalf("unexpected lines %q", lines)
+	}
+}
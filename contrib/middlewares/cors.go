@@ -25,7 +25,8 @@ func CORSAllowWildcardMiddleware(ctx server.MiddlewareContext) {
 	ctx.Response().SetHeader(HeaderKeyAllowOrigin, origin)
 	ctx.Response().SetHeader(HeaderKeyVary, "Origin")
 
-	if ctx.Request().Method() == http.MethodOptions {
+	// unmatched routes have no service to tell the allowed methods
+	if ctx.Request().Method() == http.MethodOptions && ctx.Request().MatchedService() != nil {
 		// allow headers
 		requestedHdrs := ctx.Request().Header().Get("Access-Control-Request-Headers")
 		ctx.Response().SetHeader(HeaderKeyAllowHeaders, requestedHdrs)
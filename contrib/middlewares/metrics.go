@@ -0,0 +1,78 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dlshle/aghs/contrib/metrics"
+	"github.com/dlshle/aghs/server"
+)
+
+// MetricsMiddleware records the request count, latency and in-flight requests in registry. Requests are labeled by the
+// uri pattern rather than the raw path to keep the number of series bounded, unmatched routes have empty service and
+// uri_pattern labels and methods that are neither standard nor routed are labeled other.
+func MetricsMiddleware(registry metrics.Registry) server.Middleware {
+	requests := registry.Counter("aghs_http_requests_total", "Number of handled HTTP requests.",
+		"service", "uri_pattern", "method", "status")
+	latency := registry.Histogram("aghs_http_request_duration_seconds", "Latency of handled HTTP requests.", nil,
+		"service", "uri_pattern", "method", "status")
+	inFlight := registry.Gauge("aghs_http_requests_in_flight", "Number of HTTP requests being handled.",
+		"service", "uri_pattern", "method")
+	return func(ctx server.MiddlewareContext) {
+		request := ctx.Request()
+		serviceId, uriPattern, method := requestLabels(request)
+		inFlight.Inc(serviceId, uriPattern, method)
+		defer inFlight.Dec(serviceId, uriPattern, method)
+		start := time.Now()
+		ctx.Next()
+		status := strconv.Itoa(responseStatus(ctx))
+		requests.Inc(serviceId, uriPattern, method, status)
+		latency.Observe(time.Since(start).Seconds(), serviceId, uriPattern, method, status)
+	}
+}
+
+const otherMethodLabel = "other"
+
+var standardMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodConnect: true,
+	http.MethodOptions: true,
+	http.MethodTrace:   true,
+}
+
+func requestLabels(request server.Request) (serviceId, uriPattern, method string) {
+	service := request.MatchedService()
+	if service != nil {
+		serviceId = service.Id()
+	}
+	return serviceId, request.UriPattern(), methodLabel(request, service)
+}
+
+// methodLabel keeps clients from creating series with arbitrary methods
+func methodLabel(request server.Request, service server.Service) string {
+	method := request.Method()
+	if standardMethods[method] {
+		return method
+	}
+	if service != nil {
+		for _, supported := range service.SupportedMethodsForPattern(request.UriPattern()) {
+			if supported == method {
+				return method
+			}
+		}
+	}
+	return otherMethodLabel
+}
+
+func responseStatus(ctx server.MiddlewareContext) int {
+	if err := ctx.Error(); err != nil {
+		return err.Code()
+	}
+	return ctx.Response().Code()
+}
@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dlshle/aghs/contrib/metrics"
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+)
+
+func newStudentsService() server.Service {
+	return server.NewServiceBuilder().Id("students").
+		WithRouteHandlers(server.PathHandlerBuilder("/students/:id").
+			Get(func(r server.Request) (server.Response, server.ServiceError) {
+				return server.NewResponse(http.StatusOK, r.PathParams()["id"]), nil
+			}).
+			Delete(func(r server.Request) (server.Response, server.ServiceError) {
+				return nil, server.InternalError("database is down")
+			})).
+		MustBuild()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	registry := metrics.NewRegistry()
+	svr := servertest.NewForService(t, newStudentsService(), MetricsMiddleware(registry))
+	svr.Get("/students/1").Do().AssertStatus(http.StatusOK)
+	svr.Get("/students/2").Do().AssertStatus(http.StatusOK)
+	svr.Delete("/students/1").Do().AssertStatus(http.StatusInternalServerError)
+	svr.Get("/teachers/1").Do().AssertStatus(http.StatusNotFound)
+	svr.Request("PURGE", "/students/1").Do()
+	exposed := registry.String()
+	for _, line := range []string{
+		`aghs_http_requests_total{service="students",uri_pattern="/students/:id",method="GET",status="200"} 2`,
+		`aghs_http_requests_total{service="students",uri_pattern="/students/:id",method="DELETE",status="500"} 1`,
+		`aghs_http_requests_total{service="",uri_pattern="",method="GET",status="404"} 1`,
+		`aghs_http_requests_total{service="students",uri_pattern="/students/:id",method="other",status="405"} 1`,
+		`aghs_http_request_duration_seconds_count{service="students",uri_pattern="/students/:id",method="GET",status="200"} 2`,
+		`aghs_http_requests_in_flight{service="students",uri_pattern="/students/:id",method="GET"} 0`,
+	} {
+		if !strings.Contains(exposed, line+"\n") {
+			t.Errorf("expected %s in\n%s", line, exposed)
+		}
+	}
+}
+
+func TestThrottlingMiddlewareWithMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	svr := servertest.NewForService(t, newStudentsService(), NewIpThrottlingMiddlewareWithMetrics(1, registry))
+	svr.Get("/students/1").RemoteAddr("10.0.0.1:1000").Do().AssertStatus(http.StatusOK)
+	svr.Get("/students/1").RemoteAddr("10.0.0.1:1000").Do().AssertStatus(http.StatusTooManyRequests)
+	expected := `aghs_throttle_rejections_total{service="students",uri_pattern="/students/:id",method="GET"} 1`
+	if exposed := registry.String(); !strings.Contains(exposed, expected) {
+		t.Fatalf("expected %s in\n%s", expected, exposed)
+	}
+	// without a registry nothing is recorded anywhere
+	if exposed := metrics.DefaultRegistry.String(); exposed != "" {
+		t.Fatalf("unexpected metrics in the default registry\n%s", exposed)
+	}
+	servertest.NewForService(t, newStudentsService(), NewIpThrottlingMiddleware(0)).
+		Get("/students/1").Do().AssertStatus(http.StatusTooManyRequests)
+	if exposed := metrics.DefaultRegistry.String(); exposed != "" {
+		t.Fatalf("unexpected metrics in the default registry\n%s", exposed)
+	}
+}
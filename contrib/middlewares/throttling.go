@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"github.com/dlshle/aghs/constant"
+	"github.com/dlshle/aghs/contrib/metrics"
 	"github.com/dlshle/aghs/server"
 	throttle "github.com/dlshle/aghs/utils"
 	"net/http"
@@ -35,6 +36,19 @@ func (c IpThrottleCriteria) Hit(ctx server.MiddlewareContext) (throttle.Record,
 }
 
 func ThrottlingMiddleware(criteria ThrottleCriteria) server.Middleware {
+	return throttlingMiddleware(criteria, func(request server.Request) {})
+}
+
+// ThrottlingMiddlewareWithMetrics also counts the rejected requests in registry, labeled like MetricsMiddleware.
+func ThrottlingMiddlewareWithMetrics(criteria ThrottleCriteria, registry metrics.Registry) server.Middleware {
+	rejections := registry.Counter("aghs_throttle_rejections_total", "Number of requests rejected by throttling.",
+		"service", "uri_pattern", "method")
+	return throttlingMiddleware(criteria, func(request server.Request) {
+		rejections.Inc(requestLabels(request))
+	})
+}
+
+func throttlingMiddleware(criteria ThrottleCriteria, onRejected func(request server.Request)) server.Middleware {
 	return func(ctx server.MiddlewareContext) {
 		rec, err := criteria.Hit(ctx)
 		defer func() {
@@ -46,6 +60,7 @@ func ThrottlingMiddleware(criteria ThrottleCriteria) server.Middleware {
 			ctx.Response().SetHeader(ThrottleWindowRemainKey, strconv.Itoa(hitRemains))
 		}()
 		if err != nil {
+			onRejected(ctx.Request())
 			ctx.Response().SetCode(http.StatusTooManyRequests)
 			ctx.Response().SetPayload(nil)
 			return
@@ -57,3 +72,8 @@ func ThrottlingMiddleware(criteria ThrottleCriteria) server.Middleware {
 func NewIpThrottlingMiddleware(limit int) server.Middleware {
 	return ThrottlingMiddleware(newIpThrottleCriteria(limit))
 }
+
+// NewIpThrottlingMiddlewareWithMetrics counts the rejected requests in registry.
+func NewIpThrottlingMiddlewareWithMetrics(limit int, registry metrics.Registry) server.Middleware {
+	return ThrottlingMiddlewareWithMetrics(newIpThrottleCriteria(limit), registry)
+}
@@ -1,15 +1,280 @@
 package middlewares
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
 	"github.com/dlshle/aghs/server"
-	"github.com/dlshle/aghs/utils"
 )
 
 const (
-	CtxKeyTraceID = "trace_id"
+	CtxKeyTraceID      = "trace_id"
+	CtxKeySpanID       = "span_id"
+	CtxKeyParentSpanID = "parent_span_id"
+	CtxKeyTraceFlags   = "trace_flags"
+	CtxKeyTraceState   = "trace_state"
+)
+
+const (
+	TraceParentHeader     = "traceparent"
+	TraceStateHeader      = "tracestate"
+	B3Header              = "b3"
+	B3TraceIdHeader       = "X-B3-TraceId"
+	B3SpanIdHeader        = "X-B3-SpanId"
+	B3ParentSpanIdHeader  = "X-B3-ParentSpanId"
+	B3SampledHeader       = "X-B3-Sampled"
+	B3FlagsHeader         = "X-B3-Flags"
+	TraceIdResponseHeader = "X-Trace-Id"
+)
+
+const (
+	traceParentVersion = "00"
+	traceParentLength  = 55
+	flagsSampled       = "01"
+	flagsNotSampled    = "00"
 )
 
+// SpanContext identifies the span of a request within a W3C trace.
+type SpanContext struct {
+	// TraceID is 32 lowercase hex characters
+	TraceID string
+	// SpanID is 16 lowercase hex characters
+	SpanID string
+	// ParentSpanID is the span of the caller, empty if the request started the trace
+	ParentSpanID string
+	Sampled      bool
+	// TraceState is the vendor specific tracestate passed along unchanged
+	TraceState string
+}
+
+// TraceParent formats the span context as a traceparent header value.
+func (c SpanContext) TraceParent() string {
+	flags := flagsNotSampled
+	if c.Sampled {
+		flags = flagsSampled
+	}
+	return traceParentVersion + "-" + c.TraceID + "-" + c.SpanID + "-" + flags
+}
+
+// Inject sets traceparent and tracestate on the header of an outgoing request so that the callee continues the trace.
+func (c SpanContext) Inject(header http.Header) {
+	header.Set(TraceParentHeader, c.TraceParent())
+	if c.TraceState != "" {
+		header.Set(TraceStateHeader, c.TraceState)
+	}
+}
+
+type TracingConfig struct {
+	// B3 continues traces from the b3 or X-B3-* headers when there is no valid traceparent
+	B3 bool
+	// ResponseHeader returns the trace id to the client, defaults to X-Trace-Id
+	ResponseHeader string
+}
+
+var defaultTracingMiddleware = NewTracingMiddleware(TracingConfig{})
+
+// TracingMiddleware continues the W3C trace of the incoming traceparent or starts a new one.
 func TracingMiddleware(ctx server.MiddlewareContext) {
-	ctx.Request().RegisterContext(CtxKeyTraceID, utils.GenerateID())
-	ctx.Next()
+	defaultTracingMiddleware(ctx)
+}
+
+// NewTracingMiddleware creates a child span of the incoming trace for every request, the span context is registered on the
+// request and can be read with RequestSpanContext.
+func NewTracingMiddleware(config TracingConfig) server.Middleware {
+	if config.ResponseHeader == "" {
+		config.ResponseHeader = TraceIdResponseHeader
+	}
+	return func(ctx server.MiddlewareContext) {
+		request := ctx.Request()
+		parent, found := ExtractSpanContext(request.Header(), config.B3)
+		span := newSpanContext(parent, found)
+		request.RegisterContext(CtxKeyTraceID, span.TraceID)
+		request.RegisterContext(CtxKeySpanID, span.SpanID)
+		if span.ParentSpanID != "" {
+			request.RegisterContext(CtxKeyParentSpanID, span.ParentSpanID)
+		}
+		if span.Sampled {
+			request.RegisterContext(CtxKeyTraceFlags, flagsSampled)
+		} else {
+			request.RegisterContext(CtxKeyTraceFlags, flagsNotSampled)
+		}
+		if span.TraceState != "" {
+			request.RegisterContext(CtxKeyTraceState, span.TraceState)
+		}
+		// deferred so that the trace id is sent along with the panics of the following middlewares too, error responses
+		// carry the headers of the response
+		defer func() {
+			ctx.Response().SetHeader(config.ResponseHeader, span.TraceID)
+		}()
+		ctx.Next()
+	}
+}
+
+func newSpanContext(parent SpanContext, hasParent bool) SpanContext {
+	if !hasParent {
+		return SpanContext{
+			TraceID: randomHexID(16),
+			SpanID:  randomHexID(8),
+			Sampled: true,
+		}
+	}
+	return SpanContext{
+		TraceID:      parent.TraceID,
+		SpanID:       randomHexID(8),
+		ParentSpanID: parent.SpanID,
+		Sampled:      parent.Sampled,
+		TraceState:   parent.TraceState,
+	}
+}
+
+// ExtractSpanContext reads the span context of the caller from traceparent and tracestate, and from the B3 headers if b3
+// is set and traceparent is missing or invalid.
+func ExtractSpanContext(header http.Header, b3 bool) (SpanContext, bool) {
+	if spanCtx, ok := parseTraceParent(header.Get(TraceParentHeader)); ok {
+		spanCtx.TraceState = strings.Join(header.Values(TraceStateHeader), ",")
+		return spanCtx, true
+	}
+	if !b3 {
+		return SpanContext{}, false
+	}
+	if value := header.Get(B3Header); value != "" {
+		return parseB3Single(value)
+	}
+	return parseB3Multi(header)
+}
+
+// parseTraceParent parses version-traceid-parentid-flags, fields appended by future versions are ignored
+func parseTraceParent(value string) (SpanContext, bool) {
+	value = strings.TrimSpace(value)
+	if len(value) < traceParentLength {
+		return SpanContext{}, false
+	}
+	version := value[:2]
+	if !isLowerHex(version) || version == "ff" {
+		return SpanContext{}, false
+	}
+	if version == traceParentVersion && len(value) != traceParentLength {
+		return SpanContext{}, false
+	}
+	if len(value) > traceParentLength && value[traceParentLength] != '-' {
+		return SpanContext{}, false
+	}
+	if value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return SpanContext{}, false
+	}
+	traceID, spanID, flags := value[3:35], value[36:52], value[53:55]
+	if !isValidID(traceID) || !isValidID(spanID) || !isLowerHex(flags) {
+		return SpanContext{}, false
+	}
+	flagsValue, _ := hex.DecodeString(flags)
+	return SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: flagsValue[0]&1 == 1,
+	}, true
+}
+
+// parseB3Single parses traceid-spanid-sampled-parentspanid where sampled and parentspanid are optional
+func parseB3Single(value string) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	// a lone sampling state carries no trace to continue
+	if len(parts) < 2 || len(parts) > 4 {
+		return SpanContext{}, false
+	}
+	sampled := "1"
+	if len(parts) > 2 {
+		sampled = parts[2]
+	}
+	return newB3SpanContext(parts[0], parts[1], sampled)
+}
+
+func parseB3Multi(header http.Header) (SpanContext, bool) {
+	sampled := header.Get(B3SampledHeader)
+	if header.Get(B3FlagsHeader) == "1" {
+		sampled = "d"
+	}
+	if sampled == "" {
+		sampled = "1"
+	}
+	return newB3SpanContext(header.Get(B3TraceIdHeader), header.Get(B3SpanIdHeader), sampled)
+}
+
+func newB3SpanContext(traceID, spanID, sampled string) (SpanContext, bool) {
+	traceID, spanID = strings.ToLower(traceID), strings.ToLower(spanID)
+	// 64 bit trace ids are left padded to 128 bits
+	if len(traceID) == 16 {
+		traceID = strings.Repeat("0", 16) + traceID
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || !isValidID(traceID) || !isValidID(spanID) {
+		return SpanContext{}, false
+	}
+	return SpanContext{
+		TraceID: traceID,
+		SpanID:  spanID,
+		Sampled: sampled == "1" || sampled == "d" || sampled == "true",
+	}, true
+}
+
+// isValidID checks that id is lowercase hex and not all zeros
+func isValidID(id string) bool {
+	return isLowerHex(id) && strings.Trim(id, "0") != ""
+}
+
+func isLowerHex(value string) bool {
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return value != ""
+}
+
+func randomHexID(size int) string {
+	id := make([]byte, size)
+	for {
+		rand.Read(id)
+		if encoded := hex.EncodeToString(id); isValidID(encoded) {
+			return encoded
+		}
+	}
+}
+
+// RequestSpanContext returns the span context registered by the tracing middleware.
+func RequestSpanContext(request server.Request) (SpanContext, bool) {
+	return SpanContextFromContext(request.Context())
+}
+
+// SpanContextFromContext returns the span context registered by the tracing middleware on the request context.
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	traceID := contextValue(ctx, CtxKeyTraceID)
+	spanID := contextValue(ctx, CtxKeySpanID)
+	if traceID == "" || spanID == "" {
+		return SpanContext{}, false
+	}
+	return SpanContext{
+		TraceID:      traceID,
+		SpanID:       spanID,
+		ParentSpanID: contextValue(ctx, CtxKeyParentSpanID),
+		Sampled:      contextValue(ctx, CtxKeyTraceFlags) == flagsSampled,
+		TraceState:   contextValue(ctx, CtxKeyTraceState),
+	}, true
+}
+
+// TraceID returns the trace id of the request, empty if the tracing middleware is not installed.
+func TraceID(request server.Request) string {
+	return contextValue(request.Context(), CtxKeyTraceID)
+}
+
+// SpanID returns the span id of the request, empty if the tracing middleware is not installed.
+func SpanID(request server.Request) string {
+	return contextValue(request.Context(), CtxKeySpanID)
+}
+
+func contextValue(ctx context.Context, key string) string {
+	value, _ := ctx.Value(key).(string)
+	return value
 }
@@ -0,0 +1,187 @@
+package middlewares
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+)
+
+const (
+	testTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	testSpanID  = "00f067aa0ba902b7"
+)
+
+func TestExtractTraceParent(t *testing.T) {
+	cases := []struct {
+		name        string
+		traceParent string
+		tracestate  []string
+		ok          bool
+		sampled     bool
+	}{
+		{"sampled", "00-" + testTraceID + "-" + testSpanID + "-01", nil, true, true},
+		{"not sampled", "00-" + testTraceID + "-" + testSpanID + "-00", nil, true, false},
+		{"other flags", "00-" + testTraceID + "-" + testSpanID + "-09", nil, true, true},
+		{"surrounding spaces", " 00-" + testTraceID + "-" + testSpanID + "-01 ", nil, true, true},
+		{"tracestate", "00-" + testTraceID + "-" + testSpanID + "-01", []string{"congo=t61rcWkgMzE", "rojo=00f067aa0ba902b7"}, true, true},
+		{"future version", "cc-" + testTraceID + "-" + testSpanID + "-01", nil, true, true},
+		{"future version with fields", "cc-" + testTraceID + "-" + testSpanID + "-01-what-the-future-holds", nil, true, true},
+		{"empty", "", nil, false, false},
+		{"invalid version", "ff-" + testTraceID + "-" + testSpanID + "-01", nil, false, false},
+		{"uppercase version", "0A-" + testTraceID + "-" + testSpanID + "-01", nil, false, false},
+		{"version 00 with fields", "00-" + testTraceID + "-" + testSpanID + "-01-extra", nil, false, false},
+		{"future version without separator", "cc-" + testTraceID + "-" + testSpanID + "-01.extra", nil, false, false},
+		{"zero trace id", "00-" + strings.Repeat("0", 32) + "-" + testSpanID + "-01", nil, false, false},
+		{"zero span id", "00-" + testTraceID + "-" + strings.Repeat("0", 16) + "-01", nil, false, false},
+		{"uppercase trace id", "00-" + strings.ToUpper(testTraceID) + "-" + testSpanID + "-01", nil, false, false},
+		{"short trace id", "00-" + testTraceID[2:] + "-" + testSpanID + "-0101", nil, false, false},
+		{"invalid flags", "00-" + testTraceID + "-" + testSpanID + "-0x", nil, false, false},
+		{"wrong separators", "00_" + testTraceID + "_" + testSpanID + "_01", nil, false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(TraceParentHeader, c.traceParent)
+			for _, state := range c.tracestate {
+				header.Add(TraceStateHeader, state)
+			}
+			spanCtx, ok := ExtractSpanContext(header, false)
+			if ok != c.ok {
+				t.Fatalf("expected ok to be %v, got %+v", c.ok, spanCtx)
+			}
+			if !ok {
+				return
+			}
+			if spanCtx.TraceID != testTraceID || spanCtx.SpanID != testSpanID || spanCtx.Sampled != c.sampled {
+				t.Fatalf("unexpected span context %+v", spanCtx)
+			}
+			if expected := strings.Join(c.tracestate, ","); spanCtx.TraceState != expected {
+				t.Fatalf("expected tracestate %q, got %q", expected, spanCtx.TraceState)
+			}
+		})
+	}
+}
+
+func TestExtractB3(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  map[string]string
+		traceID string
+		ok      bool
+		sampled bool
+	}{
+		{"single", map[string]string{B3Header: testTraceID + "-" + testSpanID}, testTraceID, true, true},
+		{"single sampled", map[string]string{B3Header: testTraceID + "-" + testSpanID + "-1"}, testTraceID, true, true},
+		{"single not sampled", map[string]string{B3Header: testTraceID + "-" + testSpanID + "-0"}, testTraceID, true, false},
+		{"single debug", map[string]string{B3Header: testTraceID + "-" + testSpanID + "-d"}, testTraceID, true, true},
+		{"single with parent", map[string]string{B3Header: testTraceID + "-" + testSpanID + "-1-05e3ac9a4f6e3b90"}, testTraceID, true, true},
+		{"single 64 bit trace id", map[string]string{B3Header: testTraceID[16:] + "-" + testSpanID}, strings.Repeat("0", 16) + testTraceID[16:], true, true},
+		{"single uppercase", map[string]string{B3Header: strings.ToUpper(testTraceID + "-" + testSpanID)}, testTraceID, true, true},
+		{"single sampling state only", map[string]string{B3Header: "1"}, "", false, false},
+		{"single too many fields", map[string]string{B3Header: testTraceID + "-" + testSpanID + "-1-05e3ac9a4f6e3b90-1"}, "", false, false},
+		{"single zero trace id", map[string]string{B3Header: strings.Repeat("0", 32) + "-" + testSpanID}, "", false, false},
+		{"single short span id", map[string]string{B3Header: testTraceID + "-" + testSpanID[1:]}, "", false, false},
+		{"single malformed", map[string]string{B3Header: testTraceID + "-not-hex-span-id"}, "", false, false},
+		{"multi", map[string]string{B3TraceIdHeader: testTraceID, B3SpanIdHeader: testSpanID}, testTraceID, true, true},
+		{"multi not sampled", map[string]string{B3TraceIdHeader: testTraceID, B3SpanIdHeader: testSpanID, B3SampledHeader: "0"}, testTraceID, true, false},
+		{"multi sampled true", map[string]string{B3TraceIdHeader: testTraceID, B3SpanIdHeader: testSpanID, B3SampledHeader: "true"}, testTraceID, true, true},
+		{"multi debug", map[string]string{B3TraceIdHeader: testTraceID, B3SpanIdHeader: testSpanID, B3SampledHeader: "0", B3FlagsHeader: "1"}, testTraceID, true, true},
+		{"multi missing span id", map[string]string{B3TraceIdHeader: testTraceID}, "", false, false},
+		{"multi zero span id", map[string]string{B3TraceIdHeader: testTraceID, B3SpanIdHeader: strings.Repeat("0", 16)}, "", false, false},
+		{"none", map[string]string{}, "", false, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			header := http.Header{}
+			for key, value := range c.header {
+				header.Set(key, value)
+			}
+			spanCtx, ok := ExtractSpanContext(header, true)
+			if ok != c.ok {
+				t.Fatalf("expected ok to be %v, got %+v", c.ok, spanCtx)
+			}
+			if ok && (spanCtx.TraceID != c.traceID || spanCtx.SpanID != testSpanID || spanCtx.Sampled != c.sampled) {
+				t.Fatalf("unexpected span context %+v", spanCtx)
+			}
+			// B3 is only read when enabled
+			if _, ok := ExtractSpanContext(header, false); ok {
+				t.Fatal("expected the B3 headers to be ignored")
+			}
+		})
+	}
+}
+
+func TestExtractPrefersTraceParent(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceParentHeader, "00-"+testTraceID+"-"+testSpanID+"-01")
+	header.Set(B3Header, strings.Repeat("a", 32)+"-"+strings.Repeat("b", 16))
+	if spanCtx, ok := ExtractSpanContext(header, true); !ok || spanCtx.TraceID != testTraceID {
+		t.Fatalf("expected the traceparent to be continued, got %+v", spanCtx)
+	}
+	// an invalid traceparent falls back to B3
+	header.Set(TraceParentHeader, "00-"+strings.Repeat("0", 32)+"-"+testSpanID+"-01")
+	if spanCtx, ok := ExtractSpanContext(header, true); !ok || spanCtx.TraceID != strings.Repeat("a", 32) {
+		t.Fatalf("expected the B3 trace to be continued, got %+v", spanCtx)
+	}
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	var spanCtx SpanContext
+	service := server.NewServiceBuilder().Id("traced").
+		WithRouteHandlers(server.PathHandlerBuilder("/traced").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			spanCtx, _ = RequestSpanContext(r)
+			return server.NewResponse(http.StatusOK, "ok"), nil
+		})).
+		MustBuild()
+	svr := servertest.NewForService(t, service, TracingMiddleware)
+	svr.Get("/traced").
+		Header(TraceParentHeader, "00-"+testTraceID+"-"+testSpanID+"-00").
+		Header(TraceStateHeader, "congo=t61rcWkgMzE").
+		Do().AssertStatus(http.StatusOK).AssertHeader(TraceIdResponseHeader, testTraceID)
+	if spanCtx.TraceID != testTraceID || spanCtx.ParentSpanID != testSpanID || !isValidID(spanCtx.SpanID) || spanCtx.SpanID == testSpanID ||
+		spanCtx.Sampled || spanCtx.TraceState != "congo=t61rcWkgMzE" {
+		t.Fatalf("unexpected child span %+v", spanCtx)
+	}
+	if expected := "00-" + testTraceID + "-" + spanCtx.SpanID + "-00"; spanCtx.TraceParent() != expected {
+		t.Fatalf("expected traceparent %s, got %s", expected, spanCtx.TraceParent())
+	}
+	// a new sampled trace is started without a valid traceparent
+	resp := svr.Get("/traced").Header(TraceParentHeader, "garbage").Do().AssertStatus(http.StatusOK)
+	if traceID := resp.Header().Get(TraceIdResponseHeader); traceID != spanCtx.TraceID || !isValidID(traceID) || len(traceID) != 32 ||
+		spanCtx.ParentSpanID != "" || !spanCtx.Sampled || traceID == testTraceID {
+		t.Fatalf("unexpected new trace %s %+v", traceID, spanCtx)
+	}
+}
+
+func TestTracingMiddlewareOnErrors(t *testing.T) {
+	service := server.NewServiceBuilder().Id("traced").
+		WithRouteHandlers(server.PathHandlerBuilder("/fail").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return nil, server.InternalError("database is down")
+		})).
+		WithRouteHandlers(server.PathHandlerBuilder("/panic").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewResponse(http.StatusOK, "ok"), nil
+		})).
+		MustBuild()
+	panicking := func(ctx server.MiddlewareContext) {
+		ctx.Next()
+		if ctx.Request().Path() == "/panic" {
+			panic("middleware failure")
+		}
+	}
+	svr := servertest.NewForService(t, service, TracingMiddleware, panicking)
+	for _, c := range []struct {
+		path   string
+		status int
+	}{
+		{"/fail", http.StatusInternalServerError},
+		{"/missing", http.StatusNotFound},
+		{"/panic", http.StatusInternalServerError},
+	} {
+		svr.Get(c.path).
+			Header(TraceParentHeader, "00-"+testTraceID+"-"+testSpanID+"-01").
+			Do().AssertStatus(c.status).AssertHeader(TraceIdResponseHeader, testTraceID)
+	}
+}
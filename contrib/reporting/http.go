@@ -0,0 +1,176 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/gommon/logging"
+)
+
+const (
+	defaultBatchSize     = 50
+	defaultFlushInterval = time.Second * 5
+	defaultQueueSize     = 1000
+	defaultHTTPTimeout   = time.Second * 10
+)
+
+// BatchEncoder encodes a batch of events into the request body.
+type BatchEncoder func(events []server.ErrorEvent) (body []byte, contentType string, err error)
+
+type HTTPReporterConfig struct {
+	// Endpoint receives the batches as POST requests
+	Endpoint string
+	// Header is added to every request, e.g. for authentication
+	Header        http.Header
+	BatchSize     int
+	FlushInterval time.Duration
+	// QueueSize bounds the events waiting to be sent, new events are dropped when the queue is full and their number is
+	// logged at most once per FlushInterval
+	QueueSize int
+	Client    *http.Client
+	// Encoder encodes the batch as a JSON array by default
+	Encoder BatchEncoder
+	Logger  logging.Logger
+}
+
+// HTTPReporter sends the error events in batches from a background goroutine, Report never blocks.
+type HTTPReporter struct {
+	config HTTPReporterConfig
+	queue  chan server.ErrorEvent
+	done   chan struct{}
+	lock   sync.RWMutex
+	closed bool
+	// dropped counts the events dropped on a full queue since the last warning
+	dropped atomic.Int64
+}
+
+func NewHTTPReporter(config HTTPReporterConfig) *HTTPReporter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	if config.Encoder == nil {
+		config.Encoder = encodeJSONArray
+	}
+	if config.Logger == nil {
+		config.Logger = logging.GlobalLogger.WithPrefix("[HTTPReporter]")
+	}
+	reporter := &HTTPReporter{
+		config: config,
+		queue:  make(chan server.ErrorEvent, config.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go reporter.run()
+	return reporter
+}
+
+func (r *HTTPReporter) Report(event server.ErrorEvent) {
+	// the request is recycled once Report returns
+	event.Request = nil
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.queue <- event:
+	default:
+		// logged by run, a warning per event would flood the logs when overloaded
+		r.dropped.Add(1)
+	}
+}
+
+// Close sends the queued events and stops the reporter, events reported after Close are dropped.
+func (r *HTTPReporter) Close() error {
+	r.lock.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.lock.Unlock()
+	<-r.done
+	return nil
+}
+
+func (r *HTTPReporter) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(r.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]server.ErrorEvent, 0, r.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := r.send(batch); err != nil {
+			r.config.Logger.Errorf(context.Background(), "failed to send %d error events: %s", len(batch), err.Error())
+		}
+		batch = batch[:0]
+	}
+	defer r.warnDropped()
+	for {
+		select {
+		case event, ok := <-r.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, event)
+			if len(batch) >= r.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			r.warnDropped()
+		}
+	}
+}
+
+func (r *HTTPReporter) warnDropped() {
+	if dropped := r.dropped.Swap(0); dropped > 0 {
+		r.config.Logger.Warnf(context.Background(), "error event queue is full, dropped %d events", dropped)
+	}
+}
+
+func (r *HTTPReporter) send(events []server.ErrorEvent) error {
+	body, contentType, err := r.config.Encoder(events)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, r.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range r.config.Header {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", contentType)
+	resp, err := r.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func encodeJSONArray(events []server.ErrorEvent) ([]byte, string, error) {
+	body, err := json.Marshal(events)
+	return body, "application/json", err
+}
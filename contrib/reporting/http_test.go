@@ -0,0 +1,141 @@
+package reporting
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/gommon/logging"
+)
+
+// batchEndpoint collects the batches posted to it, each request waits for release first
+type batchEndpoint struct {
+	*httptest.Server
+	batches  chan []server.ErrorEvent
+	received chan struct{}
+	release  chan struct{}
+}
+
+func newBatchEndpoint(t *testing.T, blocking bool) *batchEndpoint {
+	endpoint := &batchEndpoint{
+		batches:  make(chan []server.ErrorEvent, 16),
+		received: make(chan struct{}, 16),
+		release:  make(chan struct{}),
+	}
+	if !blocking {
+		close(endpoint.release)
+	}
+	endpoint.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint.received <- struct{}{}
+		<-endpoint.release
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("X-Token") != "secret" {
+			t.Errorf("unexpected header %v", r.Header)
+		}
+		var batch []server.ErrorEvent
+		if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+			t.Errorf("invalid batch: %v", err)
+		}
+		endpoint.batches <- batch
+	}))
+	t.Cleanup(endpoint.Close)
+	return endpoint
+}
+
+func (e *batchEndpoint) nextBatch(t *testing.T) []server.ErrorEvent {
+	select {
+	case batch := <-e.batches:
+		return batch
+	case <-time.After(time.Second * 5):
+		t.Fatal("no batch was sent")
+		return nil
+	}
+}
+
+func TestHTTPReporterBatches(t *testing.T) {
+	endpoint := newBatchEndpoint(t, false)
+	reporter := NewHTTPReporter(HTTPReporterConfig{
+		Endpoint:      endpoint.URL,
+		Header:        http.Header{"X-Token": []string{"secret"}},
+		BatchSize:     2,
+		FlushInterval: time.Millisecond * 50,
+	})
+	for i := 0; i < 3; i++ {
+		reporter.Report(server.ErrorEvent{Code: http.StatusInternalServerError, URI: "/fail"})
+	}
+	// a full batch is sent right away and the rest on the next tick
+	if batch := endpoint.nextBatch(t); len(batch) != 2 || batch[0].URI != "/fail" {
+		t.Fatalf("unexpected batch %+v", batch)
+	}
+	if batch := endpoint.nextBatch(t); len(batch) != 1 {
+		t.Fatalf("unexpected batch %+v", batch)
+	}
+	// Close sends the events still queued
+	reporter.Report(server.ErrorEvent{Code: http.StatusBadGateway})
+	if err := reporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case batch := <-endpoint.batches:
+		if len(batch) != 1 || batch[0].Code != http.StatusBadGateway {
+			t.Fatalf("unexpected batch %+v", batch)
+		}
+	default:
+		t.Fatal("the queued event was not sent on Close")
+	}
+	// dropped once closed
+	reporter.Report(server.ErrorEvent{Code: http.StatusInternalServerError})
+}
+
+// syncBuffer collects the log lines written from the reporter goroutine
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
+
+func TestHTTPReporterCountsDroppedEvents(t *testing.T) {
+	endpoint := newBatchEndpoint(t, true)
+	logs := new(syncBuffer)
+	reporter := NewHTTPReporter(HTTPReporterConfig{
+		Endpoint:      endpoint.URL,
+		Header:        http.Header{"X-Token": []string{"secret"}},
+		BatchSize:     1,
+		QueueSize:     1,
+		FlushInterval: time.Millisecond * 20,
+		Logger:        logging.NewLevelLogger(logs, "[HTTPReporter]", log.Ldate|log.Ltime, logging.TRACE),
+	})
+	reporter.Report(server.ErrorEvent{URI: "/1"})
+	// the first event is being sent, the second one waits in the queue and the rest are dropped
+	<-endpoint.received
+	for i := 2; i <= 5; i++ {
+		reporter.Report(server.ErrorEvent{URI: "/" + string(rune('0'+i))})
+	}
+	close(endpoint.release)
+	if err := reporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if first, second := endpoint.nextBatch(t), endpoint.nextBatch(t); first[0].URI != "/1" || second[0].URI != "/2" {
+		t.Fatalf("unexpected batches %+v %+v", first, second)
+	}
+	if output := logs.String(); strings.Count(output, "dropped") != 1 || !strings.Contains(output, "dropped 3 events") {
+		t.Fatalf("expected one warning about the 3 dropped events, got %q", output)
+	}
+}
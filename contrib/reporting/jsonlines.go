@@ -0,0 +1,60 @@
+package reporting
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/dlshle/aghs/server"
+)
+
+// JSONLinesReporter writes every error event as one JSON line.
+type JSONLinesReporter struct {
+	lock   *sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+func NewJSONLinesReporter(writer io.Writer) JSONLinesReporter {
+	return JSONLinesReporter{
+		lock:   new(sync.Mutex),
+		writer: writer,
+	}
+}
+
+// NewFileReporter appends the error events to the file at path, the file is closed with the reporter.
+func NewFileReporter(path string) (JSONLinesReporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return JSONLinesReporter{}, err
+	}
+	reporter := NewJSONLinesReporter(file)
+	reporter.closer = file
+	return reporter, nil
+}
+
+func (r JSONLinesReporter) Report(event server.ErrorEvent) {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	line = append(line, '\n')
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.writer.Write(line)
+}
+
+func (r JSONLinesReporter) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closer == nil {
+		return nil
+	}
+	err := r.closer.Close()
+	if errors.Is(err, os.ErrClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package reporting
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+)
+
+func TestJSONLinesReporter(t *testing.T) {
+	var buffer bytes.Buffer
+	reporter := NewJSONLinesReporter(&buffer)
+	reporter.Report(server.ErrorEvent{Code: http.StatusInternalServerError, Message: "database\nis down", URI: "/fail"})
+	reporter.Report(server.ErrorEvent{Code: http.StatusInternalServerError, Panic: true, Context: map[string]string{"trace_id": "abc"}})
+	if err := reporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	events := decodeLines(t, buffer.Bytes())
+	if len(events) != 2 || events[0].Message != "database\nis down" || events[0].URI != "/fail" ||
+		!events[1].Panic || events[1].Context["trace_id"] != "abc" {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func TestFileReporterAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.jsonl")
+	for i := 0; i < 2; i++ {
+		reporter, err := NewFileReporter(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reporter.Report(server.ErrorEvent{Code: http.StatusInternalServerError + i})
+		if err := reporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// closing twice is fine as the server closes the reporters it is given
+		if err := reporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := decodeLines(t, data)
+	if len(events) != 2 || events[0].Code != http.StatusInternalServerError || events[1].Code != http.StatusNotImplemented {
+		t.Fatalf("unexpected events %+v", events)
+	}
+}
+
+func decodeLines(t *testing.T, data []byte) []server.ErrorEvent {
+	var events []server.ErrorEvent
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var event server.ErrorEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
@@ -0,0 +1,230 @@
+package spans
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dlshle/aghs/contrib/middlewares"
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/gommon/logging"
+)
+
+const (
+	defaultServiceName   = "aghs"
+	defaultBatchSize     = 512
+	defaultFlushInterval = time.Second * 5
+	defaultQueueSize     = 2048
+	defaultHTTPTimeout   = time.Second * 10
+)
+
+// SpanData is a span resolved into the trace of its request.
+type SpanData struct {
+	TraceID      string
+	SpanID       string
+	ParentSpanID string
+	Name         string
+	// Server is set on the request span, the others are internal spans
+	Server     bool
+	Start      time.Time
+	Duration   time.Duration
+	Attributes map[string]string
+	Error      bool
+}
+
+// SpanEncoder encodes a batch of spans into the request body.
+type SpanEncoder func(spans []SpanData) (body []byte, contentType string, err error)
+
+type HTTPExporterConfig struct {
+	// Endpoint receives the batches as POST requests, it defaults to the local collector of the format
+	Endpoint string
+	// Header is added to every request, e.g. for authentication
+	Header http.Header
+	// ServiceName is reported as the service of the spans, defaults to aghs
+	ServiceName string
+	// BatchSize is the number of spans sent in one request
+	BatchSize     int
+	FlushInterval time.Duration
+	// QueueSize bounds the requests waiting to be sent, new requests are dropped when the queue is full
+	QueueSize int
+	Client    *http.Client
+	Logger    logging.Logger
+}
+
+// HTTPExporter sends the spans in batches from a background goroutine, Export never blocks. Requests the tracing
+// middleware has not sampled are not exported.
+type HTTPExporter struct {
+	config  HTTPExporterConfig
+	encoder SpanEncoder
+	queue   chan []SpanData
+	done    chan struct{}
+	lock    sync.RWMutex
+	closed  bool
+}
+
+func newHTTPExporter(config HTTPExporterConfig, encoder SpanEncoder) *HTTPExporter {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = defaultFlushInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.Client == nil {
+		config.Client = &http.Client{Timeout: defaultHTTPTimeout}
+	}
+	if config.Logger == nil {
+		config.Logger = logging.GlobalLogger.WithPrefix("[SpanExporter]")
+	}
+	exporter := &HTTPExporter{
+		config:  config,
+		encoder: encoder,
+		queue:   make(chan []SpanData, config.QueueSize),
+		done:    make(chan struct{}),
+	}
+	go exporter.run()
+	return exporter
+}
+
+func (e *HTTPExporter) Export(requestSpans server.RequestSpans) {
+	if requestSpans.Context[middlewares.CtxKeyTraceFlags] == "00" {
+		return
+	}
+	spans := ResolveSpans(requestSpans)
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.closed {
+		return
+	}
+	select {
+	case e.queue <- spans:
+	default:
+		e.config.Logger.Warnf(context.Background(), "span queue is full, dropping spans of %s", requestSpans.URI)
+	}
+}
+
+// Close sends the queued spans and stops the exporter, spans exported after Close are dropped.
+func (e *HTTPExporter) Close() error {
+	e.lock.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.lock.Unlock()
+	<-e.done
+	return nil
+}
+
+func (e *HTTPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.config.FlushInterval)
+	defer ticker.Stop()
+	batch := make([]SpanData, 0, e.config.BatchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.config.Logger.Errorf(context.Background(), "failed to send %d spans: %s", len(batch), err.Error())
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case spans, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, spans...)
+			if len(batch) >= e.config.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+func (e *HTTPExporter) send(spans []SpanData) error {
+	body, contentType, err := e.encoder(spans)
+	if err != nil {
+		return err
+	}
+	request, err := http.NewRequest(http.MethodPost, e.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for key, values := range e.config.Header {
+		request.Header[key] = values
+	}
+	request.Header.Set("Content-Type", contentType)
+	resp, err := e.config.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// ResolveSpans places the spans of a request into its trace. The request span continues the trace and span ids
+// registered by the tracing middleware, new ids are generated without it.
+func ResolveSpans(requestSpans server.RequestSpans) []SpanData {
+	ctx := requestSpans.Context
+	traceID := ctx[middlewares.CtxKeyTraceID]
+	if len(traceID) != 32 {
+		traceID = randomHexID(16)
+	}
+	if len(requestSpans.Spans) == 0 {
+		return nil
+	}
+	resolved := make([]SpanData, len(requestSpans.Spans))
+	for i, span := range requestSpans.Spans {
+		resolved[i] = SpanData{
+			TraceID:  traceID,
+			SpanID:   randomHexID(8),
+			Name:     span.Name,
+			Start:    span.Start,
+			Duration: span.Duration,
+		}
+		if i == 0 && len(ctx[middlewares.CtxKeySpanID]) == 16 {
+			resolved[i].SpanID = ctx[middlewares.CtxKeySpanID]
+		}
+		if span.Parent >= 0 {
+			resolved[i].ParentSpanID = resolved[span.Parent].SpanID
+		}
+	}
+	root := &resolved[0]
+	root.ParentSpanID = ctx[middlewares.CtxKeyParentSpanID]
+	root.Server = true
+	root.Error = requestSpans.Status >= http.StatusInternalServerError
+	root.Attributes = map[string]string{
+		"http.method":      requestSpans.Method,
+		"http.target":      requestSpans.URI,
+		"http.status_code": strconv.Itoa(requestSpans.Status),
+	}
+	if requestSpans.UriPattern != "" {
+		root.Attributes["http.route"] = requestSpans.UriPattern
+	}
+	if requestSpans.ServiceId != "" {
+		root.Attributes["aghs.service_id"] = requestSpans.ServiceId
+	}
+	return resolved
+}
+
+func randomHexID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
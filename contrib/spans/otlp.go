@@ -0,0 +1,121 @@
+package spans
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+)
+
+const DefaultOTLPEndpoint = "http://localhost:4318/v1/traces"
+
+const (
+	otlpSpanKindInternal = 1
+	otlpSpanKindServer   = 2
+	otlpStatusError      = 2
+)
+
+// NewOTLPExporter exports the spans as OTLP/JSON to an OpenTelemetry collector.
+func NewOTLPExporter(config HTTPExporterConfig) *HTTPExporter {
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultOTLPEndpoint
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultServiceName
+	}
+	return newHTTPExporter(config, OTLPEncoder(config.ServiceName))
+}
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+// OTLPEncoder encodes the spans as an OTLP/JSON ExportTraceServiceRequest, ids are hex encoded as the JSON mapping requires.
+func OTLPEncoder(serviceName string) SpanEncoder {
+	return func(spans []SpanData) ([]byte, string, error) {
+		encoded := make([]otlpSpan, len(spans))
+		for i, span := range spans {
+			encoded[i] = otlpSpan{
+				TraceID:           span.TraceID,
+				SpanID:            span.SpanID,
+				ParentSpanID:      span.ParentSpanID,
+				Name:              span.Name,
+				Kind:              otlpSpanKindInternal,
+				StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+				EndTimeUnixNano:   strconv.FormatInt(span.Start.Add(span.Duration).UnixNano(), 10),
+				Attributes:        otlpAttributes(span.Attributes),
+			}
+			if span.Server {
+				encoded[i].Kind = otlpSpanKindServer
+			}
+			if span.Error {
+				encoded[i].Status = &otlpStatus{Code: otlpStatusError}
+			}
+		}
+		body, err := json.Marshal(otlpRequest{
+			ResourceSpans: []otlpResourceSpans{{
+				Resource: otlpResource{
+					Attributes: otlpAttributes(map[string]string{"service.name": serviceName}),
+				},
+				ScopeSpans: []otlpScopeSpans{{
+					Scope: otlpScope{Name: "github.com/dlshle/aghs"},
+					Spans: encoded,
+				}},
+			}},
+		})
+		return body, "application/json", err
+	}
+}
+
+func otlpAttributes(attributes map[string]string) []otlpAttribute {
+	encoded := make([]otlpAttribute, 0, len(attributes))
+	for key, value := range attributes {
+		encoded = append(encoded, otlpAttribute{Key: key, Value: otlpAnyValue{StringValue: value}})
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return encoded[i].Key < encoded[j].Key
+	})
+	return encoded
+}
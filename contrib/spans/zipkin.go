@@ -0,0 +1,63 @@
+package spans
+
+import "encoding/json"
+
+const DefaultZipkinEndpoint = "http://localhost:9411/api/v2/spans"
+
+// NewZipkinExporter exports the spans as Zipkin v2 JSON to a Zipkin compatible collector.
+func NewZipkinExporter(config HTTPExporterConfig) *HTTPExporter {
+	if config.Endpoint == "" {
+		config.Endpoint = DefaultZipkinEndpoint
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultServiceName
+	}
+	return newHTTPExporter(config, ZipkinEncoder(config.ServiceName))
+}
+
+type zipkinSpan struct {
+	TraceID       string            `json:"traceId"`
+	ID            string            `json:"id"`
+	ParentID      string            `json:"parentId,omitempty"`
+	Name          string            `json:"name"`
+	Kind          string            `json:"kind,omitempty"`
+	Timestamp     int64             `json:"timestamp"`
+	Duration      int64             `json:"duration"`
+	LocalEndpoint zipkinEndpoint    `json:"localEndpoint"`
+	Tags          map[string]string `json:"tags,omitempty"`
+}
+
+type zipkinEndpoint struct {
+	ServiceName string `json:"serviceName"`
+}
+
+// ZipkinEncoder encodes the spans as a Zipkin v2 JSON array, timestamps and durations are in microseconds.
+func ZipkinEncoder(serviceName string) SpanEncoder {
+	return func(spans []SpanData) ([]byte, string, error) {
+		encoded := make([]zipkinSpan, len(spans))
+		for i, span := range spans {
+			encoded[i] = zipkinSpan{
+				TraceID:       span.TraceID,
+				ID:            span.SpanID,
+				ParentID:      span.ParentSpanID,
+				Name:          span.Name,
+				Timestamp:     span.Start.UnixMicro(),
+				Duration:      span.Duration.Microseconds(),
+				LocalEndpoint: zipkinEndpoint{ServiceName: serviceName},
+				Tags:          span.Attributes,
+			}
+			if span.Server {
+				encoded[i].Kind = "SERVER"
+			}
+			if span.Error {
+				tags := map[string]string{"error": "true"}
+				for key, value := range span.Attributes {
+					tags[key] = value
+				}
+				encoded[i].Tags = tags
+			}
+		}
+		body, err := json.Marshal(encoded)
+		return body, "application/json", err
+	}
+}
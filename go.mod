@@ -1,6 +1,6 @@
 module github.com/dlshle/aghs
 
-go 1.22
+go 1.23
 
 toolchain go1.24.4
 
@@ -8,10 +8,12 @@ require (
 	github.com/dlshle/gommon v0.5.32
 	github.com/golang-jwt/jwt v3.2.2+incompatible
 	github.com/valyala/fasthttp v1.33.0
+	golang.org/x/net v0.35.0
 )
 
 require (
 	github.com/andybalholm/brotli v1.0.4 // indirect
 	github.com/klauspost/compress v1.14.1 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	golang.org/x/text v0.22.0 // indirect
 )
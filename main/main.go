@@ -3,7 +3,9 @@ package main
 import (
 	"strconv"
 	"sync/atomic"
+	"time"
 
+	"github.com/dlshle/aghs/contrib/health"
 	"github.com/dlshle/aghs/contrib/middlewares"
 	"github.com/dlshle/aghs/server"
 	"github.com/dlshle/gommon/logging"
@@ -15,7 +17,12 @@ func main() {
 		Logger(logging.GlobalLogger.WithWaterMark(logging.INFO)).
 		Engine(server.NetEngine).
 		Address("0.0.0.0:1234").
+		ShutdownOnSignals(time.Second * 30).
+		RestartOnSignals(time.Second * 30).
 		WithService(NewStudentService()).
+		AddListener(server.NewBuilder().
+			Address("127.0.0.1:1235").
+			WithService(health.NewHealthCheckService("/health"))).
 		WithMiddleware(middlewares.CORSAllowWildcardMiddleware).
 		WithMiddleware(func(ctx server.MiddlewareContext) {
 			atomic.AddUint32(&requestCounter, 1)
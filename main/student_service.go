@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
+	"strings"
 
 	"github.com/dlshle/aghs/server"
 	"github.com/dlshle/aghs/store"
@@ -20,6 +22,7 @@ const (
 	routeStudents     = "/students"
 	routeStudentByID  = "/students/:sid"
 	routeStudentLogin = "/students/login"
+	routeStudentsBulk = "/students/bulk"
 	routeStatus       = "/status"
 	routeFiles        = "/files"
 )
@@ -43,6 +46,10 @@ func NewStudentService() StudentService {
 				Get(studentService.handleGetAllStudents).
 				Post(studentService.handleAddStudent).
 				Build()).
+		WithRouteHandlers(
+			server.PathHandlerBuilder(routeStudentsBulk).
+				Post(studentService.handleBulkAddStudents).
+				Build()).
 		WithRouteHandlers(
 			server.PathHandlerBuilder(routeStudentLogin).
 				Post(studentService.handleLogin).
@@ -76,9 +83,27 @@ func (s StudentService) handleGetAllStudents(r server.Request) (server.Response,
 	students, _ := s.studentStore.Query(func(interface{}) bool {
 		return true
 	})
+	if strings.Contains(r.Header().Get("Accept"), server.NDJSONContentType) {
+		return server.NewNDJSONResponse(r, 200, slices.Values(students)), nil
+	}
 	return server.NewResponse(200, students), nil
 }
 
+func (s StudentService) handleBulkAddStudents(r server.Request) (server.Response, server.ServiceError) {
+	var added []Student
+	for student, err := range server.DecodeNDJSON[Student](r, 0) {
+		if err != nil {
+			return nil, server.BadRequestError(err.Error())
+		}
+		newStudent, err := s.addStudent(student)
+		if err != nil {
+			return nil, server.BadRequestError(err.Error())
+		}
+		added = append(added, newStudent)
+	}
+	return server.NewResponse(201, added), nil
+}
+
 func (s StudentService) handleGetStudent(r server.Request) (server.Response, server.ServiceError) {
 	studentId := r.PathParams()["sid"]
 	if studentId == "" {
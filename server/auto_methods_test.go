@@ -0,0 +1,83 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+)
+
+func newStudentsService(configure func(server.ServiceBuilder) server.ServiceBuilder) server.Service {
+	builder := server.NewServiceBuilder().
+		Id("students").
+		WithRouteHandlers(server.PathHandlerBuilder("/students").
+			Get(func(r server.Request) (server.Response, server.ServiceError) {
+				resp := server.NewPlainTextResponse(http.StatusOK, "ann,bob")
+				resp.SetHeader("X-Total", "2")
+				return resp, nil
+			}).
+			Post(func(r server.Request) (server.Response, server.ServiceError) {
+				return server.NewResponse(http.StatusCreated, nil), nil
+			})).
+		WithRouteHandlers(server.PathHandlerBuilder("/students/:id").
+			Delete(func(r server.Request) (server.Response, server.ServiceError) {
+				return server.NewResponse(http.StatusNoContent, nil), nil
+			}))
+	if configure != nil {
+		builder = configure(builder)
+	}
+	return builder.MustBuild()
+}
+
+func TestAutoHead(t *testing.T) {
+	svr := servertest.NewForService(t, newStudentsService(nil))
+	resp := svr.Request(http.MethodHead, "/students").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Total", "2").
+		AssertHeader("Content-Length", "7").
+		AssertBody("")
+	if resp.Header().Get("Content-Type") == "" {
+		t.Fatal("expected the content type of the GET response")
+	}
+	// routes without GET are not answered
+	svr.Request(http.MethodHead, "/students/1").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "DELETE, OPTIONS")
+}
+
+func TestAutoOptions(t *testing.T) {
+	svr := servertest.NewForService(t, newStudentsService(nil))
+	svr.Request(http.MethodOptions, "/students").Do().
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Allow", "GET, HEAD, OPTIONS, POST").
+		AssertBody("")
+	svr.Request(http.MethodOptions, "/students/1").Do().
+		AssertStatus(http.StatusNoContent).
+		AssertHeader("Allow", "DELETE, OPTIONS")
+}
+
+func TestMethodNotAllowedAllowHeader(t *testing.T) {
+	svr := servertest.NewForService(t, newStudentsService(nil))
+	svr.Put("/students").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "GET, HEAD, OPTIONS, POST")
+	svr.Get("/students/1").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "DELETE, OPTIONS")
+}
+
+func TestAutoMethodsOptOut(t *testing.T) {
+	svr := servertest.NewForService(t, newStudentsService(func(b server.ServiceBuilder) server.ServiceBuilder {
+		return b.AutoHead(false).AutoOptions(false).AllowHeader(false)
+	}))
+	svr.Request(http.MethodHead, "/students").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertNoHeader("Allow")
+	svr.Request(http.MethodOptions, "/students").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertNoHeader("Allow")
+	svr.Put("/students").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertNoHeader("Allow")
+}
@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlshle/gommon/logging"
+)
+
+const DefaultCertificatePollInterval = time.Second * 30
+
+// CertificateManager serves certificates by SNI and reloads the ones loaded from files when they change on disk.
+type CertificateManager struct {
+	ctx          context.Context
+	logger       logging.Logger
+	pollInterval time.Duration
+	lock         *sync.RWMutex
+	reloadLock   *sync.Mutex
+	files        []*certificateFiles
+	static       []*tls.Certificate
+	nameMap      map[string]*tls.Certificate
+	defaultCert  *tls.Certificate
+	stopChan     chan struct{}
+}
+
+type certificateFiles struct {
+	certFile   string
+	keyFile    string
+	modTime    time.Time
+	keyModTime time.Time
+	size       int64
+	cert       *tls.Certificate
+	certNames  []string
+}
+
+func NewCertificateManager(pollInterval time.Duration) *CertificateManager {
+	if pollInterval <= 0 {
+		pollInterval = DefaultCertificatePollInterval
+	}
+	return &CertificateManager{
+		ctx:          context.Background(),
+		logger:       logging.GlobalLogger.WithPrefix("[CertificateManager]"),
+		pollInterval: pollInterval,
+		lock:         new(sync.RWMutex),
+		reloadLock:   new(sync.Mutex),
+		nameMap:      make(map[string]*tls.Certificate),
+	}
+}
+
+// AddCertificateFiles loads the key pair and keeps watching both files for changes.
+func (m *CertificateManager) AddCertificateFiles(certFile, keyFile string) error {
+	files := &certificateFiles{certFile: certFile, keyFile: keyFile}
+	if err := files.load(); err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.files = append(m.files, files)
+	m.rebuildNameMapWithoutLock()
+	return nil
+}
+
+func (m *CertificateManager) AddCertificate(cert tls.Certificate) error {
+	if len(cert.Certificate) == 0 {
+		return fmt.Errorf("certificate has an empty chain")
+	}
+	if cert.Leaf == nil {
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+		cert.Leaf = leaf
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.static = append(m.static, &cert)
+	m.rebuildNameMapWithoutLock()
+	return nil
+}
+
+// GetCertificate picks the certificate by the SNI server name, it is meant to be used as tls.Config.GetCertificate.
+func (m *CertificateManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	name := strings.TrimSuffix(strings.ToLower(hello.ServerName), ".")
+	if cert, exists := m.nameMap[name]; exists {
+		return cert, nil
+	}
+	if dot := strings.IndexByte(name, '.'); dot > 0 {
+		if cert, exists := m.nameMap["*"+name[dot:]]; exists {
+			return cert, nil
+		}
+	}
+	if m.defaultCert == nil {
+		return nil, fmt.Errorf("no certificate available for server name %s", hello.ServerName)
+	}
+	return m.defaultCert, nil
+}
+
+// Reload reloads every certificate whose files have changed since the last load.
+func (m *CertificateManager) Reload() error {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+	m.lock.RLock()
+	files, ctx, logger := m.files, m.ctx, m.logger
+	m.lock.RUnlock()
+	var lastErr error
+	reloaded := false
+	for _, f := range files {
+		changed, err := f.changed()
+		if err != nil {
+			logger.Errorf(ctx, "unable to check certificate %s: %s", f.certFile, err.Error())
+			lastErr = err
+			continue
+		}
+		if !changed {
+			continue
+		}
+		// loaded out of the lock so that a slow disk doesn't stall the handshakes
+		loaded := &certificateFiles{certFile: f.certFile, keyFile: f.keyFile}
+		if err = loaded.load(); err != nil {
+			// keep serving the previous certificate until the files are fixed
+			logger.Errorf(ctx, "failed to reload certificate %s: %s", f.certFile, err.Error())
+			lastErr = err
+			continue
+		}
+		m.lock.Lock()
+		*f = *loaded
+		m.lock.Unlock()
+		logger.Infof(ctx, "reloaded certificate %s for %s", f.certFile, strings.Join(f.certNames, ", "))
+		reloaded = true
+	}
+	if reloaded {
+		m.lock.Lock()
+		m.rebuildNameMapWithoutLock()
+		m.lock.Unlock()
+	}
+	return lastErr
+}
+
+// setLogger makes the manager log with the logger of the server using it
+func (m *CertificateManager) setLogger(ctx context.Context, logger logging.Logger) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.ctx, m.logger = ctx, logger
+}
+
+func (m *CertificateManager) startWatching() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopChan != nil {
+		return
+	}
+	m.stopChan = make(chan struct{})
+	go m.watch(m.stopChan)
+}
+
+func (m *CertificateManager) stopWatching() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.stopChan == nil {
+		return
+	}
+	close(m.stopChan)
+	m.stopChan = nil
+}
+
+func (m *CertificateManager) watch(stopChan chan struct{}) {
+	ticker := time.NewTicker(m.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.Reload()
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+func (m *CertificateManager) rebuildNameMapWithoutLock() {
+	m.nameMap = make(map[string]*tls.Certificate)
+	m.defaultCert = nil
+	certs := make([]*tls.Certificate, 0, len(m.files)+len(m.static))
+	for _, f := range m.files {
+		certs = append(certs, f.cert)
+	}
+	certs = append(certs, m.static...)
+	for _, cert := range certs {
+		if m.defaultCert == nil {
+			m.defaultCert = cert
+		}
+		for _, name := range certificateNames(cert.Leaf) {
+			if _, exists := m.nameMap[name]; !exists {
+				m.nameMap[name] = cert
+			}
+		}
+	}
+}
+
+func (f *certificateFiles) load() error {
+	stat, err := os.Stat(f.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := os.Stat(f.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s: %v", f.certFile, err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return err
+	}
+	cert.Leaf = leaf
+	f.cert = &cert
+	f.certNames = certificateNames(leaf)
+	f.modTime = stat.ModTime()
+	f.keyModTime = keyStat.ModTime()
+	f.size = stat.Size()
+	return nil
+}
+
+func (f *certificateFiles) changed() (bool, error) {
+	certStat, err := os.Stat(f.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyStat, err := os.Stat(f.keyFile)
+	if err != nil {
+		return false, err
+	}
+	// a rollback to older files changes the mod time too
+	return certStat.Size() != f.size || !certStat.ModTime().Equal(f.modTime) || !keyStat.ModTime().Equal(f.keyModTime), nil
+}
+
+func certificateNames(leaf *x509.Certificate) []string {
+	var names []string
+	for _, name := range leaf.DNSNames {
+		names = append(names, strings.ToLower(name))
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return names
+}
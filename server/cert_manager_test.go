@@ -0,0 +1,137 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCertificateFiles writes a self-signed key pair for hosts, the files get modTime
+func writeCertificateFiles(t *testing.T, certFile, keyFile string, modTime time.Time, hosts ...string) {
+	cert, err := NewSelfSignedCertificate(hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		certFile: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]}),
+		keyFile:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key}),
+	}
+	for file, data := range files {
+		if err = os.WriteFile(file, data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func assertServedName(t *testing.T, manager *CertificateManager, serverName, want string) {
+	t.Helper()
+	cert, err := manager.GetCertificate(&tls.ClientHelloInfo{ServerName: serverName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf.DNSNames[0] != want {
+		t.Fatalf("expected the certificate of %s for %s, got %v", want, serverName, cert.Leaf.DNSNames)
+	}
+}
+
+func TestCertificateManagerReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	loadedAt := time.Now().Add(-time.Hour)
+	writeCertificateFiles(t, certFile, keyFile, loadedAt, "a.test")
+	manager := NewCertificateManager(0)
+	if err := manager.AddCertificateFiles(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	static, _ := NewSelfSignedCertificate("*.static.test")
+	if err := manager.AddCertificate(static); err != nil {
+		t.Fatal(err)
+	}
+	assertServedName(t, manager, "a.test", "a.test")
+	assertServedName(t, manager, "www.static.test", "*.static.test")
+	// unknown names get the first certificate
+	assertServedName(t, manager, "unknown.test", "a.test")
+
+	if err := manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assertServedName(t, manager, "a.test", "a.test")
+
+	writeCertificateFiles(t, certFile, keyFile, loadedAt.Add(time.Minute), "b.test")
+	if err := manager.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	assertServedName(t, manager, "b.test", "b.test")
+	assertServedName(t, manager, "a.test", "b.test")
+
+	// a broken key pair keeps the previous certificate
+	os.WriteFile(keyFile, []byte("broken"), 0600)
+	os.Chtimes(keyFile, loadedAt.Add(time.Hour), loadedAt.Add(time.Hour))
+	if err := manager.Reload(); err == nil {
+		t.Fatal("expected the reload of a broken key to fail")
+	}
+	assertServedName(t, manager, "b.test", "b.test")
+}
+
+func TestCertificateManagerRejectsEmptyChain(t *testing.T) {
+	if err := NewCertificateManager(0).AddCertificate(tls.Certificate{}); err == nil {
+		t.Fatal("expected an error for an empty chain")
+	}
+}
+
+func TestCertificateManagerKeepsCallerTLSConfig(t *testing.T) {
+	config := &tls.Config{MinVersion: tls.VersionTLS13}
+	svr, err := NewBuilder().TLSConfig(config).CertificateManager(NewCertificateManager(0)).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.GetCertificate != nil {
+		t.Fatal("the tls config of the caller was modified")
+	}
+	if served := svr.(immutableServer).tlsConfig; served.GetCertificate == nil || served.MinVersion != tls.VersionTLS13 {
+		t.Fatal("the server config lacks the certificate manager or the caller settings")
+	}
+}
+
+func TestCertificateFilesChanged(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	loadedAt := time.Now().Add(-time.Hour)
+	writeCertificateFiles(t, certFile, keyFile, loadedAt, "a.test")
+	files := &certificateFiles{certFile: certFile, keyFile: keyFile}
+	if err := files.load(); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name    string
+		file    string
+		modTime time.Time
+	}{
+		{"newer certificate", certFile, loadedAt.Add(time.Minute)},
+		// e.g. the previous files restored from a backup
+		{"older certificate", certFile, loadedAt.Add(-time.Minute)},
+		{"newer key", keyFile, loadedAt.Add(time.Minute)},
+		{"older key", keyFile, loadedAt.Add(-time.Minute)},
+	}
+	for _, c := range cases {
+		if changed, err := files.changed(); err != nil || changed {
+			t.Fatalf("unexpected change before %s: %v", c.name, err)
+		}
+		os.Chtimes(c.file, c.modTime, c.modTime)
+		if changed, err := files.changed(); err != nil || !changed {
+			t.Errorf("expected the %s to be a change: %v", c.name, err)
+		}
+		os.Chtimes(c.file, loadedAt, loadedAt)
+	}
+}
@@ -1,23 +1,372 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"math"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
 )
 
-type Engine func(listener net.Listener, server http.Handler) error
+// Engine creates an EngineServer that dispatches every request to the handler.
+type Engine func(handler http.Handler, config EngineConfig) EngineServer
 
-func NetEngine(listener net.Listener, server http.Handler) error {
-	return http.Serve(listener, server)
+// ServeFunc serves handler on listener until the listener is closed, it is what an Engine used to be before engines
+// could shut down gracefully, e.g. func(l net.Listener, h http.Handler) error { return http.Serve(l, h) }.
+type ServeFunc func(listener net.Listener, handler http.Handler) error
+
+// EngineFromServeFunc adapts serve to an Engine. Only the TLSConfig of the EngineConfig is applied, by serving TLS
+// over HTTP/1.1 on the listener, Serve fails when timeouts or MaxHeaderBytes are configured as serve can't apply them.
+// The EngineServer can't drain, both Shutdown and Close close the listener and return right away, leaving the in-flight
+// requests to serve.
+func EngineFromServeFunc(serve ServeFunc) Engine {
+	return func(handler http.Handler, config EngineConfig) EngineServer {
+		engineServer := &serveFuncEngineServer{serve: serve, handler: handler}
+		if ignored := config.serveFuncIgnored(); len(ignored) > 0 {
+			engineServer.err = fmt.Errorf("engines adapted from a ServeFunc can't apply %s, configure them in the ServeFunc instead",
+				strings.Join(ignored, ", "))
+		}
+		if config.TLSConfig != nil {
+			engineServer.tlsConfig = config.TLSConfig.Clone()
+			engineServer.tlsConfig.NextProtos = []string{"http/1.1"}
+		}
+		return engineServer
+	}
+}
+
+type EngineConfig struct {
+	// TLSConfig makes the engine serve TLS on the listener when set
+	TLSConfig         *tls.Config
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	// HTTP2 is only supported by NetEngine, nil keeps the net/http defaults(HTTP/2 over TLS only)
+	HTTP2 *HTTP2Config
+	// FastHTTP is only supported by FastHTTPEngine and FastHTTPAdaptorEngine
+	FastHTTP *FastHTTPConfig
+}
+
+// serveFuncIgnored lists the settings a ServeFunc has no way to apply
+func (c EngineConfig) serveFuncIgnored() []string {
+	var ignored []string
+	for _, setting := range []struct {
+		name string
+		set  bool
+	}{
+		{"ReadTimeout", c.ReadTimeout > 0},
+		{"ReadHeaderTimeout", c.ReadHeaderTimeout > 0},
+		{"WriteTimeout", c.WriteTimeout > 0},
+		{"IdleTimeout", c.IdleTimeout > 0},
+		{"MaxHeaderBytes", c.MaxHeaderBytes > 0},
+	} {
+		if setting.set {
+			ignored = append(ignored, setting.name)
+		}
+	}
+	return ignored
+}
+
+type EngineServer interface {
+	Serve(listener net.Listener) error
+	// Shutdown closes the listeners and waits for in-flight requests until ctx is done.
+	Shutdown(ctx context.Context) error
+	// Close closes the listeners without waiting for in-flight requests.
+	Close() error
+}
+
+type netEngineServer struct {
+	server *http.Server
+	useTLS bool
+	// h2c is nil unless clear-text HTTP/2 is served
+	h2c *h2cConns
+	err error
+}
+
+func NetEngine(handler http.Handler, config EngineConfig) EngineServer {
+	engineServer := netEngineServer{
+		server: &http.Server{
+			Handler:           handler,
+			TLSConfig:         config.TLSConfig,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		},
+		useTLS: config.TLSConfig != nil,
+	}
+	if config.HTTP2 != nil {
+		engineServer.h2c, engineServer.err = configureHTTP2(engineServer.server, *config.HTTP2)
+	}
+	return engineServer
+}
+
+func (s netEngineServer) Serve(listener net.Listener) (err error) {
+	if s.err != nil {
+		return s.err
+	}
+	if s.useTLS {
+		err = s.server.ServeTLS(listener, "", "")
+	} else {
+		err = s.server.Serve(listener)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+func (s netEngineServer) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	if err == nil && s.h2c != nil {
+		err = s.h2c.wait(ctx)
+	}
+	if err != nil {
+		// drain deadline exceeded, drop the remaining connections
+		s.Close()
+	}
+	return err
+}
+
+func (s netEngineServer) Close() error {
+	if s.h2c != nil {
+		s.h2c.closeAll()
+	}
+	return s.server.Close()
+}
+
+type serveFuncEngineServer struct {
+	serve     ServeFunc
+	handler   http.Handler
+	tlsConfig *tls.Config
+	err       error
+	lock      sync.Mutex
+	listener  net.Listener
+	closed    bool
+}
+
+func (s *serveFuncEngineServer) Serve(listener net.Listener) error {
+	if s.err != nil {
+		return s.err
+	}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	s.listener = listener
+	s.lock.Unlock()
+	err := s.serve(listener, s.handler)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		// the error of the closed listener
+		return nil
+	}
+	return err
+}
+
+func (s *serveFuncEngineServer) Shutdown(ctx context.Context) error {
+	return s.Close()
+}
+
+func (s *serveFuncEngineServer) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+type fastHTTPEngineServer struct {
+	server    *fasthttp.Server
+	tlsConfig *tls.Config
+	conns     *connTracker
+	err       error
 }
 
-func FastHTTPEngine(listener net.Listener, server http.Handler) error {
-	fastHTTPServer := fasthttp.Server{
-		Handler:               fasthttpadaptor.NewFastHTTPHandler(server),
-		NoDefaultServerHeader: true,
+const (
+	// fastHTTPCloseTimeout bounds how long Close waits for the in-flight handlers
+	fastHTTPCloseTimeout = time.Second
+	// maxFastHTTPHeaderBytes bounds MaxHeaderBytes as fasthttp allocates a read buffer that big per connection
+	maxFastHTTPHeaderBytes = 64 << 10
+	// noReadTimeout lifts the read deadline for the body when only ReadHeaderTimeout is set
+	noReadTimeout = time.Duration(math.MaxInt64)
+)
+
+// FastHTTPEngine serves handlers implementing FastHTTPHandler natively and falls back to the net/http adaptor otherwise.
+func FastHTTPEngine(handler http.Handler, config EngineConfig) EngineServer {
+	if fastHTTPHandler, ok := handler.(FastHTTPHandler); ok {
+		return newFastHTTPEngineServer(fastHTTPHandler.ServeFastHTTP, config)
+	}
+	return FastHTTPAdaptorEngine(handler, config)
+}
+
+// FastHTTPAdaptorEngine always converts fasthttp requests to net/http ones before handing them to the handler.
+func FastHTTPAdaptorEngine(handler http.Handler, config EngineConfig) EngineServer {
+	return newFastHTTPEngineServer(fasthttpadaptor.NewFastHTTPHandler(handler), config)
+}
+
+func newFastHTTPEngineServer(handler fasthttp.RequestHandler, config EngineConfig) EngineServer {
+	engineServer := fastHTTPEngineServer{
+		server: &fasthttp.Server{
+			Handler:               handler,
+			NoDefaultServerHeader: true,
+			ReadTimeout:           config.ReadTimeout,
+			WriteTimeout:          config.WriteTimeout,
+			IdleTimeout:           config.IdleTimeout,
+			// fasthttp limits the request header by the read buffer size
+			ReadBufferSize: config.MaxHeaderBytes,
+		},
+		conns: newConnTracker(),
+	}
+	if config.MaxHeaderBytes > maxFastHTTPHeaderBytes {
+		engineServer.err = fmt.Errorf("MaxHeaderBytes %d exceeds the %d bytes fasthttp can buffer per connection",
+			config.MaxHeaderBytes, maxFastHTTPHeaderBytes)
+	}
+	if config.ReadHeaderTimeout > 0 {
+		// fasthttp applies ReadTimeout to the header, the deadline starts over for the body once the header is read
+		engineServer.server.ReadTimeout = config.ReadHeaderTimeout
+		bodyTimeout := config.ReadTimeout
+		if bodyTimeout == 0 {
+			bodyTimeout = noReadTimeout
+		}
+		engineServer.server.HeaderReceived = func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+			return fasthttp.RequestConfig{ReadTimeout: bodyTimeout}
+		}
+	}
+	if config.FastHTTP != nil {
+		if config.MaxHeaderBytes > 0 && config.FastHTTP.ReadBufferSize > 0 && config.FastHTTP.ReadBufferSize != config.MaxHeaderBytes {
+			engineServer.err = fmt.Errorf("FastHTTPConfig.ReadBufferSize %d conflicts with MaxHeaderBytes %d, fasthttp limits the header by its read buffer",
+				config.FastHTTP.ReadBufferSize, config.MaxHeaderBytes)
+		}
+		config.FastHTTP.apply(engineServer.server)
+	}
+	if config.TLSConfig != nil {
+		// fasthttp only speaks HTTP/1.x
+		engineServer.tlsConfig = config.TLSConfig.Clone()
+		engineServer.tlsConfig.NextProtos = []string{"http/1.1"}
+	}
+	return engineServer
+}
+
+func (s fastHTTPEngineServer) Serve(listener net.Listener) error {
+	if s.err != nil {
+		return s.err
+	}
+	listener = trackingListener{Listener: listener, tracker: s.conns}
+	if s.tlsConfig != nil {
+		listener = tls.NewListener(listener, s.tlsConfig)
+	}
+	return s.server.Serve(listener)
+}
+
+func (s fastHTTPEngineServer) Shutdown(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Shutdown()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// drain deadline exceeded, drop the remaining connections. fasthttp has no way to abort in-flight requests, the
+		// handlers that are still running are left behind
+		s.conns.closeAll()
+		return ctx.Err()
+	}
+}
+
+// Close closes the listeners and the open connections, it waits at most fastHTTPCloseTimeout for the in-flight handlers
+// as fasthttp has no way to abort them.
+func (s fastHTTPEngineServer) Close() error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Shutdown()
+	}()
+	s.conns.closeAll()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(fastHTTPCloseTimeout):
+		return nil
 	}
-	return fastHTTPServer.Serve(listener)
+}
+
+// connTracker keeps the connections accepted by fasthttp so they can be closed, connections accepted after closeAll are
+// closed right away
+type connTracker struct {
+	lock   sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]struct{})}
+}
+
+func (t *connTracker) add(conn net.Conn) net.Conn {
+	tracked := &trackedConn{Conn: conn, tracker: t}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		conn.Close()
+	} else {
+		t.conns[tracked] = struct{}{}
+	}
+	return tracked
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.conns, conn)
+}
+
+func (t *connTracker) closeAll() {
+	t.lock.Lock()
+	conns := t.conns
+	t.conns = make(map[net.Conn]struct{})
+	t.closed = true
+	t.lock.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
+
+type trackingListener struct {
+	net.Listener
+	tracker *connTracker
+}
+
+func (l trackingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.tracker.add(conn), nil
+}
+
+type trackedConn struct {
+	net.Conn
+	tracker *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.tracker.remove(c)
+	return c.Conn.Close()
 }
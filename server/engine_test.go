@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newEchoTestServer serves POST /echo answering the request body with engine configured by configure
+func newEchoTestServer(t *testing.T, engine Engine, configure func(Builder) Builder) string {
+	builder, url := listenTestServer(t, configure(NewBuilder().Engine(engine)).WithService(NewServiceBuilder().Id("echo").
+		WithRouteHandlers(PathHandlerBuilder("/echo").Post(func(r Request) (Response, ServiceError) {
+			body, err := r.Body()
+			if err != nil {
+				return nil, BadRequestError(err.Error())
+			}
+			return NewPlainTextResponse(http.StatusOK, string(body)), nil
+		})).MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestServer(t, svr, url)
+	return strings.TrimPrefix(url, "http://")
+}
+
+func TestMaxHeaderBytes(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			addr := newEchoTestServer(t, e.engine, func(builder Builder) Builder {
+				return builder.MaxHeaderBytes(8 << 10)
+			})
+			for _, c := range []struct {
+				size   int
+				status int
+			}{
+				{1 << 10, http.StatusOK},
+				{32 << 10, http.StatusRequestHeaderFieldsTooLarge},
+			} {
+				request, _ := http.NewRequest(http.MethodPost, "http://"+addr+"/echo", strings.NewReader("ok"))
+				request.Header.Set("X-Padding", strings.Repeat("x", c.size))
+				resp, err := freshTestClient().Do(request)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != c.status {
+					t.Errorf("expected %d for a %d bytes header, got %d", c.status, c.size, resp.StatusCode)
+				}
+			}
+		})
+	}
+}
+
+func TestFastHTTPRefusesLargeHeaderBuffers(t *testing.T) {
+	cases := []struct {
+		name      string
+		configure func(Builder) Builder
+	}{
+		{"too large", func(builder Builder) Builder {
+			return builder.MaxHeaderBytes(1 << 20)
+		}},
+		{"conflicting read buffer", func(builder Builder) Builder {
+			return builder.MaxHeaderBytes(8 << 10).FastHTTP(FastHTTPConfig{ReadBufferSize: 16 << 10})
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			builder, _ := listenTestServer(t, c.configure(NewBuilder().Engine(FastHTTPEngine)))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			started := make(chan error, 1)
+			go func() {
+				started <- svr.Start()
+			}()
+			select {
+			case err := <-started:
+				if err == nil || !strings.Contains(err.Error(), "MaxHeaderBytes") {
+					t.Fatalf("expected the header buffer to be refused, got %v", err)
+				}
+			case <-time.After(time.Second * 5):
+				svr.Stop()
+				t.Fatal("expected Start to fail")
+			}
+		})
+	}
+}
+
+func TestReadHeaderTimeout(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			addr := newEchoTestServer(t, e.engine, func(builder Builder) Builder {
+				return builder.ReadHeaderTimeout(time.Millisecond * 200)
+			})
+			t.Run("slow header", func(t *testing.T) {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(time.Second * 5))
+				io.WriteString(conn, "POST /echo HTTP/1.1\r\nHost: "+addr+"\r\n")
+				// the connection is dropped without a response to the request
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if err == nil {
+					resp.Body.Close()
+					if resp.StatusCode == http.StatusOK {
+						t.Fatal("expected the slow header to time out")
+					}
+				} else if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					t.Fatal("expected the server to drop the connection")
+				}
+			})
+			t.Run("slow body", func(t *testing.T) {
+				conn, err := net.Dial("tcp", addr)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(time.Second * 5))
+				io.WriteString(conn, "POST /echo HTTP/1.1\r\nHost: "+addr+"\r\nContent-Length: 5\r\n\r\n")
+				// the body takes longer than the header timeout
+				for _, part := range []string{"s", "l", "o", "w", "!"} {
+					time.Sleep(time.Millisecond * 100)
+					io.WriteString(conn, part)
+				}
+				resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || string(body) != "slow!" {
+					t.Fatalf("unexpected response %d %q", resp.StatusCode, body)
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrorEvent describes a panic or a response with a 5xx status. Stack is where the panic happened or where the 5xx
+// ServiceError was created, it is empty for 5xx responses returned without a ServiceError.
+type ErrorEvent struct {
+	Time          time.Time         `json:"time"`
+	Code          int               `json:"code"`
+	Message       string            `json:"message"`
+	Panic         bool              `json:"panic"`
+	Stack         string            `json:"stack,omitempty"`
+	ServiceId     string            `json:"serviceId,omitempty"`
+	UriPattern    string            `json:"uriPattern,omitempty"`
+	Method        string            `json:"method,omitempty"`
+	URI           string            `json:"uri"`
+	RemoteAddress string            `json:"remoteAddress,omitempty"`
+	Context       map[string]string `json:"context,omitempty"`
+	// Header is a copy of the request header with the credentials redacted
+	Header http.Header `json:"header,omitempty"`
+	// Dropped is the number of events dropped by rate limiting since the previous reported event
+	Dropped int `json:"dropped,omitempty"`
+	// Request is only valid during Report as it is recycled after the response is sent, it is nil for panics while responding
+	Request Request `json:"-"`
+}
+
+// ErrorReporter receives every panic and 5xx error synchronously on the request goroutine, so it should not block.
+// Reporters implementing io.Closer are closed after the server has drained.
+type ErrorReporter interface {
+	Report(event ErrorEvent)
+}
+
+type ErrorReporterFunc func(event ErrorEvent)
+
+func (f ErrorReporterFunc) Report(event ErrorEvent) {
+	f(event)
+}
+
+func (s *serverBuilder) ErrorReporter(reporter ErrorReporter) Builder {
+	s.errorReporter = reporter
+	return s
+}
+
+func newErrorEvent(request Request, code int, message string) ErrorEvent {
+	event := ErrorEvent{
+		Time:    time.Now(),
+		Code:    code,
+		Message: message,
+		Request: request,
+	}
+	if request == nil {
+		return event
+	}
+	event.UriPattern = request.UriPattern()
+	event.Method = request.Method()
+	event.URI = request.URI()
+	event.RemoteAddress = request.RemoteAddress()
+	event.Context = request.ContextValues()
+	event.Header = redactedHeader(request.Header())
+	if service := request.MatchedService(); service != nil {
+		event.ServiceId = service.Id()
+	}
+	if recorder, ok := request.(panicRecorder); ok {
+		if panicEvent := recorder.recordedPanic(); panicEvent != nil {
+			event.Panic = true
+			event.Message = fmt.Sprintf("%v", panicEvent.Value)
+			event.Stack = panicEvent.Stack
+		}
+	}
+	return event
+}
+
+// redactedHeaders are the request headers carrying credentials
+var redactedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+func redactedHeader(header http.Header) http.Header {
+	if header == nil {
+		return nil
+	}
+	redacted := header.Clone()
+	for _, key := range redactedHeaders {
+		if redacted.Get(key) != "" {
+			redacted.Set(key, "[redacted]")
+		}
+	}
+	return redacted
+}
+
+// stackRecorder is implemented by the ServiceErrors recording where they were created
+type stackRecorder interface {
+	stack() string
+}
+
+type panicRecorder interface {
+	recordPanic(event PanicEvent)
+	recordedPanic() *PanicEvent
+}
+
+// reportError reports the request if it has panicked or ends up with a 5xx status.
+func (s immutableServer) reportError(request Request, resp Response, serviceErr ServiceError) {
+	if s.errorReporter == nil {
+		return
+	}
+	var (
+		code    int
+		message string
+	)
+	if serviceErr != nil {
+		code, message = serviceErr.Code(), serviceErr.Error()
+	} else if resp != nil {
+		code, message = resp.Code(), http.StatusText(resp.Code())
+	}
+	if code < http.StatusInternalServerError && !hasPanicked(request) {
+		return
+	}
+	event := newErrorEvent(request, code, message)
+	if recorder, ok := serviceErr.(stackRecorder); ok && !event.Panic {
+		event.Stack = recorder.stack()
+	}
+	s.errorReporter.Report(event)
+}
+
+func hasPanicked(request Request) bool {
+	recorder, ok := request.(panicRecorder)
+	return ok && recorder.recordedPanic() != nil
+}
+
+// reportPanic reports panics outside of the middleware chain
+func (s immutableServer) reportPanic(event PanicEvent) {
+	if s.errorReporter == nil {
+		return
+	}
+	errorEvent := newErrorEvent(nil, http.StatusInternalServerError, fmt.Sprintf("%v", event.Value))
+	errorEvent.Time = event.Time
+	errorEvent.Panic = true
+	errorEvent.Stack = event.Stack
+	errorEvent.URI = event.URI
+	s.errorReporter.Report(errorEvent)
+}
+
+func (s immutableServer) closeErrorReporter(closed closerSet) {
+	if closer, ok := s.errorReporter.(io.Closer); ok && closed.add(closer) {
+		if err := closer.Close(); err != nil {
+			s.logger.Errorf(s.ctx, "failed to close error reporter: %s", err.Error())
+		}
+	}
+}
+
+type sampledErrorReporter struct {
+	reporter   ErrorReporter
+	sampleRate float64
+}
+
+// SampledErrorReporter reports sampleRate(0 to 1) of the 5xx errors to reporter, panics are always reported.
+func SampledErrorReporter(reporter ErrorReporter, sampleRate float64) ErrorReporter {
+	return sampledErrorReporter{reporter, sampleRate}
+}
+
+func (r sampledErrorReporter) Report(event ErrorEvent) {
+	if event.Panic || rand.Float64() < r.sampleRate {
+		r.reporter.Report(event)
+	}
+}
+
+func (r sampledErrorReporter) Close() error {
+	if closer, ok := r.reporter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+type rateLimitedErrorReporter struct {
+	reporter ErrorReporter
+	limit    int
+	interval time.Duration
+	lock     *sync.Mutex
+	state    *rateLimitState
+}
+
+type rateLimitState struct {
+	windowEnd time.Time
+	reported  int
+	dropped   int
+}
+
+// RateLimitedErrorReporter reports at most limit events per interval to reporter, the number of dropped events is carried
+// by the next reported event.
+func RateLimitedErrorReporter(reporter ErrorReporter, limit int, interval time.Duration) ErrorReporter {
+	return rateLimitedErrorReporter{
+		reporter: reporter,
+		limit:    limit,
+		interval: interval,
+		lock:     new(sync.Mutex),
+		state:    new(rateLimitState),
+	}
+}
+
+func (r rateLimitedErrorReporter) Report(event ErrorEvent) {
+	r.lock.Lock()
+	now := time.Now()
+	if now.After(r.state.windowEnd) {
+		r.state.windowEnd = now.Add(r.interval)
+		r.state.reported = 0
+	}
+	if r.state.reported >= r.limit {
+		r.state.dropped++
+		r.lock.Unlock()
+		return
+	}
+	r.state.reported++
+	event.Dropped = r.state.dropped
+	r.state.dropped = 0
+	r.lock.Unlock()
+	r.reporter.Report(event)
+}
+
+func (r rateLimitedErrorReporter) Close() error {
+	if closer, ok := r.reporter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
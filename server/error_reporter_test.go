@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampledErrorReporter(t *testing.T) {
+	cases := []struct {
+		sampleRate float64
+		expected   int
+	}{
+		{0, 1},
+		{1, 3},
+	}
+	for _, c := range cases {
+		var reported []ErrorEvent
+		reporter := SampledErrorReporter(ErrorReporterFunc(func(event ErrorEvent) {
+			reported = append(reported, event)
+		}), c.sampleRate)
+		reporter.Report(ErrorEvent{Code: http.StatusInternalServerError})
+		reporter.Report(ErrorEvent{Code: http.StatusInternalServerError, Panic: true})
+		reporter.Report(ErrorEvent{Code: http.StatusBadGateway})
+		if len(reported) != c.expected {
+			t.Fatalf("expected %d events at rate %v, got %+v", c.expected, c.sampleRate, reported)
+		}
+	}
+}
+
+func TestRateLimitedErrorReporter(t *testing.T) {
+	var reported []ErrorEvent
+	reporter := RateLimitedErrorReporter(ErrorReporterFunc(func(event ErrorEvent) {
+		reported = append(reported, event)
+	}), 2, time.Millisecond*100)
+	for i := 0; i < 5; i++ {
+		reporter.Report(ErrorEvent{Code: http.StatusInternalServerError})
+	}
+	if len(reported) != 2 || reported[0].Dropped != 0 || reported[1].Dropped != 0 {
+		t.Fatalf("expected 2 events within the interval, got %+v", reported)
+	}
+	time.Sleep(time.Millisecond * 150)
+	reporter.Report(ErrorEvent{Code: http.StatusInternalServerError})
+	if len(reported) != 3 || reported[2].Dropped != 3 {
+		t.Fatalf("expected the next event to carry the 3 dropped ones, got %+v", reported)
+	}
+}
+
+func TestErrorReporterEvents(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			events := make(chan ErrorEvent, 8)
+			service := NewServiceBuilder().Id("students").
+				WithRouteHandlers(PathHandlerBuilder("/fail").Get(func(r Request) (Response, ServiceError) {
+					return nil, InternalError("database is down")
+				})).
+				WithRouteHandlers(PathHandlerBuilder("/missing").Get(func(r Request) (Response, ServiceError) {
+					return nil, NotFoundError("no such student")
+				})).
+				WithRouteHandlers(PathHandlerBuilder("/panic").Get(func(r Request) (Response, ServiceError) {
+					panic("boom")
+				})).
+				MustBuild()
+			builder, url := listenTestServer(t, NewBuilder().Engine(e.engine).
+				WithMiddleware(func(ctx MiddlewareContext) {
+					ctx.Request().RegisterContext("trace_id", "abc")
+					ctx.Next()
+				}).
+				ErrorReporter(ErrorReporterFunc(func(event ErrorEvent) {
+					if event.Request == nil {
+						t.Errorf("expected the request of %s", event.URI)
+					}
+					event.Request = nil
+					events <- event
+				})).
+				WithService(service))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runTestServer(t, svr, url)
+			for _, path := range []string{"/missing", "/fail?id=1", "/panic"} {
+				request, _ := http.NewRequest(http.MethodGet, url+path, nil)
+				request.Header.Set("Authorization", "Bearer secret")
+				request.Header.Set("X-Client", "tests")
+				resp, err := http.DefaultClient.Do(request)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+			}
+			// the 404 is not reported
+			failed, panicked := <-events, <-events
+			if failed.Code != http.StatusInternalServerError || failed.Panic || failed.Message != "database is down" ||
+				failed.ServiceId != "students" || failed.UriPattern != "/fail" || failed.URI != "/fail?id=1" || failed.Method != http.MethodGet {
+				t.Fatalf("unexpected 5xx event %+v", failed)
+			}
+			// the stack starts where the error was created
+			if !strings.Contains(failed.Stack, "TestErrorReporterEvents") || strings.Contains(failed.Stack, "NewServiceErrorWithCode") {
+				t.Fatalf("unexpected stack of the 5xx event %s", failed.Stack)
+			}
+			if !panicked.Panic || panicked.Message != "boom" || panicked.Stack == "" || panicked.ServiceId != "students" {
+				t.Fatalf("unexpected panic event %+v", panicked)
+			}
+			for _, event := range []ErrorEvent{failed, panicked} {
+				if event.Context["trace_id"] != "abc" || event.RemoteAddress == "" {
+					t.Fatalf("expected the request context in %+v", event)
+				}
+				if event.Header.Get("X-Client") != "tests" || event.Header.Get("Authorization") != "[redacted]" {
+					t.Fatalf("unexpected header %v", event.Header)
+				}
+			}
+			select {
+			case event := <-events:
+				t.Fatalf("unexpected event %+v", event)
+			default:
+			}
+		})
+	}
+}
+
+// closingReporter counts how many times it is closed
+type closingReporter struct {
+	closed int
+}
+
+func (r *closingReporter) Report(event ErrorEvent) {}
+
+func (r *closingReporter) Close() error {
+	r.closed++
+	return nil
+}
+
+func TestSharedErrorReporterIsClosedOnce(t *testing.T) {
+	reporter := new(closingReporter)
+	adminBuilder, _ := listenTestServer(t, NewBuilder().ErrorReporter(reporter))
+	builder, url := listenTestServer(t, NewBuilder().ErrorReporter(reporter).AddListener(adminBuilder))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestServer(t, svr, url)
+	if err := svr.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if reporter.closed != 1 {
+		t.Fatalf("expected the shared reporter to be closed once, got %d", reporter.closed)
+	}
+}
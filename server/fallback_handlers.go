@@ -0,0 +1,125 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/dlshle/gommon/uri_trie"
+)
+
+// PanicEvent is captured when a handler or middleware panics.
+type PanicEvent struct {
+	Time          time.Time
+	Value         interface{}
+	Stack         string
+	Method        string
+	URI           string
+	RemoteAddress string
+	// ServiceId is empty when the route is not matched
+	ServiceId string
+}
+
+func (e PanicEvent) String() string {
+	return fmt.Sprintf("panic while handling request(%s, %s) from %s: %v\n%s", e.Method, e.URI, e.RemoteAddress, e.Value, e.Stack)
+}
+
+// PanicHandler turns a recovered panic into the response, request is nil if the panic happened outside of the middleware chain.
+type PanicHandler func(request Request, event PanicEvent) (Response, ServiceError)
+
+// NotFoundHandler handles requests matching no route, the request has no matched service nor uri pattern.
+func (s *serverBuilder) NotFoundHandler(handler RequestHandler) Builder {
+	s.notFoundHandler = handler
+	return s
+}
+
+// MethodNotAllowedHandler handles requests the matched service rejects with 405, the Allow header set by the service is kept.
+func (s *serverBuilder) MethodNotAllowedHandler(handler RequestHandler) Builder {
+	s.methodNotAllowedHandler = handler
+	return s
+}
+
+func (s *serverBuilder) PanicHandler(handler PanicHandler) Builder {
+	s.panicHandler = handler
+	return s
+}
+
+func defaultNotFoundHandler(request Request) (Response, ServiceError) {
+	return nil, NotFoundError(fmt.Sprintf("route %s is undefined", request.URI()))
+}
+
+func (s immutableServer) defaultPanicHandler(request Request, event PanicEvent) (Response, ServiceError) {
+	s.logger.Errorf(s.ctx, "%s", event.String())
+	return nil, InternalError(fmt.Sprintf("%v", event.Value))
+}
+
+// serviceHandler recovers the panics of the service so that the global middlewares still see the response.
+func (s immutableServer) serviceHandler(service Service) RequestHandler {
+	return func(request Request) (resp Response, err ServiceError) {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				resp, err = s.handlePanic(request, "", recovered)
+			}
+		}()
+		resp, err = service.Handle(request)
+		if err != nil && err.Code() == http.StatusMethodNotAllowed && s.methodNotAllowedHandler != nil {
+			return s.handleMethodNotAllowed(request, resp)
+		}
+		return
+	}
+}
+
+func (s immutableServer) handleMethodNotAllowed(request Request, serviceResp Response) (Response, ServiceError) {
+	resp, err := s.methodNotAllowedHandler(request)
+	if serviceResp == nil {
+		return resp, err
+	}
+	if exists, allow := serviceResp.GetHeader("Allow"); exists {
+		if resp == nil {
+			resp = NewResponse(http.StatusMethodNotAllowed, nil)
+		}
+		resp.SetHeader("Allow", allow)
+	}
+	return resp, err
+}
+
+func (s immutableServer) handlePanic(request Request, uri string, recovered interface{}) (Response, ServiceError) {
+	event := PanicEvent{
+		Time:  time.Now(),
+		Value: recovered,
+		Stack: string(debug.Stack()),
+		URI:   uri,
+	}
+	if request != nil {
+		event.Method = request.Method()
+		event.URI = request.URI()
+		event.RemoteAddress = request.RemoteAddress()
+		if service := request.MatchedService(); service != nil {
+			event.ServiceId = service.Id()
+		}
+	}
+	if recorder, ok := request.(panicRecorder); ok {
+		recorder.recordPanic(event)
+	} else {
+		s.reportPanic(event)
+	}
+	return s.panicHandler(request, event)
+}
+
+// unmatchedContext is used to build the request of unmatched routes
+func unmatchedContext(uri string) *uri_trie.MatchContext {
+	queryParams := make(map[string]string)
+	if _, rawQuery, found := strings.Cut(uri, "?"); found {
+		values, _ := url.ParseQuery(rawQuery)
+		for key := range values {
+			queryParams[key] = values.Get(key)
+		}
+	}
+	return &uri_trie.MatchContext{
+		QueryParams: queryParams,
+		PathParams:  make(map[string]string),
+	}
+}
@@ -0,0 +1,110 @@
+package server_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dlshle/aghs/contrib/middlewares"
+	"github.com/dlshle/aghs/server"
+	"github.com/dlshle/aghs/server/servertest"
+)
+
+// taggingMiddleware marks every response it sees, so that the tests can tell the global middlewares ran
+func taggingMiddleware(ctx server.MiddlewareContext) {
+	ctx.Next()
+	if ctx.Response() != nil {
+		ctx.Response().SetHeader("X-Tagged", "true")
+	}
+}
+
+func newFallbackServer(t *testing.T, configure func(server.Builder) server.Builder) *servertest.Server {
+	builder := server.NewBuilder().
+		WithService(newStudentsService(nil)).
+		WithMiddlewares([]server.Middleware{taggingMiddleware})
+	if configure != nil {
+		builder = configure(builder)
+	}
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return servertest.New(t, svr)
+}
+
+func TestNotFoundHandler(t *testing.T) {
+	newFallbackServer(t, nil).Get("/teachers").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("X-Tagged", "true")
+	var matched server.Service
+	svr := newFallbackServer(t, func(b server.Builder) server.Builder {
+		return b.NotFoundHandler(func(r server.Request) (server.Response, server.ServiceError) {
+			matched = r.MatchedService()
+			return server.NewPlainTextResponse(http.StatusNotFound, "no route for "+r.Path()+" "+r.QueryParams()["q"]), nil
+		})
+	})
+	svr.Get("/teachers?q=ann").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertHeader("X-Tagged", "true").
+		AssertBody("no route for /teachers ann")
+	if matched != nil {
+		t.Fatal("unmatched requests must have no matched service")
+	}
+}
+
+func TestMethodNotAllowedHandler(t *testing.T) {
+	svr := newFallbackServer(t, func(b server.Builder) server.Builder {
+		return b.MethodNotAllowedHandler(func(r server.Request) (server.Response, server.ServiceError) {
+			return server.NewPlainTextResponse(http.StatusMethodNotAllowed, r.Method()+" is not allowed"), nil
+		})
+	})
+	svr.Put("/students").Do().
+		AssertStatus(http.StatusMethodNotAllowed).
+		AssertHeader("Allow", "GET, HEAD, OPTIONS, POST").
+		AssertHeader("X-Tagged", "true").
+		AssertBody("PUT is not allowed")
+	// the routed methods are untouched
+	svr.Get("/students").Do().AssertStatus(http.StatusOK)
+}
+
+func TestPanicHandler(t *testing.T) {
+	panicking := server.NewServiceBuilder().Id("panicking").
+		WithRouteHandlers(server.PathHandlerBuilder("/panic").Get(func(r server.Request) (server.Response, server.ServiceError) {
+			panic("boom")
+		})).
+		MustBuild()
+	var event server.PanicEvent
+	svr := newFallbackServer(t, func(b server.Builder) server.Builder {
+		return b.WithService(panicking).PanicHandler(func(r server.Request, e server.PanicEvent) (server.Response, server.ServiceError) {
+			event = e
+			return server.NewPlainTextResponse(http.StatusServiceUnavailable, "recovered"), nil
+		})
+	})
+	svr.Get("/panic").Do().
+		AssertStatus(http.StatusServiceUnavailable).
+		AssertHeader("X-Tagged", "true").
+		AssertBody("recovered")
+	if event.Value != "boom" || event.ServiceId != "panicking" || event.Method != http.MethodGet || event.Stack == "" {
+		t.Fatalf("unexpected panic event %+v", event)
+	}
+	// the default panic handler responds with 500
+	newFallbackServer(t, func(b server.Builder) server.Builder {
+		return b.WithService(panicking)
+	}).Get("/panic").Do().
+		AssertStatus(http.StatusInternalServerError).
+		AssertHeader("X-Tagged", "true")
+}
+
+func TestCORSOnUnmatchedRoutes(t *testing.T) {
+	svr := newFallbackServer(t, func(b server.Builder) server.Builder {
+		return b.WithMiddlewares([]server.Middleware{middlewares.CORSAllowWildcardMiddleware})
+	})
+	// the preflight of an unmatched route has no service to tell the allowed methods
+	svr.Request(http.MethodOptions, "/teachers").Header("Origin", "https://a.test").Do().
+		AssertStatus(http.StatusNotFound).
+		AssertHeader(middlewares.HeaderKeyAllowOrigin, "https://a.test").
+		AssertNoHeader(middlewares.HeaderKeyAllowMethods)
+	svr.Request(http.MethodOptions, "/students").Header("Origin", "https://a.test").Do().
+		AssertStatus(http.StatusNoContent).
+		AssertHeader(middlewares.HeaderKeyAllowOrigin, "https://a.test").
+		AssertHeader(middlewares.HeaderKeyAllowMethods, "GET, POST, OPTIONS")
+}
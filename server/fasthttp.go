@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dlshle/gommon/uri_trie"
+	"github.com/valyala/fasthttp"
+)
+
+var fastHTTPRequestPool sync.Pool = sync.Pool{New: func() any {
+	return new(fastHTTPRequest)
+}}
+
+// FastHTTPHandler is implemented by handlers that FastHTTPEngine can serve without the net/http adaptor.
+type FastHTTPHandler interface {
+	ServeFastHTTP(ctx *fasthttp.RequestCtx)
+}
+
+type FastHTTPConfig struct {
+	// Concurrency is the max number of concurrent connections, 0 means fasthttp.DefaultConcurrency
+	Concurrency int
+	// ReadBufferSize is allocated per connection and limits the request header, it must match MaxHeaderBytes when both
+	// are set
+	ReadBufferSize     int
+	WriteBufferSize    int
+	MaxRequestBodySize int
+	// ReduceMemoryUsage trades CPU for memory by releasing buffers of idle connections
+	ReduceMemoryUsage     bool
+	DisableKeepalive      bool
+	TCPKeepalive          bool
+	TCPKeepalivePeriod    time.Duration
+	MaxIdleWorkerDuration time.Duration
+	// StreamRequestBody lets Request.BodyReader read bodies bigger than ReadBufferSize as they arrive
+	StreamRequestBody bool
+}
+
+func (c FastHTTPConfig) apply(server *fasthttp.Server) {
+	server.Concurrency = c.Concurrency
+	if c.ReadBufferSize > 0 {
+		server.ReadBufferSize = c.ReadBufferSize
+	}
+	server.WriteBufferSize = c.WriteBufferSize
+	server.MaxRequestBodySize = c.MaxRequestBodySize
+	server.ReduceMemoryUsage = c.ReduceMemoryUsage
+	server.DisableKeepalive = c.DisableKeepalive
+	server.TCPKeepalive = c.TCPKeepalive
+	server.TCPKeepalivePeriod = c.TCPKeepalivePeriod
+	server.MaxIdleWorkerDuration = c.MaxIdleWorkerDuration
+	server.StreamRequestBody = c.StreamRequestBody
+}
+
+// FastHTTP tunes the fasthttp server, it is ignored by NetEngine.
+func (s *serverBuilder) FastHTTP(config FastHTTPConfig) Builder {
+	s.fastHTTPConfig = &config
+	return s
+}
+
+func (s immutableServer) ServeFastHTTP(ctx *fasthttp.RequestCtx) {
+	err := s.HandleFastHTTP(ctx)
+	if err != nil {
+		s.logger.Errorf(s.ctx, "server encountered an error while handling request(%s, %s) from %s due to %s", ctx.Method(), ctx.Path(), ctx.RemoteAddr().String(), err.Error())
+	}
+}
+
+func (s immutableServer) HandleFastHTTP(ctx *fasthttp.RequestCtx) error {
+	return s.handle(fastHTTPResponseWriter{ctx}, string(ctx.RequestURI()), func(matchCtx *uri_trie.MatchContext) Request {
+		// the service is nil for unmatched routes
+		service, _ := matchCtx.Value.(Service)
+		return NewFastHTTPRequest(ctx, service, matchCtx.UriPattern, matchCtx.QueryParams, matchCtx.PathParams)
+	})
+}
+
+type fastHTTPResponseWriter struct {
+	ctx *fasthttp.RequestCtx
+}
+
+func (w fastHTTPResponseWriter) SetHeader(key, value string) {
+	w.ctx.Response.Header.Set(key, value)
+}
+
+func (w fastHTTPResponseWriter) WriteHeader(code int) {
+	w.ctx.SetStatusCode(code)
+}
+
+func (w fastHTTPResponseWriter) Write(data []byte) (int, error) {
+	return w.ctx.Write(data)
+}
+
+type fastHTTPRequest struct {
+	routeMatch
+	ctx    *fasthttp.RequestCtx
+	header http.Header
+}
+
+func NewFastHTTPRequest(ctx *fasthttp.RequestCtx, matchedSvc Service, uriPattern string, queryParams map[string]string, pathParams map[string]string) Request {
+	request := fastHTTPRequestPool.Get().(*fastHTTPRequest)
+	request.routeMatch = routeMatch{
+		c:           ctx,
+		uriPattern:  uriPattern,
+		pathParams:  pathParams,
+		queryParams: queryParams,
+		svc:         matchedSvc,
+	}
+	request.ctx = ctx
+	return request
+}
+
+func (r *fastHTTPRequest) recycle() {
+	r.ctx = nil
+	r.header = nil
+	r.routeMatch = routeMatch{}
+	fastHTTPRequestPool.Put(r)
+}
+
+func (r *fastHTTPRequest) String() string {
+	return fmt.Sprintf(`Request{"method":"%s","url":"%s","remoteAddr":"%s","header":"%s","context":"%s","body":"%s"}`,
+		r.Method(),
+		r.ctx.URI().String(),
+		r.RemoteAddress(),
+		r.Header(),
+		r.Context(),
+		truncateString(string(r.ctx.PostBody()), 64),
+	)
+}
+
+func (r *fastHTTPRequest) Path() string {
+	return string(r.ctx.Path())
+}
+
+// URI contains query params
+func (r *fastHTTPRequest) URI() string {
+	return string(r.ctx.RequestURI())
+}
+
+func (r *fastHTTPRequest) Protocol() string {
+	return string(r.ctx.Request.Header.Protocol())
+}
+
+func (r *fastHTTPRequest) Host() string {
+	return string(r.ctx.Host())
+}
+
+func (r *fastHTTPRequest) Method() string {
+	return string(r.ctx.Method())
+}
+
+// Header is converted from the fasthttp header on first access, without Host like net/http
+func (r *fastHTTPRequest) Header() http.Header {
+	if r.header == nil {
+		r.header = make(http.Header)
+		r.ctx.Request.Header.VisitAll(func(key, value []byte) {
+			if string(key) != fasthttp.HeaderHost {
+				r.header.Add(string(key), string(value))
+			}
+		})
+	}
+	return r.header
+}
+
+func (r *fastHTTPRequest) Body() ([]byte, error) {
+	return r.ctx.PostBody(), nil
+}
+
+// BodyReader streams the body when FastHTTPConfig.StreamRequestBody is set, fasthttp buffers it otherwise.
+func (r *fastHTTPRequest) BodyReader() io.Reader {
+	if stream := r.ctx.RequestBodyStream(); stream != nil {
+		return stream
+	}
+	return bytes.NewReader(r.ctx.PostBody())
+}
+
+func (r *fastHTTPRequest) FormFile(key string, maxSize int64) (io.ReadCloser, error) {
+	fileHeader, err := r.ctx.FormFile(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract multipart form file: %v", err)
+	}
+	if maxSize > 0 && fileHeader.Size > maxSize {
+		return nil, fmt.Errorf("multipart form file %s exceeds max size %d", key, maxSize)
+	}
+	return fileHeader.Open()
+}
+
+func (r *fastHTTPRequest) FormValue(key string) (string, error) {
+	return string(r.ctx.FormValue(key)), nil
+}
+
+func (r *fastHTTPRequest) MultipartFormValue(key string, maxSize int64) ([]string, error) {
+	// fasthttp has read the body already, only bounded by MaxRequestBodySize
+	size := int64(r.ctx.Request.Header.ContentLength())
+	if size < 0 {
+		size = int64(len(r.ctx.PostBody()))
+	}
+	if maxSize > 0 && size > maxSize {
+		return nil, multipart.ErrMessageTooLarge
+	}
+	form, err := r.ctx.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	return form.Value[key], nil
+}
+
+func (r *fastHTTPRequest) UnmarshalBody(holder interface{}) error {
+	return json.Unmarshal(r.ctx.PostBody(), holder)
+}
+
+func (r *fastHTTPRequest) RemoteAddress() string {
+	return r.ctx.RemoteAddr().String()
+}
+
+func (r *fastHTTPRequest) RawCtx() context.Context {
+	return r.ctx
+}
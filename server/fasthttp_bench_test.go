@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+)
+
+type benchStudent struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Class string `json:"class"`
+}
+
+func newBenchServer(b *testing.B) immutableServer {
+	service := NewServiceBuilder().
+		Id("bench").
+		WithRouteHandlers(PathHandlerBuilder("/ping").Get(func(r Request) (Response, ServiceError) {
+			return NewPlainTextResponse(http.StatusOK, "pong"), nil
+		})).
+		WithRouteHandlers(PathHandlerBuilder("/students/:sid").Get(func(r Request) (Response, ServiceError) {
+			return NewResponse(http.StatusOK, benchStudent{Id: r.PathParams()["sid"], Name: "bench", Class: r.QueryParams()["class"]}), nil
+		})).
+		MustBuild()
+	svr, err := NewBuilder().WithService(service).Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	return svr.(immutableServer)
+}
+
+// BenchmarkFastHTTPEngine compares the native fasthttp path with the net/http adaptor path on the same routes
+func BenchmarkFastHTTPEngine(b *testing.B) {
+	svr := newBenchServer(b)
+	handlers := []struct {
+		name    string
+		handler fasthttp.RequestHandler
+	}{
+		{"native", svr.ServeFastHTTP},
+		{"adaptor", fasthttpadaptor.NewFastHTTPHandler(svr)},
+	}
+	routes := []struct {
+		name string
+		uri  string
+	}{
+		{"static", "/ping"},
+		{"params", "/students/42?class=a"},
+	}
+	for _, route := range routes {
+		for _, h := range handlers {
+			b.Run(route.name+"/"+h.name, func(b *testing.B) {
+				benchmarkFastHTTPHandler(b, h.handler, route.uri)
+			})
+		}
+	}
+}
+
+func benchmarkFastHTTPHandler(b *testing.B, handler fasthttp.RequestHandler, uri string) {
+	var ctx fasthttp.RequestCtx
+	ctx.Init(&fasthttp.Request{}, nil, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ctx.Request.Reset()
+		ctx.Response.Reset()
+		ctx.Request.Header.SetMethod(http.MethodGet)
+		ctx.Request.SetRequestURI(uri)
+		handler(&ctx)
+		if ctx.Response.StatusCode() != http.StatusOK {
+			b.Fatalf("unexpected status %d for %s", ctx.Response.StatusCode(), uri)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// inspectRequest answers what the accessor of the path param returns for the request
+func inspectRequest(r Request) (Response, ServiceError) {
+	maxSize, _ := strconv.ParseInt(r.QueryParams()["max"], 10, 64)
+	result := make(map[string]any)
+	var err error
+	switch r.PathParams()["accessor"] {
+	case "header":
+		result["multi"] = r.Header().Values("X-Multi")
+		result["agent"] = r.Header().Get("User-Agent")
+		result["host"] = r.Host()
+		result["hostHeader"] = r.Header().Get("Host")
+	case "form":
+		if result["name"], err = r.FormValue("name"); err == nil {
+			result["q"], err = r.FormValue("q")
+		}
+	case "multipart":
+		result["names"], err = r.MultipartFormValue("name", maxSize)
+	case "file":
+		var file io.ReadCloser
+		if file, err = r.FormFile("data", maxSize); err == nil {
+			defer file.Close()
+			var data []byte
+			data, err = io.ReadAll(file)
+			result["data"] = string(data)
+		}
+	case "body":
+		var data []byte
+		data, err = io.ReadAll(r.BodyReader())
+		result["size"] = len(data)
+		result["sum"] = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	if err != nil {
+		result = map[string]any{"error": err.Error()}
+	}
+	return NewResponse(http.StatusOK, result), nil
+}
+
+func newMultipartRequest(url string, names []string, data string) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	for _, name := range names {
+		writer.WriteField("name", name)
+	}
+	file, _ := writer.CreateFormFile("data", "data.txt")
+	io.WriteString(file, data)
+	writer.Close()
+	request, _ := http.NewRequest(http.MethodPost, url, body)
+	request.Header.Set("Content-Type", writer.FormDataContentType())
+	return request
+}
+
+func TestFastHTTPRequestMatchesNetRequest(t *testing.T) {
+	largeBody := bytes.Repeat([]byte("0123456789abcdef"), 64<<10)
+	cases := []struct {
+		name       string
+		newRequest func(url string) *http.Request
+		expected   string
+	}{
+		{"header", func(url string) *http.Request {
+			request, _ := http.NewRequest(http.MethodGet, url+"/header", nil)
+			request.Host = "example.com:8080"
+			request.Header.Set("User-Agent", "tests")
+			request.Header.Add("X-Multi", "a")
+			request.Header.Add("X-Multi", "b")
+			return request
+		}, `{"agent":"tests","host":"example.com:8080","hostHeader":"","multi":["a","b"]}`},
+		{"form", func(url string) *http.Request {
+			request, _ := http.NewRequest(http.MethodPost, url+"/form?q=1", strings.NewReader("name=ann"))
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return request
+		}, `{"name":"ann","q":"1"}`},
+		{"multipart", func(url string) *http.Request {
+			return newMultipartRequest(url+"/multipart?max=4096", []string{"ann", "bob"}, "file")
+		}, `{"names":["ann","bob"]}`},
+		{"multipart too large", func(url string) *http.Request {
+			return newMultipartRequest(url+"/multipart?max=64", []string{"ann"}, strings.Repeat("x", 128))
+		}, `{"error":"multipart: message too large"}`},
+		{"form file", func(url string) *http.Request {
+			return newMultipartRequest(url+"/file?max=4096", nil, "file content")
+		}, `{"data":"file content"}`},
+		{"form file too large", func(url string) *http.Request {
+			return newMultipartRequest(url+"/file?max=4", nil, "file content")
+		}, `{"error":"multipart form file data exceeds max size 4"}`},
+		{"streamed body", func(url string) *http.Request {
+			request, _ := http.NewRequest(http.MethodPost, url+"/body", bytes.NewReader(largeBody))
+			return request
+		}, fmt.Sprintf(`{"size":%d,"sum":"%x"}`, len(largeBody), sha256.Sum256(largeBody))},
+	}
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			builder, url := listenTestServer(t, NewBuilder().Engine(e.engine).
+				// the body of the streamed case is bigger than the max request body size
+				FastHTTP(FastHTTPConfig{StreamRequestBody: true, MaxRequestBodySize: 64 << 10}).
+				WithService(NewServiceBuilder().Id("inspect").
+					WithRouteHandlers(PathHandlerBuilder("/inspect/:accessor").Get(inspectRequest).Post(inspectRequest)).
+					MustBuild()))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runTestServer(t, svr, url)
+			for _, c := range cases {
+				resp, err := http.DefaultClient.Do(c.newRequest(url + "/inspect"))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK || string(body) != c.expected {
+					t.Errorf("expected %s for %s, got %d %s", c.expected, c.name, resp.StatusCode, body)
+				}
+			}
+		})
+	}
+}
@@ -7,15 +7,22 @@ type HandlersWithPath interface {
 	Handlers() map[string][]Middleware
 }
 
+// middlewareNamer is implemented by the HandlersWithPath naming their middlewares, keyed by method
+type middlewareNamer interface {
+	middlewareNames() map[string][]string
+}
+
 type pathHandlerBuilder struct {
 	path     string
 	handlers map[string][]Middleware // method:handler
+	names    map[string][]string
 }
 
 func PathHandlerBuilder(path string) *pathHandlerBuilder {
 	return &pathHandlerBuilder{
 		path:     path,
 		handlers: make(map[string][]Middleware),
+		names:    make(map[string][]string),
 	}
 }
 
@@ -27,6 +34,17 @@ func (b *pathHandlerBuilder) Handlers() map[string][]Middleware {
 	return b.handlers
 }
 
+// NameMiddlewares names the middlewares of the method route in order in spans, Server-Timing and route introspection,
+// the middlewares without a name or with an empty one are named by their function names.
+func (b *pathHandlerBuilder) NameMiddlewares(method string, names ...string) *pathHandlerBuilder {
+	b.names[method] = names
+	return b
+}
+
+func (b *pathHandlerBuilder) middlewareNames() map[string][]string {
+	return b.names
+}
+
 func (b *pathHandlerBuilder) Get(handler RequestHandler) *pathHandlerBuilder {
 	b.handlers[http.MethodGet] = []Middleware{wrapHandlerAsMiddleware(handler)}
 	return b
@@ -122,6 +140,16 @@ func (b *pathHandlerBuilder) WithMethodHandler(method string, handler RequestHan
 	return b
 }
 
+// WebSocket upgrades GET requests on the path to WebSocket connections served by handler.
+func (b *pathHandlerBuilder) WebSocket(config WebSocketConfig, handler WebSocketHandler) *pathHandlerBuilder {
+	return b.Get(NewWebSocketHandler(config, handler))
+}
+
+// WebSocketWithMiddlewares upgrades after the middlewares have accepted the request, e.g. authentication.
+func (b *pathHandlerBuilder) WebSocketWithMiddlewares(config WebSocketConfig, handler WebSocketHandler, middlewares ...Middleware) *pathHandlerBuilder {
+	return b.GetWithMiddlewares(NewWebSocketHandler(config, handler), middlewares...)
+}
+
 func (b *pathHandlerBuilder) Build() HandlersWithPath {
 	return b
 }
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type HTTP2Config struct {
+	// Disabled turns off HTTP/2 over TLS, H2C is ignored as well
+	Disabled bool
+	// H2C serves clear-text HTTP/2 with both prior knowledge and the "Upgrade: h2c" handshake when TLS is not configured,
+	// Shutdown sends a GOAWAY to the h2c connections and waits for their streams like for the other connections
+	H2C bool
+	// MaxConcurrentStreams is the max number of concurrent streams per connection, 0 means the http2 default(250)
+	MaxConcurrentStreams uint32
+	// MaxReadFrameSize is the largest frame the server is willing to read, must be between 16KB and 16MB, 0 means 1MB
+	MaxReadFrameSize uint32
+	// MaxUploadBufferPerConnection is the initial connection level flow control window size
+	MaxUploadBufferPerConnection int32
+	// MaxUploadBufferPerStream is the initial stream level flow control window size
+	MaxUploadBufferPerStream int32
+	// IdleTimeout closes idle HTTP/2 connections, 0 falls back to the server IdleTimeout
+	IdleTimeout time.Duration
+}
+
+// HTTP2 configures HTTP/2 for NetEngine, FastHTTPEngine only speaks HTTP/1.x and ignores it.
+func (s *serverBuilder) HTTP2(config HTTP2Config) Builder {
+	s.http2Config = &config
+	return s
+}
+
+// configureHTTP2 returns the tracker of the h2c connections when H2C is served
+func configureHTTP2(server *http.Server, config HTTP2Config) (*h2cConns, error) {
+	if config.Disabled {
+		// a non-nil empty map disables the automatic HTTP/2 upgrade of net/http
+		server.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+		return nil, nil
+	}
+	useTLS := server.TLSConfig != nil
+	h2Server := &http2.Server{
+		MaxConcurrentStreams:         config.MaxConcurrentStreams,
+		MaxReadFrameSize:             config.MaxReadFrameSize,
+		MaxUploadBufferPerConnection: config.MaxUploadBufferPerConnection,
+		MaxUploadBufferPerStream:     config.MaxUploadBufferPerStream,
+		IdleTimeout:                  config.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(server, h2Server); err != nil {
+		return nil, err
+	}
+	if !config.H2C || useTLS {
+		return nil, nil
+	}
+	conns := &h2cConns{conns: make(map[net.Conn]struct{})}
+	server.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		return context.WithValue(ctx, h2cConnKey{}, conn)
+	}
+	server.Handler = conns.track(h2c.NewHandler(server.Handler, h2Server))
+	return conns, nil
+}
+
+type h2cConnKey struct{}
+
+// h2cConns keeps the connections hijacked by h2c. http.Server forgets about hijacked connections, its Shutdown only
+// sends them a GOAWAY through the http2.Server and its Close leaves them open.
+type h2cConns struct {
+	lock   sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+}
+
+// track keeps the connection of h2c requests until the h2c handler returns, which is once the connection is closed
+func (c *h2cConns) track(h2cHandler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, ok := r.Context().Value(h2cConnKey{}).(net.Conn)
+		if ok && isH2CRequest(r) {
+			if !c.add(conn) {
+				return
+			}
+			defer c.remove(conn)
+		}
+		h2cHandler.ServeHTTP(w, r)
+	})
+}
+
+// isH2CRequest matches the prior knowledge preface and the "Upgrade: h2c" handshake the same way as the h2c handler
+func isH2CRequest(r *http.Request) bool {
+	if r.Method == "PRI" && len(r.Header) == 0 && r.URL.Path == "*" && r.Proto == "HTTP/2.0" {
+		return true
+	}
+	return httpguts.HeaderValuesContainsToken(r.Header["Upgrade"], "h2c") &&
+		httpguts.HeaderValuesContainsToken(r.Header["Connection"], "HTTP2-Settings")
+}
+
+func (c *h2cConns) add(conn net.Conn) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		conn.Close()
+		return false
+	}
+	c.conns[conn] = struct{}{}
+	return true
+}
+
+func (c *h2cConns) remove(conn net.Conn) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.conns, conn)
+}
+
+func (c *h2cConns) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.conns)
+}
+
+// wait waits until the connections are closed by their GOAWAY or ctx is done
+func (c *h2cConns) wait(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for c.count() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (c *h2cConns) closeAll() {
+	c.lock.Lock()
+	conns := c.conns
+	c.conns = make(map[net.Conn]struct{})
+	c.closed = true
+	c.lock.Unlock()
+	for conn := range conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// newHTTP2TestServer serves GET /proto answering the protocol of the request next to the GET /slow of newSlowTestServer
+func newHTTP2TestServer(t *testing.T, builder Builder, client *http.Client) (svr Server, url string, entered, release chan struct{}) {
+	return newSlowTestServer(t, builder.WithService(NewServiceBuilder().Id("http2").
+		WithRouteHandlers(PathHandlerBuilder("/proto").Get(func(r Request) (Response, ServiceError) {
+			return NewPlainTextResponse(http.StatusOK, r.Protocol()), nil
+		})).
+		MustBuild()), client)
+}
+
+func tlsTestClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+		ForceAttemptHTTP2: true,
+	}}
+}
+
+// h2cTestClient speaks HTTP/2 with prior knowledge over clear-text connections
+func h2cTestClient() *http.Client {
+	return &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+func getProto(t *testing.T, client *http.Client, url string) string {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.Proto != string(body) {
+		t.Fatalf("the client spoke %s and the server %s", resp.Proto, body)
+	}
+	return resp.Proto
+}
+
+func TestHTTP2OverTLS(t *testing.T) {
+	cases := []struct {
+		name     string
+		config   *HTTP2Config
+		expected string
+	}{
+		{"default", nil, "HTTP/2.0"},
+		{"configured", &HTTP2Config{MaxConcurrentStreams: 16}, "HTTP/2.0"},
+		{"disabled", &HTTP2Config{Disabled: true}, "HTTP/1.1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			builder := NewBuilder().SelfSignedTLS()
+			if c.config != nil {
+				builder = builder.HTTP2(*c.config)
+			}
+			client := tlsTestClient()
+			_, url, _, _ := newHTTP2TestServer(t, builder, client)
+			if proto := getProto(t, client, url+"/proto"); proto != c.expected {
+				t.Fatalf("expected %s, got %s", c.expected, proto)
+			}
+		})
+	}
+}
+
+func TestH2CWithPriorKnowledge(t *testing.T) {
+	client := h2cTestClient()
+	_, url, _, _ := newHTTP2TestServer(t, NewBuilder().HTTP2(HTTP2Config{H2C: true}), client)
+	if proto := getProto(t, client, url+"/proto"); proto != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2.0, got %s", proto)
+	}
+	// HTTP/1.1 is still served
+	if proto := getProto(t, http.DefaultClient, url+"/proto"); proto != "HTTP/1.1" {
+		t.Fatalf("expected HTTP/1.1, got %s", proto)
+	}
+}
+
+func TestH2CUpgrade(t *testing.T) {
+	_, url, _, _ := newHTTP2TestServer(t, NewBuilder().HTTP2(HTTP2Config{H2C: true}), http.DefaultClient)
+	addr := strings.TrimPrefix(url, "http://")
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second * 5))
+	// an empty HTTP2-Settings is a valid settings payload
+	io.WriteString(conn, "GET /proto HTTP/1.1\r\nHost: "+addr+"\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: \r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "h2c" {
+		t.Fatalf("unexpected upgrade response %d %v", resp.StatusCode, resp.Header)
+	}
+	// the response to the upgraded request arrives on stream 1 once the client preface is sent
+	io.WriteString(conn, http2.ClientPreface)
+	framer := http2.NewFramer(conn, reader)
+	if err := framer.WriteSettings(); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		frame, err := framer.ReadFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the upgraded request keeps the protocol it was sent with
+		if data, ok := frame.(*http2.DataFrame); ok && data.StreamID == 1 {
+			if body := string(data.Data()); body != "HTTP/1.1" {
+				t.Fatalf("unexpected body of the upgraded request %s", body)
+			}
+			return
+		}
+	}
+}
+
+func TestH2CIsOptIn(t *testing.T) {
+	_, url, _, _ := newHTTP2TestServer(t, NewBuilder().HTTP2(HTTP2Config{}), http.DefaultClient)
+	client := h2cTestClient()
+	client.Timeout = time.Second
+	if resp, err := client.Get(url + "/proto"); err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected h2c to be refused, got %s", resp.Proto)
+	}
+}
+
+func TestShutdownDrainsH2CConnections(t *testing.T) {
+	client := h2cTestClient()
+	svr, url, entered, release := newHTTP2TestServer(t, NewBuilder().HTTP2(HTTP2Config{H2C: true}), client)
+	results, err := getSlow(client, url, entered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		shutdown <- svr.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown returned before the h2c stream completed: %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	close(release)
+	if result := <-results; result.err != nil || result.body != "done" {
+		t.Fatalf("unexpected in-flight response %q %v", result.body, result.err)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Fatalf("unexpected shutdown error %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("shutdown did not return once the h2c connection was drained")
+	}
+}
+
+func TestShutdownDeadlineClosesH2CConnections(t *testing.T) {
+	client := h2cTestClient()
+	svr, url, entered, _ := newHTTP2TestServer(t, NewBuilder().HTTP2(HTTP2Config{H2C: true}), client)
+	results, err := getSlow(client, url, entered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := svr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline to be exceeded, got %v", err)
+	}
+	select {
+	case result := <-results:
+		if result.err == nil {
+			t.Fatalf("expected the stuck h2c request to fail, got %q", result.body)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("the h2c connection of the stuck request was not closed")
+	}
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	unixAddressPrefix    = "unix://"
+	fdAddressPrefix      = "fd://"
+	systemdAddressPrefix = "systemd://"
+	// systemd passes the activated sockets starting from fd 3
+	systemdListenFdsStart = 3
+)
+
+// Listen creates a listener for the address, which is one of
+//   - host:port for TCP
+//   - unix:///path/to/socket for a Unix domain socket
+//   - fd://3 for a listening socket inherited as a file descriptor
+//   - systemd:// or systemd://name for a socket passed by systemd socket activation(LISTEN_FDS)
+func Listen(addr string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(addr, unixAddressPrefix):
+		return listenUnix(strings.TrimPrefix(addr, unixAddressPrefix))
+	case strings.HasPrefix(addr, fdAddressPrefix):
+		fd, err := strconv.Atoi(strings.TrimPrefix(addr, fdAddressPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid file descriptor address %s", addr)
+		}
+		return FileListener(uintptr(fd))
+	case strings.HasPrefix(addr, systemdAddressPrefix):
+		return systemdListener(strings.TrimPrefix(addr, systemdAddressPrefix))
+	default:
+		return net.Listen("tcp", addr)
+	}
+}
+
+func listenUnix(path string) (net.Listener, error) {
+	// remove the socket file left by a previous process that did not exit cleanly, a live one still accepts connections
+	if stat, err := os.Stat(path); err == nil && stat.Mode()&os.ModeSocket != 0 {
+		conn, err := net.Dial("unix", path)
+		if err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is served by another process", path)
+		}
+		if !errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, fmt.Errorf("failed to probe unix socket %s: %v", path, err)
+		}
+		os.Remove(path)
+	}
+	return net.Listen("unix", path)
+}
+
+// FileListener creates a listener from an inherited listening socket file descriptor.
+func FileListener(fd uintptr) (net.Listener, error) {
+	file := os.NewFile(fd, "listener-"+strconv.Itoa(int(fd)))
+	if file == nil {
+		return nil, fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	// net.FileListener dups the descriptor, so the original one can be closed
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+var systemdSockets = struct {
+	once      sync.Once
+	lock      sync.Mutex
+	names     []string
+	listeners map[string]net.Listener
+	err       error
+}{}
+
+// SystemdListeners takes all the sockets passed by systemd socket activation keyed by their names(LISTEN_FDNAMES).
+// Sockets that have been taken by a systemd:// address are not included.
+func SystemdListeners() (map[string]net.Listener, error) {
+	if err := loadSystemdSockets(); err != nil {
+		return nil, err
+	}
+	systemdSockets.lock.Lock()
+	defer systemdSockets.lock.Unlock()
+	listeners := systemdSockets.listeners
+	systemdSockets.listeners = make(map[string]net.Listener)
+	return listeners, nil
+}
+
+// systemdListener takes the activated socket by name, the first available one is used when name is empty.
+func systemdListener(name string) (net.Listener, error) {
+	if err := loadSystemdSockets(); err != nil {
+		return nil, err
+	}
+	systemdSockets.lock.Lock()
+	defer systemdSockets.lock.Unlock()
+	if name == "" {
+		for _, n := range systemdSockets.names {
+			if systemdSockets.listeners[n] != nil {
+				name = n
+				break
+			}
+		}
+	}
+	listener, exists := systemdSockets.listeners[name]
+	if !exists {
+		return nil, fmt.Errorf("systemd socket %s is not found or has been taken", name)
+	}
+	delete(systemdSockets.listeners, name)
+	return listener, nil
+}
+
+func loadSystemdSockets() error {
+	systemdSockets.once.Do(func() {
+		names, err := systemdSocketNames(os.Getenv, os.Getpid())
+		if err != nil {
+			systemdSockets.err = err
+			return
+		}
+		systemdSockets.listeners = make(map[string]net.Listener)
+		for i, name := range names {
+			listener, err := FileListener(uintptr(systemdListenFdsStart + i))
+			if err != nil {
+				systemdSockets.err = fmt.Errorf("failed to use systemd socket %s: %v", name, err)
+				return
+			}
+			systemdSockets.names = append(systemdSockets.names, name)
+			systemdSockets.listeners[name] = listener
+		}
+		// the sockets must not be passed down to child processes
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	})
+	return systemdSockets.err
+}
+
+// systemdSocketNames reads the names of the sockets passed to process pid from the socket activation env, the sockets
+// without a name in LISTEN_FDNAMES are named by their file descriptor.
+func systemdSocketNames(getenv func(string) string, pid int) ([]string, error) {
+	if getenv("LISTEN_PID") != strconv.Itoa(pid) {
+		return nil, fmt.Errorf("no sockets are passed by systemd to this process")
+	}
+	count, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %s", getenv("LISTEN_FDS"))
+	}
+	fdNames := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	names := make([]string, count)
+	for i := range names {
+		names[i] = strconv.Itoa(systemdListenFdsStart + i)
+		if i < len(fdNames) && fdNames[i] != "" {
+			names[i] = fdNames[i]
+		}
+	}
+	return names, nil
+}
+
+// perIPLimitListener closes accepted connections from remote IPs that already hold maxConnsPerIP connections.
+type perIPLimitListener struct {
+	net.Listener
+	maxConnsPerIP int
+	lock          *sync.Mutex
+	connCounts    map[string]int
+}
+
+func newPerIPLimitListener(listener net.Listener, maxConnsPerIP int) net.Listener {
+	return perIPLimitListener{
+		Listener:      listener,
+		maxConnsPerIP: maxConnsPerIP,
+		lock:          new(sync.Mutex),
+		connCounts:    make(map[string]int),
+	}
+}
+
+func (l perIPLimitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		limitedConn := &perIPLimitConn{Conn: conn, listener: l}
+		if _, ok := conn.(*proxyProtocolConn); ok {
+			// the client address is only known once the serving goroutine has read the PROXY header
+			return limitedConn, nil
+		}
+		if limitedConn.acquire(); limitedConn.acquireErr != nil {
+			conn.Close()
+			continue
+		}
+		return limitedConn, nil
+	}
+}
+
+func (l perIPLimitListener) acquire(ip string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.connCounts[ip] >= l.maxConnsPerIP {
+		return false
+	}
+	l.connCounts[ip]++
+	return true
+}
+
+func (l perIPLimitListener) release(ip string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.connCounts[ip]--
+	if l.connCounts[ip] <= 0 {
+		delete(l.connCounts, ip)
+	}
+}
+
+type perIPLimitConn struct {
+	net.Conn
+	listener    perIPLimitListener
+	acquireOnce sync.Once
+	acquired    bool
+	acquireErr  error
+	ip          string
+	releaseOnce sync.Once
+}
+
+// acquire counts the connection against the limit of its remote IP once, connections without an IP(e.g. unix socket
+// clients) are not limited as they would all share one count
+func (c *perIPLimitConn) acquire() {
+	c.acquireOnce.Do(func() {
+		tcpAddr, ok := c.Conn.RemoteAddr().(*net.TCPAddr)
+		if !ok {
+			return
+		}
+		c.ip = tcpAddr.IP.String()
+		if !c.listener.acquire(c.ip) {
+			c.acquireErr = fmt.Errorf("too many connections from %s", c.ip)
+			return
+		}
+		c.acquired = true
+	})
+}
+
+func (c *perIPLimitConn) Read(b []byte) (int, error) {
+	c.acquire()
+	if c.acquireErr != nil {
+		return 0, c.acquireErr
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *perIPLimitConn) Close() error {
+	c.releaseOnce.Do(func() {
+		// a connection closed before its first read is never counted
+		c.acquireOnce.Do(func() {})
+		if c.acquired {
+			c.listener.release(c.ip)
+		}
+	})
+	return c.Conn.Close()
+}
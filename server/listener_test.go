@@ -0,0 +1,258 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// acceptedConns dials listener n times and returns how many of the connections are served, served connections echo
+func acceptedConns(t *testing.T, listener net.Listener, network, addr string, n int) int {
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 1)
+				if _, err := conn.Read(buf); err == nil {
+					conn.Write(buf)
+				}
+				// keeps the connection counted until the test ends
+				conn.Read(buf)
+			}()
+		}
+	}()
+	served := 0
+	for i := 0; i < n; i++ {
+		conn, err := net.Dial(network, addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte{'x'})
+		if _, err = conn.Read(make([]byte, 1)); err == nil {
+			served++
+		}
+	}
+	return served
+}
+
+func TestPerIPLimitListener(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := newPerIPLimitListener(listener, 2)
+	defer limited.Close()
+	if served := acceptedConns(t, limited, "tcp", listener.Addr().String(), 4); served != 2 {
+		t.Fatalf("expected 2 connections from the same IP to be served, got %d", served)
+	}
+}
+
+func TestPerIPLimitListenerIgnoresUnixSockets(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	listener, err := listenUnix(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limited := newPerIPLimitListener(listener, 1)
+	defer limited.Close()
+	if served := acceptedConns(t, limited, "unix", path, 3); served != 3 {
+		t.Fatalf("expected every unix socket client to be served, got %d", served)
+	}
+}
+
+func TestListenUnixRemovesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.sock")
+	live, err := Listen(unixAddressPrefix + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a live socket is never taken over
+	if listener, err := Listen(unixAddressPrefix + path); err == nil || !strings.Contains(err.Error(), "served by another process") {
+		if listener != nil {
+			listener.Close()
+		}
+		t.Fatalf("expected the live socket to be refused, got %v", err)
+	}
+	// the socket file left by a process that did not exit cleanly
+	live.(*net.UnixListener).SetUnlinkOnClose(false)
+	live.Close()
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("expected the stale socket file to be left, got %v", err)
+	}
+	listener, err := Listen(unixAddressPrefix + path)
+	if err != nil {
+		t.Fatalf("expected the stale socket to be replaced, got %v", err)
+	}
+	defer listener.Close()
+	if served := acceptedConns(t, listener, "unix", path, 1); served != 1 {
+		t.Fatal("expected the new socket to be served")
+	}
+	// other files are never removed
+	file := filepath.Join(t.TempDir(), "app.sock")
+	if err := os.WriteFile(file, []byte("data"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Listen(unixAddressPrefix + file); err == nil {
+		t.Fatal("expected listening on a regular file to fail")
+	}
+	if data, err := os.ReadFile(file); err != nil || string(data) != "data" {
+		t.Fatalf("the regular file was modified %q %v", data, err)
+	}
+}
+
+func TestListenFd(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+	file, err := listenerFile(tcpListener)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// FileListener dups the descriptor and closes the inherited one, so that closing file right after is a no-op rather
+	// than closing a reused descriptor later on
+	listener, err := Listen(fdAddressPrefix + strconv.Itoa(int(file.Fd())))
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if listener.Addr().String() != tcpListener.Addr().String() {
+		t.Fatalf("expected the listener on %s, got %s", tcpListener.Addr(), listener.Addr())
+	}
+	tcpListener.Close()
+	if served := acceptedConns(t, listener, "tcp", listener.Addr().String(), 1); served != 1 {
+		t.Fatal("expected the inherited socket to be served")
+	}
+	for _, addr := range []string{fdAddressPrefix + "listener", fdAddressPrefix + "-1"} {
+		if listener, err := Listen(addr); err == nil {
+			listener.Close()
+			t.Errorf("expected %s to be invalid", addr)
+		}
+	}
+}
+
+func TestSystemdSocketNames(t *testing.T) {
+	const pid = 42
+	cases := []struct {
+		name     string
+		env      map[string]string
+		expected []string
+		err      string
+	}{
+		{"named", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "web:admin"}, []string{"web", "admin"}, ""},
+		{"unnamed", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2"}, []string{"3", "4"}, ""},
+		{"partially named", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "3", "LISTEN_FDNAMES": "web::"}, []string{"web", "4", "5"}, ""},
+		{"more names than fds", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "1", "LISTEN_FDNAMES": "web:admin"}, []string{"web"}, ""},
+		{"other process", map[string]string{"LISTEN_PID": "1", "LISTEN_FDS": "1"}, nil, "no sockets"},
+		{"no pid", map[string]string{"LISTEN_FDS": "1"}, nil, "no sockets"},
+		{"no fds", map[string]string{"LISTEN_PID": "42"}, nil, "invalid LISTEN_FDS"},
+		{"zero fds", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "0"}, nil, "invalid LISTEN_FDS"},
+		{"invalid fds", map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "two"}, nil, "invalid LISTEN_FDS"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			names, err := systemdSocketNames(func(key string) string {
+				return c.env[key]
+			}, pid)
+			if c.err != "" {
+				if err == nil || !strings.Contains(err.Error(), c.err) {
+					t.Fatalf("expected an error about %s, got %v %v", c.err, names, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(names, c.expected) {
+				t.Fatalf("expected %v, got %v %v", c.expected, names, err)
+			}
+		})
+	}
+}
+
+const systemdHelperEnvKey = "AGHS_SYSTEMD_HELPER"
+
+// TestSystemdListeners passes the sockets to a child process at the file descriptors systemd would use
+func TestSystemdListeners(t *testing.T) {
+	var (
+		files []*os.File
+		addrs []string
+	)
+	for i := 0; i < 3; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer listener.Close()
+		file, err := listenerFile(listener)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		files = append(files, file)
+		addrs = append(addrs, listener.Addr().String())
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdHelperProcess$")
+	cmd.ExtraFiles = files
+	cmd.Env = append(os.Environ(), systemdHelperEnvKey+"=1", "LISTEN_FDS=3", "LISTEN_FDNAMES=web:admin:")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("the child process failed: %v\n%s", err, output)
+	}
+	// systemd:// takes the first socket left, web, and the unnamed socket is named by its descriptor
+	expected := []string{"admin=" + addrs[1], "first=" + addrs[0], "5=" + addrs[2]}
+	for _, line := range expected {
+		if !strings.Contains(string(output), line+"\n") {
+			t.Errorf("expected %s in the output of the child\n%s", line, output)
+		}
+	}
+}
+
+// TestSystemdHelperProcess runs in the child process of TestSystemdListeners only
+func TestSystemdHelperProcess(t *testing.T) {
+	if os.Getenv(systemdHelperEnvKey) != "1" {
+		t.Skip("only run by TestSystemdListeners")
+	}
+	// systemd sets the pid of the activated process
+	os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	admin, err := Listen(systemdAddressPrefix + "admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("admin=%s\n", admin.Addr())
+	if _, err := Listen(systemdAddressPrefix + "admin"); err == nil {
+		t.Fatal("the admin socket is taken twice")
+	}
+	first, err := Listen(systemdAddressPrefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Printf("first=%s\n", first.Addr())
+	listeners, err := SystemdListeners()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, listener := range listeners {
+		fmt.Printf("%s=%s\n", name, listener.Addr())
+	}
+	if len(listeners) != 1 {
+		t.Fatalf("expected only the unnamed socket to be left, got %v", listeners)
+	}
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, exists := os.LookupEnv(key); exists {
+			t.Fatalf("%s must not leak to the children", key)
+		}
+	}
+}
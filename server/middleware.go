@@ -1,6 +1,11 @@
 package server
 
-import "sync"
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
 
 var middlewareContextPool sync.Pool = sync.Pool{
 	New: func() any {
@@ -64,14 +69,25 @@ func (c *middlewareContext) Error() ServiceError {
 
 type Middleware func(ctx MiddlewareContext)
 
-func middlewaresToRequestHandler(middlewares []Middleware) RequestHandler {
+// middlewaresToRequestHandler names the spans of the middlewares by names
+func middlewaresToRequestHandler(middlewares []Middleware, names []string) RequestHandler {
 	return func(r Request) (Response, ServiceError) {
-		return runMiddlewares(middlewares, r)
+		return runMiddlewares(middlewares, names, r)
 	}
 }
 
-func runMiddlewares(middlewares []Middleware, request Request) (Response, ServiceError) {
-	ctx := makeMiddlewareContext(middlewares, request)
+// RunMiddlewares runs the middlewares and then handler(if not nil) against request outside of a server, e.g. in tests.
+func RunMiddlewares(request Request, handler RequestHandler, middlewares ...Middleware) (Response, ServiceError) {
+	chain := append([]Middleware(nil), middlewares...)
+	if handler != nil {
+		chain = append(chain, wrapHandlerAsMiddleware(handler))
+	}
+	return runMiddlewares(chain, nil, request)
+}
+
+// runMiddlewares falls back to MiddlewareName for the spans not named by names
+func runMiddlewares(middlewares []Middleware, names []string, request Request) (Response, ServiceError) {
+	ctx := makeMiddlewareContext(middlewares, names, request)
 	ctx.Next()
 	defer func() {
 		ctx.recycle()
@@ -79,10 +95,11 @@ func runMiddlewares(middlewares []Middleware, request Request) (Response, Servic
 	return ctx.Response(), ctx.Error()
 }
 
-func makeMiddlewareContext(middlewares []Middleware, request Request) MiddlewareContext {
+func makeMiddlewareContext(middlewares []Middleware, names []string, request Request) MiddlewareContext {
 	response := NewResponse(0, nil)
 	currIndex := 0
 	ctx := newMiddlewareContext(request, response)
+	recorder := requestSpanRecorder(request)
 	nextFunc := func() {
 		var currMiddleware Middleware
 		if ctx.err != nil || currIndex >= len(middlewares) {
@@ -92,15 +109,53 @@ func makeMiddlewareContext(middlewares []Middleware, request Request) Middleware
 			currMiddleware = middlewares[currIndex]
 		}
 		currIndex++
+		if recorder != nil {
+			span := recorder.start(spanName(middlewares, names, currIndex-1))
+			defer recorder.end(span)
+		}
 		currMiddleware(ctx)
 	}
 	ctx.next = nextFunc
 	return ctx
 }
 
+func spanName(middlewares []Middleware, names []string, index int) string {
+	if index < len(names) {
+		return names[index]
+	}
+	return MiddlewareName(middlewares[index])
+}
+
 func wrapHandlerAsMiddleware(handler RequestHandler) Middleware {
 	return func(ctx MiddlewareContext) {
 		rawCtx := ctx.(*middlewareContext)
 		rawCtx.response, rawCtx.err = handler(rawCtx.request)
 	}
 }
+
+// MiddlewareName names the middleware by its function name, e.g. middlewares.CORSAllowWildcardMiddleware.
+func MiddlewareName(middleware Middleware) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(middleware).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+	name := fn.Name()
+	// trim the package path
+	if index := strings.LastIndex(name, "/"); index >= 0 {
+		name = name[index+1:]
+	}
+	return name
+}
+
+// middlewareNames names the middlewares by names, the ones without a name given are named by MiddlewareName
+func middlewareNames(middlewares []Middleware, names []string) []string {
+	resolved := make([]string, len(middlewares))
+	for i, middleware := range middlewares {
+		if i < len(names) && names[i] != "" {
+			resolved[i] = names[i]
+		} else {
+			resolved[i] = MiddlewareName(middleware)
+		}
+	}
+	return resolved
+}
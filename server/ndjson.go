@@ -0,0 +1,196 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"sync"
+	"time"
+)
+
+const (
+	NDJSONContentType          = "application/x-ndjson"
+	ndjsonFlushInterval        = time.Millisecond * 100
+	defaultNDJSONMaxLineSize   = 1 << 20
+	ndjsonInitialLineBufferLen = 64 * 1024
+)
+
+// ndjsonWriter encodes one item per line and flushes at most every ndjsonFlushInterval
+type ndjsonWriter struct {
+	w         StreamWriter
+	encoder   *json.Encoder
+	lock      sync.Mutex
+	lastFlush time.Time
+	pending   bool
+	// err is the error of a flush of flushPeriodically
+	err error
+}
+
+func newNDJSONWriter(w StreamWriter) *ndjsonWriter {
+	return &ndjsonWriter{
+		w:       w,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (n *ndjsonWriter) write(item interface{}) error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	if n.err != nil {
+		return n.err
+	}
+	if err := n.encoder.Encode(item); err != nil {
+		return err
+	}
+	n.pending = true
+	if time.Since(n.lastFlush) >= ndjsonFlushInterval {
+		return n.flushLocked()
+	}
+	return nil
+}
+
+func (n *ndjsonWriter) flush() error {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	return n.flushLocked()
+}
+
+func (n *ndjsonWriter) flushLocked() error {
+	if n.err != nil {
+		return n.err
+	}
+	if !n.pending {
+		return nil
+	}
+	n.pending = false
+	n.lastFlush = time.Now()
+	return n.w.Flush()
+}
+
+// flushPeriodically flushes the pending lines every ndjsonFlushInterval so that they are not held until the next item,
+// the returned func stops it and waits for the flush in progress
+func (n *ndjsonWriter) flushPeriodically() (stop func()) {
+	ticker := time.NewTicker(ndjsonFlushInterval)
+	stopped, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				n.lock.Lock()
+				if err := n.flushLocked(); err != nil && n.err == nil {
+					n.err = err
+				}
+				n.lock.Unlock()
+			}
+		}
+	}()
+	return func() {
+		ticker.Stop()
+		close(stopped)
+		<-done
+	}
+}
+
+// NewNDJSONResponse streams items as newline-delimited JSON, the first item is sent right away and the rest at most
+// 100ms later, even when the next item takes longer. The iteration stops once the client is gone, items is iterated
+// after the middlewares have returned.
+func NewNDJSONResponse[T any](request Request, code int, items iter.Seq[T]) Response {
+	startContext := streamContext(request)
+	return NewStreamResponse(code, NDJSONContentType, func(w StreamWriter) error {
+		ctx, cancel := startContext()
+		defer cancel()
+		writer := newNDJSONWriter(w)
+		stopFlushing := writer.flushPeriodically()
+		defer stopFlushing()
+		for item := range items {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if err := writer.write(item); err != nil {
+				return ndjsonStreamError(ctx, err)
+			}
+		}
+		return ndjsonStreamError(ctx, writer.flush())
+	})
+}
+
+// NewNDJSONChanResponse streams the items received from items until it is closed, the buffered lines are also sent
+// whenever the producer is idle. The producer should stop on the request context, items is not drained once the
+// client is gone.
+func NewNDJSONChanResponse[T any](request Request, code int, items <-chan T) Response {
+	startContext := streamContext(request)
+	return NewStreamResponse(code, NDJSONContentType, func(w StreamWriter) error {
+		ctx, cancel := startContext()
+		defer cancel()
+		writer := newNDJSONWriter(w)
+		for {
+			var (
+				item T
+				ok   bool
+			)
+			select {
+			case item, ok = <-items:
+			default:
+				if err := writer.flush(); err != nil {
+					return ndjsonStreamError(ctx, err)
+				}
+				select {
+				case item, ok = <-items:
+				case <-ctx.Done():
+					return nil
+				}
+			}
+			if !ok {
+				return ndjsonStreamError(ctx, writer.flush())
+			}
+			if err := writer.write(item); err != nil {
+				return ndjsonStreamError(ctx, err)
+			}
+		}
+	})
+}
+
+// ndjsonStreamError drops the write errors caused by a client that is gone
+func ndjsonStreamError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// DecodeNDJSON decodes the request body line by line as it is read, blank lines are skipped. The iteration stops after
+// yielding the first error, lines longer than maxLineSize are rejected and 0 means 1MB. Under FastHTTPEngine the body
+// is only read incrementally with FastHTTPConfig.StreamRequestBody.
+func DecodeNDJSON[T any](request Request, maxLineSize int) iter.Seq2[T, error] {
+	if maxLineSize <= 0 {
+		maxLineSize = defaultNDJSONMaxLineSize
+	}
+	return func(yield func(T, error) bool) {
+		scanner := bufio.NewScanner(request.BodyReader())
+		scanner.Buffer(make([]byte, 0, min(ndjsonInitialLineBufferLen, maxLineSize)), maxLineSize)
+		var zero T
+		for line := 1; scanner.Scan(); line++ {
+			data := scanner.Bytes()
+			if len(bytes.TrimSpace(data)) == 0 {
+				continue
+			}
+			var item T
+			if err := json.Unmarshal(data, &item); err != nil {
+				yield(zero, fmt.Errorf("invalid json on line %d: %v", line, err))
+				return
+			}
+			if !yield(item, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			yield(zero, fmt.Errorf("failed to read ndjson body: %v", err))
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"iter"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ndjsonItem struct {
+	N int `json:"n"`
+}
+
+func TestNDJSONResponseFlushesPendingItems(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			release := make(chan struct{})
+			url := newStreamTestServer(t, e.engine, func(r Request) (Response, ServiceError) {
+				return NewNDJSONResponse(r, http.StatusOK, iter.Seq[ndjsonItem](func(yield func(ndjsonItem) bool) {
+					// the second item is written within the flush interval of the first one and held until the ticker
+					if !yield(ndjsonItem{1}) || !yield(ndjsonItem{2}) {
+						return
+					}
+					select {
+					case <-release:
+					case <-time.After(time.Second * 5):
+					}
+					yield(ndjsonItem{3})
+				})), nil
+			})
+			reader := getNDJSON(t, url)
+			for _, expected := range []string{"{\"n\":1}\n", "{\"n\":2}\n"} {
+				if line := readLine(t, reader); line != expected {
+					t.Fatalf("expected %q, got %q", expected, line)
+				}
+			}
+			close(release)
+			if rest, err := io.ReadAll(reader); err != nil || string(rest) != "{\"n\":3}\n" {
+				t.Fatalf("unexpected rest %q %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestNDJSONChanResponseFlushesWhenIdle(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			items := make(chan ndjsonItem)
+			url := newStreamTestServer(t, e.engine, func(r Request) (Response, ServiceError) {
+				return NewNDJSONChanResponse(r, http.StatusOK, items), nil
+			})
+			reader := getNDJSON(t, url)
+			for i := 1; i <= 2; i++ {
+				items <- ndjsonItem{i}
+				if line, expected := readLine(t, reader), fmt.Sprintf("{\"n\":%d}\n", i); line != expected {
+					t.Fatalf("expected %q, got %q", expected, line)
+				}
+			}
+			close(items)
+			if rest, err := io.ReadAll(reader); err != nil || len(rest) != 0 {
+				t.Fatalf("unexpected rest %q %v", rest, err)
+			}
+		})
+	}
+}
+
+func getNDJSON(t *testing.T, url string) *bufio.Reader {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+	if resp.Header.Get("Content-Type") != NDJSONContentType {
+		t.Fatalf("unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	cases := []struct {
+		name        string
+		body        string
+		maxLineSize int
+		limit       int
+		expected    string
+	}{
+		{"blank lines", "{\"n\":1}\n\n  \r\n{\"n\":2}", 0, 0, "1,2,"},
+		{"invalid json", "{\"n\":1}\n\nnope\n{\"n\":4}\n", 0, 0, "1,error: invalid json on line 3"},
+		{"long line", "{\"n\":1}\n{\"n\":" + strings.Repeat("0", 20) + "2}\n", 16, 0, "1,error: failed to read ndjson body: bufio.Scanner: token too long"},
+		{"early stop", "{\"n\":1}\n{\"n\":2}\n{\"n\":3}\n", 0, 2, "1,2,"},
+	}
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			builder, url := listenTestServer(t, NewBuilder().Engine(e.engine).WithService(NewServiceBuilder().Id("decode").
+				WithRouteHandlers(PathHandlerBuilder("/decode").Post(func(r Request) (Response, ServiceError) {
+					maxLineSize, _ := strconv.Atoi(r.QueryParams()["max"])
+					limit, _ := strconv.Atoi(r.QueryParams()["limit"])
+					var decoded strings.Builder
+					for item, err := range DecodeNDJSON[ndjsonItem](r, maxLineSize) {
+						if err != nil {
+							decoded.WriteString("error: " + err.Error())
+							break
+						}
+						fmt.Fprintf(&decoded, "%d,", item.N)
+						if limit--; limit == 0 {
+							break
+						}
+					}
+					return NewPlainTextResponse(http.StatusOK, decoded.String()), nil
+				})).MustBuild()))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runTestServer(t, svr, url)
+			for _, c := range cases {
+				resp, err := http.Post(fmt.Sprintf("%s/decode?max=%d&limit=%d", url, c.maxLineSize, c.limit), NDJSONContentType, strings.NewReader(c.body))
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(string(body), c.expected) {
+					t.Fatalf("%s: expected %q, got %q", c.name, c.expected, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyProtocolHeaderTimeout = time.Second * 5
+	// the longest v1 header is "PROXY TCP6 <39 chars> <39 chars> 65535 65535\r\n"
+	proxyProtocolV1MaxLength = 107
+	proxyProtocolV2HeaderLen = 16
+)
+
+var (
+	proxyProtocolV1Signature = []byte("PROXY ")
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// ProxyProtocol makes the server parse PROXY protocol v1/v2 headers from connections coming from the trusted CIDRs(or IPs),
+// so that Request.RemoteAddress returns the real client address. Use 0.0.0.0/0 and ::/0 to trust every source.
+func (s *serverBuilder) ProxyProtocol(trustedCIDRs ...string) Builder {
+	if len(trustedCIDRs) == 0 {
+		s.err = fmt.Errorf("proxy protocol requires at least one trusted CIDR")
+		return s
+	}
+	for _, cidr := range trustedCIDRs {
+		if !strings.Contains(cidr, "/") {
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			s.err = fmt.Errorf("invalid proxy protocol trusted CIDR %s: %v", cidr, err)
+			return s
+		}
+		s.proxyProtocolTrusted = append(s.proxyProtocolTrusted, ipNet)
+	}
+	return s
+}
+
+type proxyProtocolListener struct {
+	net.Listener
+	trusted []*net.IPNet
+}
+
+func newProxyProtocolListener(listener net.Listener, trusted []*net.IPNet) net.Listener {
+	return proxyProtocolListener{listener, trusted}
+}
+
+func (l proxyProtocolListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.isTrusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	// the header is parsed by the connection's serving goroutine so a slow peer can not block accepting
+	return &proxyProtocolConn{Conn: conn, remoteAddr: conn.RemoteAddr()}, nil
+}
+
+func (l proxyProtocolListener) isTrusted(addr net.Addr) bool {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, ipNet := range l.trusted {
+		if ipNet.Contains(tcpAddr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	parseOnce    sync.Once
+	parseErr     error
+	reader       *bufio.Reader
+	remoteAddr   net.Addr
+	lock         sync.Mutex
+	readDeadline time.Time
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.parseOnce.Do(c.parseHeader)
+	if c.parseErr != nil {
+		return 0, c.parseErr
+	}
+	if c.reader != nil && c.reader.Buffered() > 0 {
+		return c.reader.Read(b)
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.parseOnce.Do(c.parseHeader)
+	return c.remoteAddr
+}
+
+func (c *proxyProtocolConn) SetDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtocolConn) SetReadDeadline(t time.Time) error {
+	c.lock.Lock()
+	c.readDeadline = t
+	c.lock.Unlock()
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyProtocolConn) parseHeader() {
+	c.Conn.SetReadDeadline(time.Now().Add(proxyProtocolHeaderTimeout))
+	defer func() {
+		// restore the deadline set by the engine
+		c.lock.Lock()
+		c.Conn.SetReadDeadline(c.readDeadline)
+		c.lock.Unlock()
+	}()
+	c.reader = bufio.NewReaderSize(c.Conn, 256)
+	first, err := c.reader.Peek(1)
+	if err != nil {
+		c.parseErr = err
+		return
+	}
+	// trusted peers may still connect without the header(e.g. health checks), which may also start with P(e.g. POST),
+	// so the whole signature is checked before consuming anything
+	var addr net.Addr
+	switch {
+	case first[0] == 'P' && c.hasPrefix(proxyProtocolV1Signature):
+		addr, err = parseProxyProtocolV1(c.reader)
+	case first[0] == proxyProtocolV2Signature[0] && c.hasPrefix(proxyProtocolV2Signature):
+		addr, err = parseProxyProtocolV2(c.reader)
+	default:
+		return
+	}
+	if err != nil {
+		c.parseErr = fmt.Errorf("invalid proxy protocol header from %s: %v", c.remoteAddr.String(), err)
+		return
+	}
+	if addr != nil {
+		c.remoteAddr = addr
+	}
+}
+
+// hasPrefix peeks at the start of the connection, a shorter connection has no prefix
+func (c *proxyProtocolConn) hasPrefix(prefix []byte) bool {
+	peeked, _ := c.reader.Peek(len(prefix))
+	return bytes.Equal(peeked, prefix)
+}
+
+func parseProxyProtocolV1(reader *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLength {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("v1 header is not terminated by CRLF")
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, fmt.Errorf("malformed v1 header")
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed v1 header")
+	}
+	ip, port, err := parseProxyProtocolV1Address(fields[1], fields[2], fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("malformed v1 source address")
+	}
+	if _, _, err = parseProxyProtocolV1Address(fields[1], fields[3], fields[5]); err != nil {
+		return nil, fmt.Errorf("malformed v1 destination address")
+	}
+	return &net.TCPAddr{IP: ip, Port: port}, nil
+}
+
+func parseProxyProtocolV1Address(family, ipField, portField string) (net.IP, int, error) {
+	ip := net.ParseIP(ipField)
+	if ip == nil || strings.Contains(ipField, ":") == (family == "TCP4") {
+		return nil, 0, fmt.Errorf("invalid %s address %s", family, ipField)
+	}
+	// ports have no sign nor leading zeros
+	port, err := strconv.ParseUint(portField, 10, 16)
+	if err != nil || strconv.FormatUint(port, 10) != portField {
+		return nil, 0, fmt.Errorf("invalid port %s", portField)
+	}
+	return ip, int(port), nil
+}
+
+func parseProxyProtocolV2(reader *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, proxyProtocolV2HeaderLen)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(header[:12], proxyProtocolV2Signature) {
+		return nil, fmt.Errorf("invalid v2 signature")
+	}
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("unsupported v2 version %d", header[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return nil, err
+	}
+	command, family, transport := header[12]&0x0F, header[13]>>4, header[13]&0x0F
+	if command > 1 {
+		return nil, fmt.Errorf("unsupported v2 command %d", command)
+	}
+	if family > 3 || transport > 2 {
+		return nil, fmt.Errorf("unsupported v2 address family and transport 0x%02x", header[13])
+	}
+	// LOCAL command is sent by the proxy itself(e.g. health checks)
+	if command == 0 {
+		return nil, nil
+	}
+	if (family == 1 || family == 2) && transport != 1 {
+		return nil, fmt.Errorf("unsupported v2 transport %d for an HTTP connection", transport)
+	}
+	switch family {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("short v2 IPv4 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:4]), Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("short v2 IPv6 address block")
+		}
+		return &net.TCPAddr{IP: net.IP(payload[0:16]), Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// AF_UNSPEC and AF_UNIX carry no client IP
+		return nil, nil
+	}
+}
@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestParseProxyProtocolV1(t *testing.T) {
+	cases := []struct {
+		name    string
+		header  string
+		addr    string
+		wantErr bool
+	}{
+		{"tcp4", "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n", "192.168.0.1:56324", false},
+		{"tcp6", "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n", "[2001:db8::1]:56324", false},
+		{"unknown", "PROXY UNKNOWN\r\n", "", false},
+		{"unknown with addresses", "PROXY UNKNOWN ffff:f...f:ffff ffff:f...f:ffff 65535 65535\r\n", "", false},
+		{"missing crlf", "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n", "", true},
+		{"too long", "PROXY TCP4 " + strings.Repeat("1", 200) + "\r\n", "", true},
+		{"truncated", "PROXY TCP4 192.168.0.1", "", true},
+		{"bad keyword", "PROXZ TCP4 192.168.0.1 10.0.0.1 56324 443\r\n", "", true},
+		{"bad family", "PROXY UDP4 192.168.0.1 10.0.0.1 56324 443\r\n", "", true},
+		{"missing field", "PROXY TCP4 192.168.0.1 10.0.0.1 56324\r\n", "", true},
+		{"invalid ip", "PROXY TCP4 192.168.0.256 10.0.0.1 56324 443\r\n", "", true},
+		{"ipv6 in tcp4", "PROXY TCP4 2001:db8::1 10.0.0.1 56324 443\r\n", "", true},
+		{"ipv4 in tcp6", "PROXY TCP6 192.168.0.1 2001:db8::2 56324 443\r\n", "", true},
+		{"port out of range", "PROXY TCP4 192.168.0.1 10.0.0.1 65536 443\r\n", "", true},
+		{"signed port", "PROXY TCP4 192.168.0.1 10.0.0.1 +80 443\r\n", "", true},
+		{"leading zero port", "PROXY TCP4 192.168.0.1 10.0.0.1 080 443\r\n", "", true},
+		{"invalid destination", "PROXY TCP4 192.168.0.1 10.0.0 56324 443\r\n", "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, err := parseProxyProtocolV1(bufio.NewReader(strings.NewReader(c.header)))
+			assertProxyProtocolAddr(t, addr, err, c.addr, c.wantErr)
+		})
+	}
+}
+
+func TestParseProxyProtocolV1KeepsPayload(t *testing.T) {
+	reader := bufio.NewReader(strings.NewReader("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nGET / HTTP/1.1\r\n"))
+	if _, err := parseProxyProtocolV1(reader); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := io.ReadAll(reader)
+	if string(rest) != "GET / HTTP/1.1\r\n" {
+		t.Fatalf("unexpected payload after the header %q", rest)
+	}
+}
+
+func proxyProtocolV2Header(versionCommand, familyTransport byte, addresses []byte) []byte {
+	header := append([]byte(nil), proxyProtocolV2Signature...)
+	header = append(header, versionCommand, familyTransport)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(addresses)))
+	return append(header, addresses...)
+}
+
+func TestParseProxyProtocolV2(t *testing.T) {
+	ipv4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xdc, 0x04, 0x01, 0xbb}
+	ipv6 := append(append(append([]byte(nil), net.ParseIP("2001:db8::1")...), net.ParseIP("2001:db8::2")...), 0xdc, 0x04, 0x01, 0xbb)
+	cases := []struct {
+		name    string
+		header  []byte
+		addr    string
+		wantErr bool
+	}{
+		{"tcp4", proxyProtocolV2Header(0x21, 0x11, ipv4), "192.168.0.1:56324", false},
+		{"tcp6", proxyProtocolV2Header(0x21, 0x21, ipv6), "[2001:db8::1]:56324", false},
+		{"tcp4 with tlvs", proxyProtocolV2Header(0x21, 0x11, append(append([]byte(nil), ipv4...), 0x04, 0x00, 0x01, 0x00)), "192.168.0.1:56324", false},
+		{"local", proxyProtocolV2Header(0x20, 0x00, nil), "", false},
+		{"local ignores addresses", proxyProtocolV2Header(0x20, 0x11, ipv4), "", false},
+		{"unspec", proxyProtocolV2Header(0x21, 0x00, nil), "", false},
+		{"unix", proxyProtocolV2Header(0x21, 0x31, make([]byte, 216)), "", false},
+		{"bad signature", append([]byte("\r\n\r\n\x00\r\nQUIT\r"), 0x21, 0x11, 0x00, 0x0c), "", true},
+		{"bad version", proxyProtocolV2Header(0x11, 0x11, ipv4), "", true},
+		{"bad command", proxyProtocolV2Header(0x22, 0x11, ipv4), "", true},
+		{"bad family", proxyProtocolV2Header(0x21, 0x41, ipv4), "", true},
+		{"bad transport", proxyProtocolV2Header(0x21, 0x13, ipv4), "", true},
+		{"udp", proxyProtocolV2Header(0x21, 0x12, ipv4), "", true},
+		{"unspec transport", proxyProtocolV2Header(0x21, 0x10, ipv4), "", true},
+		{"short ipv4", proxyProtocolV2Header(0x21, 0x11, ipv4[:8]), "", true},
+		{"short ipv6", proxyProtocolV2Header(0x21, 0x21, ipv4), "", true},
+		{"truncated header", proxyProtocolV2Header(0x21, 0x11, ipv4)[:14], "", true},
+		{"truncated addresses", proxyProtocolV2Header(0x21, 0x11, ipv4)[:20], "", true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			addr, err := parseProxyProtocolV2(bufio.NewReader(bytes.NewReader(c.header)))
+			assertProxyProtocolAddr(t, addr, err, c.addr, c.wantErr)
+		})
+	}
+}
+
+func assertProxyProtocolAddr(t *testing.T, addr net.Addr, err error, want string, wantErr bool) {
+	t.Helper()
+	if wantErr {
+		if err == nil {
+			t.Fatalf("expected an error, got address %v", addr)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if want == "" {
+		if addr != nil {
+			t.Fatalf("expected no address, got %v", addr)
+		}
+		return
+	}
+	if addr == nil || addr.String() != want {
+		t.Fatalf("expected address %s, got %v", want, addr)
+	}
+}
+
+func TestProxyProtocolConnWithoutHeader(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		payload string
+		addr    string
+	}{
+		{"post", "POST /students HTTP/1.1\r\nHost: a\r\nContent-Length: 2\r\n\r\n{}", "POST /students HTTP/1.1\r\nHost: a\r\nContent-Length: 2\r\n\r\n{}", "pipe"},
+		{"put", "PUT /students/1 HTTP/1.1\r\nHost: a\r\n\r\n", "PUT /students/1 HTTP/1.1\r\nHost: a\r\n\r\n", "pipe"},
+		{"patch", "PATCH /students/1 HTTP/1.1\r\nHost: a\r\n\r\n", "PATCH /students/1 HTTP/1.1\r\nHost: a\r\n\r\n", "pipe"},
+		{"http2 preface", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n", "pipe"},
+		{"shorter than a header", "PRO", "PRO", "pipe"},
+		{"v1 header", "PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\nPOST / HTTP/1.1\r\n\r\n", "POST / HTTP/1.1\r\n\r\n", "192.168.0.1:56324"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			go func() {
+				client.Write([]byte(c.data))
+				client.Close()
+			}()
+			conn := &proxyProtocolConn{Conn: server, remoteAddr: server.RemoteAddr()}
+			defer conn.Close()
+			payload, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != c.payload {
+				t.Fatalf("unexpected payload %q", payload)
+			}
+			if addr := conn.RemoteAddr().String(); addr != c.addr {
+				t.Fatalf("unexpected remote address %s", addr)
+			}
+		})
+	}
+}
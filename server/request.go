@@ -1,10 +1,12 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"sync"
 
@@ -26,48 +28,70 @@ type Request interface {
 	QueryParams() map[string]string
 	MatchedService() Service
 	Method() string
+	// Protocol is the protocol version, e.g. HTTP/1.1
+	Protocol() string
+	// Host is the host the request is sent to, from the Host header or the absolute request uri
+	Host() string
 	Header() http.Header
 	Body() ([]byte, error)
+	// BodyReader reads the body as it arrives instead of buffering it like Body, it can't be used after Body has read
+	// a part of it
+	BodyReader() io.Reader
+	// FormFile opens the file of key of the multipart form, files bigger than maxSize are rejected
 	FormFile(key string, maxSize int64) (io.ReadCloser, error)
+	// MultipartFormValue returns the values of key of the multipart form, bodies bigger than maxSize are rejected with
+	// multipart.ErrMessageTooLarge
 	MultipartFormValue(key string, maxSize int64) ([]string, error)
 	FormValue(key string) (string, error)
 	UnmarshalBody(holder interface{}) error
 	RemoteAddress() string
 	GetContext(key string) string
 	RegisterContext(key, value string)
+	// ContextValues returns the values registered by RegisterContext
+	ContextValues() map[string]string
 	Context() context.Context
 	RawCtx() context.Context
 }
 
-type request struct {
-	r           *http.Request
+// routeMatch holds the engine independent part of a Request
+type routeMatch struct {
 	c           context.Context
 	uriPattern  string
 	pathParams  map[string]string
 	queryParams map[string]string
 	svc         Service
-	body        []byte
+	contextKeys []string
+	panicEvent  *PanicEvent
+	spans       *spanRecorder
+	// serverCtx is cancelled when the server shuts down
+	serverCtx context.Context
+	// writtenCallbacks are registered by OnResponseWritten
+	writtenCallbacks []ResponseWrittenCallback
+}
+
+type request struct {
+	routeMatch
+	r    *http.Request
+	body []byte
 }
 
 func NewRequest(r *http.Request, matchedSvc Service, uriPattern string, queryParams map[string]string, pathParams map[string]string) Request {
 	request := requestPool.Get().(*request)
-	request.svc = matchedSvc
-	request.uriPattern = uriPattern
-	request.pathParams = pathParams
-	request.queryParams = queryParams
+	request.routeMatch = routeMatch{
+		c:           r.Context(),
+		uriPattern:  uriPattern,
+		pathParams:  pathParams,
+		queryParams: queryParams,
+		svc:         matchedSvc,
+	}
 	request.r = r
-	request.c = r.Context()
 	return request
 }
 
 func (r *request) recycle() {
 	r.body = nil
 	r.r = nil
-	r.c = nil
-	r.uriPattern = ""
-	r.pathParams = nil
-	r.queryParams = nil
-	r.svc = nil
+	r.routeMatch = routeMatch{}
 	requestPool.Put(r)
 }
 
@@ -90,7 +114,7 @@ func (r *request) tryGetBody() string {
 	return string(body)
 }
 
-func (r *request) UriPattern() string {
+func (r *routeMatch) UriPattern() string {
 	return r.uriPattern
 }
 
@@ -103,18 +127,26 @@ func (r *request) URI() string {
 	return r.r.RequestURI
 }
 
-func (r *request) PathParams() map[string]string {
+func (r *routeMatch) PathParams() map[string]string {
 	return r.pathParams
 }
 
-func (r *request) QueryParams() map[string]string {
+func (r *routeMatch) QueryParams() map[string]string {
 	return r.queryParams
 }
 
-func (r *request) MatchedService() Service {
+func (r *routeMatch) MatchedService() Service {
 	return r.svc
 }
 
+func (r *request) Protocol() string {
+	return r.r.Proto
+}
+
+func (r *request) Host() string {
+	return r.r.Host
+}
+
 func (r *request) Method() string {
 	return r.r.Method
 }
@@ -134,14 +166,25 @@ func (r *request) Body() ([]byte, error) {
 	return r.body, nil
 }
 
+func (r *request) BodyReader() io.Reader {
+	if r.body != nil {
+		return bytes.NewReader(r.body)
+	}
+	return r.r.Body
+}
+
 func (r *request) FormFile(key string, maxSize int64) (io.ReadCloser, error) {
 	if err := r.r.ParseMultipartForm(10 << 20); err != nil {
 		return nil, fmt.Errorf("failed to parse multipart form: %v", err)
 	}
-	file, _, err := r.r.FormFile(key)
+	file, fileHeader, err := r.r.FormFile(key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract multipart form file: %v", err)
 	}
+	if maxSize > 0 && fileHeader.Size > maxSize {
+		file.Close()
+		return nil, fmt.Errorf("multipart form file %s exceeds max size %d", key, maxSize)
+	}
 	return file, nil
 }
 
@@ -154,6 +197,10 @@ func (r *request) FormValue(key string) (string, error) {
 }
 
 func (r *request) MultipartFormValue(key string, maxSize int64) ([]string, error) {
+	// ParseMultipartForm only keeps maxSize in memory and spills the rest to disk
+	if maxSize > 0 && r.r.ContentLength > maxSize {
+		return nil, multipart.ErrMessageTooLarge
+	}
 	err := r.r.ParseMultipartForm(maxSize)
 	if err != nil {
 		return nil, err
@@ -173,15 +220,63 @@ func (r *request) RemoteAddress() string {
 	return r.r.RemoteAddr
 }
 
-func (r *request) GetContext(key string) string {
+func (r *routeMatch) GetContext(key string) string {
 	return r.c.Value(key).(string)
 }
 
-func (r *request) RegisterContext(key, value string) {
+func (r *routeMatch) RegisterContext(key, value string) {
 	r.c = logging.WrapCtx(r.c, key, value)
+	r.contextKeys = append(r.contextKeys, key)
+}
+
+func (r *routeMatch) ContextValues() map[string]string {
+	values := make(map[string]string, len(r.contextKeys))
+	for _, key := range r.contextKeys {
+		values[key], _ = r.c.Value(key).(string)
+	}
+	return values
+}
+
+func (r *routeMatch) recordPanic(event PanicEvent) {
+	r.panicEvent = &event
+}
+
+func (r *routeMatch) recordedPanic() *PanicEvent {
+	return r.panicEvent
+}
+
+func (r *routeMatch) setSpanRecorder(recorder *spanRecorder) {
+	r.spans = recorder
+}
+
+func (r *routeMatch) spanRecorder() *spanRecorder {
+	return r.spans
+}
+
+func (r *routeMatch) setServerContext(ctx context.Context) {
+	r.serverCtx = ctx
+}
+
+func (r *routeMatch) serverContext() context.Context {
+	return r.serverCtx
+}
+
+func (r *routeMatch) addWrittenCallback(callback ResponseWrittenCallback) bool {
+	// only the requests handled by a server have its context
+	if r.serverCtx == nil {
+		return false
+	}
+	r.writtenCallbacks = append(r.writtenCallbacks, callback)
+	return true
+}
+
+func (r *routeMatch) takeWrittenCallbacks() []ResponseWrittenCallback {
+	callbacks := r.writtenCallbacks
+	r.writtenCallbacks = nil
+	return callbacks
 }
 
-func (r *request) Context() context.Context {
+func (r *routeMatch) Context() context.Context {
 	return r.c
 }
 
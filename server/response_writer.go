@@ -0,0 +1,105 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseWriter is what each engine writes a Response to
+type responseWriter interface {
+	SetHeader(key, value string)
+	WriteHeader(code int)
+	Write(data []byte) (int, error)
+	// WriteStream sends the headers and writes the body with stream after WriteHeader
+	WriteStream(stream func(writer StreamWriter))
+	// Upgrade hands the connection over to upgrade instead of writing a response
+	Upgrade(upgrade func(conn net.Conn, rw *bufio.ReadWriter)) error
+}
+
+type netResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w netResponseWriter) SetHeader(key, value string) {
+	w.Header().Set(key, value)
+}
+
+type recyclable interface {
+	recycle()
+}
+
+// ResponseWrittenCallback receives the status and the number of body bytes written for a request.
+type ResponseWrittenCallback func(status int, bytes int64)
+
+type responseWrittenObserver interface {
+	addWrittenCallback(callback ResponseWrittenCallback) bool
+	takeWrittenCallbacks() []ResponseWrittenCallback
+}
+
+// OnResponseWritten calls callback once the response of request has been written, for streaming responses once the
+// stream has ended, e.g. to log the response size. It must be called before the middlewares return and callback must
+// not use request as it is recycled by then. It returns false for requests that are not served by a Server, e.g. the
+// fake requests of tests, callback is never called for them.
+func OnResponseWritten(request Request, callback ResponseWrittenCallback) bool {
+	observer, ok := request.(responseWrittenObserver)
+	return ok && observer.addWrittenCallback(callback)
+}
+
+// countingResponseWriter counts the body bytes written and calls the callbacks once the response is written
+type countingResponseWriter struct {
+	responseWriter
+	callbacks []ResponseWrittenCallback
+	status    int
+	bytes     int64
+	// streaming defers the callbacks to the end of the stream
+	streaming bool
+}
+
+func (w *countingResponseWriter) WriteHeader(code int) {
+	w.status = code
+	w.responseWriter.WriteHeader(code)
+}
+
+func (w *countingResponseWriter) Write(data []byte) (int, error) {
+	n, err := w.responseWriter.Write(data)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *countingResponseWriter) WriteStream(stream func(writer StreamWriter)) {
+	w.streaming = true
+	w.responseWriter.WriteStream(func(writer StreamWriter) {
+		defer w.written()
+		stream(countingStreamWriter{writer, w})
+	})
+}
+
+func (w *countingResponseWriter) Upgrade(upgrade func(conn net.Conn, rw *bufio.ReadWriter)) error {
+	w.status = http.StatusSwitchingProtocols
+	return w.responseWriter.Upgrade(upgrade)
+}
+
+// finish calls the callbacks unless they are deferred to the end of the stream
+func (w *countingResponseWriter) finish() {
+	if !w.streaming {
+		w.written()
+	}
+}
+
+func (w *countingResponseWriter) written() {
+	for _, callback := range w.callbacks {
+		callback(w.status, w.bytes)
+	}
+}
+
+type countingStreamWriter struct {
+	StreamWriter
+	w *countingResponseWriter
+}
+
+func (w countingStreamWriter) Write(data []byte) (int, error) {
+	n, err := w.StreamWriter.Write(data)
+	w.w.bytes += int64(n)
+	return n, err
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type writtenResponse struct {
+	status int
+	bytes  int64
+}
+
+func TestOnResponseWritten(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			written := make(chan writtenResponse, 8)
+			service := NewServiceBuilder().Id("written").
+				WithRouteHandlers(PathHandlerBuilder("/json").Get(func(r Request) (Response, ServiceError) {
+					return NewResponse(http.StatusCreated, map[string]string{"id": "42"}), nil
+				})).
+				WithRouteHandlers(PathHandlerBuilder("/stream").Get(func(r Request) (Response, ServiceError) {
+					return NewStreamResponse(http.StatusOK, "text/plain", func(w StreamWriter) error {
+						io.WriteString(w, "first\n")
+						if err := w.Flush(); err != nil {
+							return err
+						}
+						_, err := io.WriteString(w, "second\n")
+						return err
+					}), nil
+				})).
+				WithRouteHandlers(PathHandlerBuilder("/fail").Get(func(r Request) (Response, ServiceError) {
+					return nil, InternalError("database is down")
+				})).
+				MustBuild()
+			builder, url := listenTestServer(t, NewBuilder().Engine(e.engine).
+				WithMiddleware(func(ctx MiddlewareContext) {
+					// the requests checking that the server is up are unmatched
+					if ctx.Request().MatchedService() != nil {
+						if !OnResponseWritten(ctx.Request(), func(status int, bytes int64) {
+							written <- writtenResponse{status, bytes}
+						}) {
+							t.Error("expected the served request to be observed")
+						}
+					}
+					ctx.Next()
+				}).
+				WithService(service))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runTestServer(t, svr, url)
+			for _, path := range []string{"/json", "/stream", "/fail"} {
+				resp, err := http.Get(url + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				select {
+				case w := <-written:
+					if w.status != resp.StatusCode || w.bytes != int64(len(body)) || w.bytes == 0 {
+						t.Errorf("expected %d with %d bytes for %s, got %+v", resp.StatusCode, len(body), path, w)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("the response of %s was not observed", path)
+				}
+			}
+		})
+	}
+}
+
+func TestOnResponseWrittenWithoutServer(t *testing.T) {
+	request := NewRequest(httptest.NewRequest(http.MethodGet, "/students/1", nil), nil, "", nil, nil)
+	if OnResponseWritten(request, func(status int, bytes int64) {}) {
+		t.Fatal("expected a request built outside of a server not to be observed")
+	}
+}
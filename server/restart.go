@@ -0,0 +1,254 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// listenerFdsEnvKey holds the listeners handed off to the new process as addr=fd pairs separated by ;
+	listenerFdsEnvKey = "AGHS_LISTENER_FDS"
+	// restartReadyFdEnvKey holds the pipe the new process writes to once it serves on the inherited listeners
+	restartReadyFdEnvKey = "AGHS_RESTART_READY_FD"
+	listenerFdsSeparator = ";"
+	inheritedFdsStart    = 3
+	restartReadyTimeout  = time.Second * 30
+)
+
+var inheritedListeners = struct {
+	once sync.Once
+	lock sync.Mutex
+	fds  map[string]int
+}{}
+
+// RestartOnSignals makes the server hand its listeners off to a new process of the same binary on any of the signals(SIGHUP by default),
+// this server drains within drainTimeout once the new process starts serving.
+func (s *serverBuilder) RestartOnSignals(drainTimeout time.Duration, signals ...os.Signal) Builder {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	s.restartSignals = signals
+	s.restartDrainTimeout = drainTimeout
+	return s
+}
+
+func (s immutableServer) restartOnSignals() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, s.restartSignals...)
+	defer signal.Stop(signalChan)
+	for {
+		select {
+		case sig := <-signalChan:
+			s.logger.Infof(s.ctx, "received signal %s, restarting the server...", sig.String())
+			if err := s.restart(); err != nil {
+				s.logger.Errorf(s.ctx, "failed to restart the server, keep serving with the current process: %s", err.Error())
+				continue
+			}
+			return
+		case <-s.lifecycle.done:
+			return
+		}
+	}
+}
+
+func (s immutableServer) restart() error {
+	pid, err := s.startSuccessor()
+	if err != nil {
+		return err
+	}
+	s.logger.Infof(s.ctx, "new process %d is serving, draining the current process within %s...", pid, s.restartDrainTimeout.String())
+	ctx, cancel := context.WithTimeout(s.ctx, s.restartDrainTimeout)
+	defer cancel()
+	return s.Shutdown(ctx)
+}
+
+// startSuccessor execs the current binary with the listening sockets and waits until it is ready to serve.
+func (s immutableServer) startSuccessor() (pid int, err error) {
+	s.lifecycle.lock.Lock()
+	servings, closed := s.lifecycle.servings, s.lifecycle.closed
+	s.lifecycle.lock.Unlock()
+	if closed {
+		return 0, ErrServerClosed
+	}
+	var (
+		files         []*os.File
+		fdPairs       []string
+		unixListeners []*net.UnixListener
+	)
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+		if err != nil {
+			// the socket files are still served by this process
+			for _, listener := range unixListeners {
+				listener.SetUnlinkOnClose(true)
+			}
+		}
+	}()
+	for _, srv := range servings {
+		if srv.addr == "" {
+			// the new process would start without it while this one closes it
+			return 0, fmt.Errorf("listener %s is injected by Builder.Listener and can not be handed off to the new process", srv.listener.Addr().String())
+		}
+		file, err := listenerFile(srv.listener)
+		if err != nil {
+			return 0, err
+		}
+		if unixListener, ok := unwrapListener(srv.listener).(*net.UnixListener); ok {
+			unixListeners = append(unixListeners, unixListener)
+		}
+		fdPairs = append(fdPairs, listenerFdPair(srv.addr, inheritedFdsStart+len(files)))
+		files = append(files, file)
+	}
+	readyReader, readyWriter, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer readyReader.Close()
+	files = append(files, readyWriter)
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(environWithout(listenerFdsEnvKey, restartReadyFdEnvKey),
+		listenerFdsEnvKey+"="+strings.Join(fdPairs, listenerFdsSeparator),
+		restartReadyFdEnvKey+"="+strconv.Itoa(inheritedFdsStart+len(files)-1))
+	cmd.ExtraFiles = files
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err = cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start the new process: %v", err)
+	}
+	// the new process holds its own copy of the write end, EOF means it exited before being ready
+	readyWriter.Close()
+	files = files[:len(files)-1]
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	ready := make(chan error, 1)
+	go func() {
+		_, err := readyReader.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			return 0, fmt.Errorf("new process %d exited before being ready", cmd.Process.Pid)
+		}
+		return cmd.Process.Pid, nil
+	case err = <-exited:
+		return 0, fmt.Errorf("new process %d exited before being ready: %v", cmd.Process.Pid, err)
+	case <-time.After(restartReadyTimeout):
+		cmd.Process.Kill()
+		return 0, fmt.Errorf("new process %d is not ready after %s", cmd.Process.Pid, restartReadyTimeout.String())
+	}
+}
+
+// takeInheritedListener returns the listener handed off by the previous process for addr, nil if there is none.
+func takeInheritedListener(addr string) (net.Listener, error) {
+	inheritedListeners.once.Do(func() {
+		inheritedListeners.fds = parseListenerFds(os.Getenv(listenerFdsEnvKey))
+		os.Unsetenv(listenerFdsEnvKey)
+	})
+	inheritedListeners.lock.Lock()
+	fd, exists := inheritedListeners.fds[addr]
+	delete(inheritedListeners.fds, addr)
+	inheritedListeners.lock.Unlock()
+	if !exists {
+		return nil, nil
+	}
+	return FileListener(uintptr(fd))
+}
+
+func listenerFdPair(addr string, fd int) string {
+	return addr + "=" + strconv.Itoa(fd)
+}
+
+// parseListenerFds parses the value of listenerFdsEnvKey, the fd follows the last = as unix socket paths may contain one
+func parseListenerFds(value string) map[string]int {
+	fds := make(map[string]int)
+	for _, pair := range strings.Split(value, listenerFdsSeparator) {
+		sep := strings.LastIndexByte(pair, '=')
+		if sep <= 0 {
+			continue
+		}
+		if fd, err := strconv.Atoi(pair[sep+1:]); err == nil {
+			fds[pair[:sep]] = fd
+		}
+	}
+	return fds
+}
+
+// notifyRestartReady tells the previous process that this one is serving, it is a no-op if this process is not started by a restart.
+func notifyRestartReady() {
+	fdValue := os.Getenv(restartReadyFdEnvKey)
+	if fdValue == "" {
+		return
+	}
+	os.Unsetenv(restartReadyFdEnvKey)
+	fd, err := strconv.Atoi(fdValue)
+	if err != nil {
+		return
+	}
+	readyFile := os.NewFile(uintptr(fd), "restart-ready")
+	if readyFile == nil {
+		return
+	}
+	readyFile.Write([]byte{1})
+	readyFile.Close()
+}
+
+// unwrapListener returns the listening socket under the per-IP limit and PROXY protocol listeners
+func unwrapListener(listener net.Listener) net.Listener {
+	for {
+		switch l := listener.(type) {
+		case perIPLimitListener:
+			listener = l.Listener
+		case proxyProtocolListener:
+			listener = l.Listener
+		default:
+			return listener
+		}
+	}
+}
+
+func listenerFile(listener net.Listener) (*os.File, error) {
+	switch l := unwrapListener(listener).(type) {
+	case *net.TCPListener:
+		return l.File()
+	case *net.UnixListener:
+		// the new process keeps using the socket file
+		l.SetUnlinkOnClose(false)
+		return l.File()
+	default:
+		return nil, fmt.Errorf("listener %s of type %T can not be handed off", listener.Addr().String(), listener)
+	}
+}
+
+func environWithout(keys ...string) []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		excluded := false
+		for _, key := range keys {
+			if strings.HasPrefix(kv, key+"=") {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseListenerFds(t *testing.T) {
+	value := strings.Join([]string{
+		listenerFdPair(":8080", 3),
+		listenerFdPair("/run/app=1.sock", 4),
+		"invalid",
+		"=5",
+		listenerFdPair("[::1]:9090", 6),
+	}, listenerFdsSeparator)
+	expected := map[string]int{":8080": 3, "/run/app=1.sock": 4, "[::1]:9090": 6}
+	if fds := parseListenerFds(value); !reflect.DeepEqual(fds, expected) {
+		t.Fatalf("unexpected fds %v", fds)
+	}
+	if fds := parseListenerFds(""); len(fds) != 0 {
+		t.Fatalf("unexpected fds %v", fds)
+	}
+}
+
+// TestInheritedListenerHandoff hands the listeners off within the process as the new process would receive them
+func TestInheritedListenerHandoff(t *testing.T) {
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tcpListener.Close()
+	socketPath := filepath.Join(t.TempDir(), "app.sock")
+	unixListener, err := listenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unixListener.Close()
+	listeners := map[string]net.Listener{tcpListener.Addr().String(): tcpListener, unixAddressPrefix + socketPath: unixListener}
+	var pairs []string
+	for addr, listener := range listeners {
+		file, err := listenerFile(listener)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		pairs = append(pairs, listenerFdPair(addr, int(file.Fd())))
+	}
+	t.Setenv(listenerFdsEnvKey, strings.Join(pairs, listenerFdsSeparator))
+	inheritedListeners.once = sync.Once{}
+	defer func() {
+		inheritedListeners.once = sync.Once{}
+	}()
+	for addr, listener := range listeners {
+		inherited, err := takeInheritedListener(addr)
+		if err != nil || inherited == nil {
+			t.Fatalf("expected the listener of %s, got %v", addr, err)
+		}
+		if inherited.Addr().String() != listener.Addr().String() {
+			t.Fatalf("expected the listener on %s, got %s", listener.Addr(), inherited.Addr())
+		}
+		inherited.Close()
+		if again, _ := takeInheritedListener(addr); again != nil {
+			t.Fatalf("the listener of %s is taken twice", addr)
+		}
+	}
+	if _, exists := os.LookupEnv(listenerFdsEnvKey); exists {
+		t.Fatal("the handoff env must not leak to the children")
+	}
+	if listener, err := takeInheritedListener("127.0.0.1:" + strconv.Itoa(1)); listener != nil || err != nil {
+		t.Fatal("unexpected listener for an address that was not handed off")
+	}
+}
+
+func TestRestartRejectsInjectedListeners(t *testing.T) {
+	builder, url := listenTestServer(t, NewBuilder().WithService(newTestService("hello", "/hello", "hi").MustBuild()))
+	svr := builder.MustBuild()
+	runTestServer(t, svr, url)
+	if _, err := svr.(immutableServer).startSuccessor(); err == nil || !strings.Contains(err.Error(), "can not be handed off") {
+		t.Fatalf("expected the injected listener to be rejected, got %v", err)
+	}
+}
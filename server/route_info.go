@@ -0,0 +1,246 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type RouteInfo struct {
+	// Address is the configured address of the listener serving the route
+	Address   string   `json:"address,omitempty"`
+	Pattern   string   `json:"pattern"`
+	Methods   []string `json:"methods"`
+	ServiceId string   `json:"serviceId"`
+	// Shadowed means a later registered service has the same pattern and the route is never matched
+	Shadowed bool `json:"shadowed,omitempty"`
+	// Middlewares are the global middlewares followed by the service and route middlewares, keyed by method
+	Middlewares map[string][]string `json:"middlewares,omitempty"`
+}
+
+type RouteConflictKind string
+
+const (
+	// RouteShadowed means the same pattern is registered by several services and only the last one is routed to
+	RouteShadowed RouteConflictKind = "shadowed"
+	// RouteAmbiguous means the patterns only differ by path param names
+	RouteAmbiguous RouteConflictKind = "ambiguous"
+	// RouteOverlapping means some paths match both patterns, which one wins depends on the uri trie precedence
+	RouteOverlapping RouteConflictKind = "overlapping"
+)
+
+type RouteConflict struct {
+	Kind           RouteConflictKind `json:"kind"`
+	Pattern        string            `json:"pattern"`
+	ServiceId      string            `json:"serviceId"`
+	OtherPattern   string            `json:"otherPattern"`
+	OtherServiceId string            `json:"otherServiceId"`
+	Message        string            `json:"message"`
+}
+
+// RouteDescriber is implemented by services that can tell the middlewares of their routes.
+type RouteDescriber interface {
+	RouteMiddlewares(pattern, method string) []string
+}
+
+// StrictRoutes makes Build and runtime service changes fail on shadowed or ambiguous patterns across services
+// instead of logging them, overlapping patterns are always logged only.
+func (s *serverBuilder) StrictRoutes(strict bool) Builder {
+	s.strictRoutes = strict
+	return s
+}
+
+// RoutesAdmin serves the routes and route conflicts of the server(including the additional listeners) as JSON on path
+// of listener. listener must be added with AddListener, so the routes are only exposed on e.g. an internal address.
+func (s *serverBuilder) RoutesAdmin(listener Builder, path string) Builder {
+	s.routesAdminListener = listener
+	s.routesAdminPath = path
+	return s
+}
+
+// Routes lists the routes of the server followed by the ones of its additional listeners, each sorted by pattern.
+func (s immutableServer) Routes() []RouteInfo {
+	var routes []RouteInfo
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		routes = append(routes, svr.listenerRoutes()...)
+	}
+	return routes
+}
+
+// RouteConflicts lists the conflicting patterns across services of the server and its additional listeners.
+func (s immutableServer) RouteConflicts() []RouteConflict {
+	var conflicts []RouteConflict
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		conflicts = append(conflicts, svr.routes.Load().conflicts...)
+	}
+	return conflicts
+}
+
+func (s immutableServer) listenerRoutes() []RouteInfo {
+	globalMiddlewares := s.middlewareNames
+	services := s.routes.Load().services
+	routedServiceIds := make(map[string]string)
+	for _, service := range services {
+		for _, pattern := range service.UriPatterns() {
+			routedServiceIds[pattern] = service.Id()
+		}
+	}
+	var routes []RouteInfo
+	for _, service := range services {
+		for _, pattern := range service.UriPatterns() {
+			methods := service.SupportedMethodsForPattern(pattern)
+			sort.Strings(methods)
+			route := RouteInfo{
+				Address:     s.addr,
+				Pattern:     pattern,
+				Methods:     methods,
+				ServiceId:   service.Id(),
+				Shadowed:    routedServiceIds[pattern] != service.Id(),
+				Middlewares: make(map[string][]string),
+			}
+			describer, describable := service.(RouteDescriber)
+			for _, method := range methods {
+				names := append([]string(nil), globalMiddlewares...)
+				if describable {
+					names = append(names, describer.RouteMiddlewares(pattern, method)...)
+				}
+				route.Middlewares[method] = names
+			}
+			routes = append(routes, route)
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return routes[i].Pattern < routes[j].Pattern
+	})
+	return routes
+}
+
+type routesReport struct {
+	Routes    []RouteInfo     `json:"routes"`
+	Conflicts []RouteConflict `json:"conflicts"`
+}
+
+// newRoutesAdminService reports the routes of *svr, which is set once the server owning the listener is built
+func newRoutesAdminService(svr *immutableServer, path string) Service {
+	return NewServiceBuilder().
+		Id("aghs-routes-admin").
+		WithRouteHandlers(PathHandlerBuilder(path).Get(func(r Request) (Response, ServiceError) {
+			return NewResponse(http.StatusOK, routesReport{
+				Routes:    svr.Routes(),
+				Conflicts: svr.RouteConflicts(),
+			}), nil
+		})).
+		MustBuild()
+}
+
+// addRoutesAdmin adds the routes admin service to the route table of the listener
+func (s immutableServer) addRoutesAdmin(admin Service) error {
+	table, err := s.newRouteTable(append(s.routes.Load().services, admin))
+	if err != nil {
+		return err
+	}
+	s.routes.Store(table)
+	return nil
+}
+
+// diagnoseRoutes compares the patterns of every pair of services, patterns within one service are assumed intended.
+func diagnoseRoutes(services []Service) []RouteConflict {
+	var conflicts []RouteConflict
+	for i, service := range services {
+		for _, other := range services[:i] {
+			for _, pattern := range service.UriPatterns() {
+				for _, otherPattern := range other.UriPatterns() {
+					kind := comparePatterns(pattern, otherPattern)
+					if kind == "" {
+						continue
+					}
+					conflicts = append(conflicts, RouteConflict{
+						Kind:           kind,
+						Pattern:        pattern,
+						ServiceId:      service.Id(),
+						OtherPattern:   otherPattern,
+						OtherServiceId: other.Id(),
+						Message:        routeConflictMessage(kind, pattern, service.Id(), otherPattern, other.Id()),
+					})
+				}
+			}
+		}
+	}
+	return conflicts
+}
+
+func routeConflictMessage(kind RouteConflictKind, pattern, serviceId, otherPattern, otherServiceId string) string {
+	switch kind {
+	case RouteShadowed:
+		return fmt.Sprintf("pattern %s of service %s shadows the same pattern of service %s", pattern, serviceId, otherServiceId)
+	case RouteAmbiguous:
+		return fmt.Sprintf("pattern %s of service %s and pattern %s of service %s only differ by path param names", pattern, serviceId, otherPattern, otherServiceId)
+	default:
+		return fmt.Sprintf("pattern %s of service %s overlaps with pattern %s of service %s", pattern, serviceId, otherPattern, otherServiceId)
+	}
+}
+
+func comparePatterns(pattern, otherPattern string) RouteConflictKind {
+	if pattern == otherPattern {
+		return RouteShadowed
+	}
+	segments, otherSegments := patternSegments(pattern), patternSegments(otherPattern)
+	if normalizePatternSegments(segments) == normalizePatternSegments(otherSegments) {
+		return RouteAmbiguous
+	}
+	if patternsOverlap(segments, otherSegments) {
+		return RouteOverlapping
+	}
+	return ""
+}
+
+func patternSegments(pattern string) []string {
+	pattern = strings.Trim(pattern, "/")
+	if pattern == "" {
+		return nil
+	}
+	return strings.Split(pattern, "/")
+}
+
+// normalizePatternSegments drops the path param names
+func normalizePatternSegments(segments []string) string {
+	normalized := make([]string, len(segments))
+	for i, segment := range segments {
+		switch {
+		case isParamSegment(segment):
+			normalized[i] = ":"
+		case isWildcardSegment(segment):
+			normalized[i] = "*"
+		default:
+			normalized[i] = segment
+		}
+	}
+	return strings.Join(normalized, "/")
+}
+
+func patternsOverlap(segments, otherSegments []string) bool {
+	for i := 0; ; i++ {
+		if i == len(segments) || i == len(otherSegments) {
+			// a trailing wildcard also matches the empty remainder
+			return len(segments) == len(otherSegments) ||
+				(i < len(segments) && isWildcardSegment(segments[i])) ||
+				(i < len(otherSegments) && isWildcardSegment(otherSegments[i]))
+		}
+		segment, otherSegment := segments[i], otherSegments[i]
+		if isWildcardSegment(segment) || isWildcardSegment(otherSegment) {
+			return true
+		}
+		if !isParamSegment(segment) && !isParamSegment(otherSegment) && segment != otherSegment {
+			return false
+		}
+	}
+}
+
+func isParamSegment(segment string) bool {
+	return strings.HasPrefix(segment, ":")
+}
+
+func isWildcardSegment(segment string) bool {
+	return strings.HasPrefix(segment, "*")
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestComparePatterns(t *testing.T) {
+	cases := []struct {
+		pattern, other string
+		kind           RouteConflictKind
+	}{
+		{"/students", "/students", RouteShadowed},
+		{"/students/:id", "/students/:sid", RouteAmbiguous},
+		{"/students/:id", "/students/new", RouteOverlapping},
+		{"/files/*path", "/files/a/b", RouteOverlapping},
+		{"/files/*path", "/files", RouteOverlapping},
+		{"/students/:id", "/teachers/:id", ""},
+		{"/students/:id", "/students/:id/classes", ""},
+		{"/students", "/teachers", ""},
+	}
+	for _, c := range cases {
+		if kind := comparePatterns(c.pattern, c.other); kind != c.kind {
+			t.Errorf("expected %s and %s to be %q, got %q", c.pattern, c.other, c.kind, kind)
+		}
+	}
+}
+
+func newRouteTestServices() []Service {
+	return []Service{
+		NewServiceBuilder().Id("students").
+			WithRouteHandlers(PathHandlerBuilder("/students/:id").Get(okHandler).Delete(okHandler)).
+			WithRouteHandlers(PathHandlerBuilder("/health").Get(okHandler)).
+			MustBuild(),
+		NewServiceBuilder().Id("registry").
+			WithRouteHandlers(PathHandlerBuilder("/students/:sid").Post(okHandler)).
+			WithRouteHandlers(PathHandlerBuilder("/students/new").Get(okHandler)).
+			WithRouteHandlers(PathHandlerBuilder("/health").Get(okHandler)).
+			MustBuild(),
+	}
+}
+
+func okHandler(r Request) (Response, ServiceError) {
+	return NewResponse(http.StatusOK, nil), nil
+}
+
+func TestRouteDiagnostics(t *testing.T) {
+	svr, err := NewBuilder().WithServices(newRouteTestServices()).Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kinds := make(map[string]RouteConflictKind)
+	for _, conflict := range svr.RouteConflicts() {
+		if conflict.ServiceId != "registry" || conflict.OtherServiceId != "students" || conflict.Message == "" {
+			t.Fatalf("unexpected conflict %+v", conflict)
+		}
+		kinds[conflict.Pattern+" "+conflict.OtherPattern] = conflict.Kind
+	}
+	expected := map[string]RouteConflictKind{
+		"/students/:sid /students/:id": RouteAmbiguous,
+		"/students/new /students/:id":  RouteOverlapping,
+		"/health /health":              RouteShadowed,
+	}
+	if len(kinds) != len(expected) {
+		t.Fatalf("unexpected conflicts %v", kinds)
+	}
+	for patterns, kind := range expected {
+		if kinds[patterns] != kind {
+			t.Errorf("expected %s to be %s, got %q", patterns, kind, kinds[patterns])
+		}
+	}
+	shadowed := make(map[string]bool)
+	for _, route := range svr.Routes() {
+		shadowed[route.ServiceId+" "+route.Pattern] = route.Shadowed
+		if route.Pattern == "/students/:id" && strings.Join(route.Methods, ",") != "DELETE,GET" {
+			t.Errorf("unexpected methods %v", route.Methods)
+		}
+	}
+	if !shadowed["students /health"] || shadowed["registry /health"] || shadowed["students /students/:id"] {
+		t.Fatalf("unexpected shadowed routes %v", shadowed)
+	}
+}
+
+func TestStrictRoutes(t *testing.T) {
+	if _, err := NewBuilder().StrictRoutes(true).WithServices(newRouteTestServices()).Build(); err == nil {
+		t.Fatal("expected shadowed and ambiguous patterns to be rejected")
+	}
+	overlapping := NewServiceBuilder().Id("registry").WithRouteHandlers(PathHandlerBuilder("/students/new").Get(okHandler)).MustBuild()
+	if _, err := NewBuilder().StrictRoutes(true).WithServices([]Service{newRouteTestServices()[0], overlapping}).Build(); err != nil {
+		t.Fatalf("overlapping patterns must only be logged, got %v", err)
+	}
+}
+
+func TestRoutesAdmin(t *testing.T) {
+	adminBuilder, adminURL := listenTestServer(t, NewBuilder())
+	builder, url := listenTestServer(t, NewBuilder().WithServices(newRouteTestServices()))
+	svr := builder.AddListener(adminBuilder).RoutesAdmin(adminBuilder, "/admin/routes").MustBuild()
+	runTestServer(t, svr, url)
+	resp, err := http.Get(url + "/admin/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the routes admin to be hidden from the public listener, got %d", resp.StatusCode)
+	}
+	resp, err = http.Get(adminURL + "/admin/routes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var report routesReport
+	if err = json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || len(report.Conflicts) != 3 {
+		t.Fatalf("unexpected routes report %d %+v", resp.StatusCode, report)
+	}
+	served := make(map[string]bool)
+	for _, route := range report.Routes {
+		served[route.ServiceId+" "+route.Pattern] = true
+	}
+	if !served["students /students/:id"] || !served["aghs-routes-admin /admin/routes"] {
+		t.Fatalf("unexpected routes %+v", report.Routes)
+	}
+
+	if _, err = NewBuilder().RoutesAdmin(NewBuilder(), "/admin/routes").Build(); err == nil {
+		t.Fatal("expected a routes admin listener that is not added to be rejected")
+	}
+}
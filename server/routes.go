@@ -0,0 +1,186 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dlshle/gommon/uri_trie"
+)
+
+// routeTable is never modified once published, changes are made on a copy and swapped in atomically
+type routeTable struct {
+	uriTrie   *uri_trie.TrieTree
+	services  []Service
+	conflicts []RouteConflict
+}
+
+// newRouteTable routes to the services, conflicting patterns across services are logged or rejected in strict mode.
+func (s immutableServer) newRouteTable(services []Service) (*routeTable, error) {
+	table := &routeTable{uriTrie: uri_trie.NewTrieTree()}
+	serviceIdSet := make(map[string]bool)
+	for _, service := range services {
+		if serviceIdSet[service.Id()] {
+			return nil, fmt.Errorf("service %s already exists", service.Id())
+		}
+		serviceIdSet[service.Id()] = true
+		for _, pattern := range service.UriPatterns() {
+			if err := table.uriTrie.Add(pattern, service, true); err != nil {
+				return nil, fmt.Errorf("error while adding route %s from service %s: %v", pattern, service.Id(), err)
+			}
+		}
+		table.services = append(table.services, service)
+	}
+	table.conflicts = diagnoseRoutes(table.services)
+	for _, conflict := range table.conflicts {
+		if s.strictRoutes && conflict.Kind != RouteOverlapping {
+			return nil, fmt.Errorf("route conflict: %s", conflict.Message)
+		}
+		s.logger.Warnf(s.ctx, "route conflict: %s", conflict.Message)
+	}
+	return table, nil
+}
+
+// MutableServer can add, replace and remove services while serving, in-flight requests keep using the routes they were matched with.
+// Lifecycle services added to a running server are initialized and started before they are routed to,
+// and removed ones are stopped after they are no longer routed to. Updates wait for a Start in progress to have started the
+// services, so they must not be made from the Init or Start hooks.
+type MutableServer interface {
+	Server
+	AddService(Service) error
+	// ReplaceService replaces the service with the same id or adds it if there is none
+	ReplaceService(Service) error
+	RemoveService(id string) error
+	Services() []Service
+}
+
+type mutableServer struct {
+	immutableServer
+}
+
+func (s mutableServer) AddService(service Service) error {
+	return s.updateServices(service, func(services []Service) ([]Service, Service, error) {
+		if indexOfService(services, service.Id()) >= 0 {
+			return nil, nil, fmt.Errorf("service %s already exists", service.Id())
+		}
+		return append(services, service), nil, nil
+	})
+}
+
+func (s mutableServer) ReplaceService(service Service) error {
+	return s.updateServices(service, func(services []Service) ([]Service, Service, error) {
+		if index := indexOfService(services, service.Id()); index >= 0 {
+			replaced := services[index]
+			services[index] = service
+			return services, replaced, nil
+		}
+		return append(services, service), nil, nil
+	})
+}
+
+func (s mutableServer) RemoveService(id string) error {
+	return s.updateServices(nil, func(services []Service) ([]Service, Service, error) {
+		index := indexOfService(services, id)
+		if index < 0 {
+			return nil, nil, fmt.Errorf("service %s does not exist", id)
+		}
+		removed := services[index]
+		return append(services[:index], services[index+1:]...), removed, nil
+	})
+}
+
+func (s mutableServer) Services() []Service {
+	return append([]Service(nil), s.routes.Load().services...)
+}
+
+// updateServices swaps in the route table of the updated services, added and removed services are started and stopped around
+// the swap when the server is running.
+func (s mutableServer) updateServices(added Service, update func([]Service) ([]Service, Service, error)) error {
+	s.lifecycle.servicesLock.Lock()
+	defer s.lifecycle.servicesLock.Unlock()
+	services, removed, err := update(s.Services())
+	if err != nil {
+		return err
+	}
+	table, err := s.newRouteTable(services)
+	if err != nil {
+		return err
+	}
+	// also validates the dependencies of the updated services
+	lifecycleServices, err := s.orderedLifecycleServices(services)
+	if err != nil {
+		return err
+	}
+	running := s.lifecycle.running()
+	// a replacement has the id of the service it replaces, so it is told apart by identity
+	replacing := added != nil && removed != nil && !sameService(added, removed)
+	addedLifecycleService, ok := added.(LifecycleService)
+	startAdded := running && ok && (replacing || indexOfLifecycleService(s.startedServices(), added.Id()) < 0)
+	if startAdded {
+		if err = s.startServices([]LifecycleService{addedLifecycleService}); err != nil {
+			return err
+		}
+	}
+	s.routes.Store(table)
+	s.logger.Infof(s.ctx, "route table updated, serving %d services", len(table.services))
+	if !running {
+		return nil
+	}
+	if !s.lifecycle.setServices(lifecycleServices) {
+		// closed meanwhile, the close has stopped the previously started services
+		if startAdded {
+			s.stopServices([]LifecycleService{addedLifecycleService})
+		}
+		return ErrServerClosed
+	}
+	// the removed service may still be served by another listener
+	removedLifecycleService, ok := removed.(LifecycleService)
+	if ok && replacing && !s.servedByAdditionalServers(removed) {
+		s.stopServices([]LifecycleService{removedLifecycleService})
+	} else if ok && !replacing && indexOfLifecycleService(lifecycleServices, removed.Id()) < 0 {
+		s.stopServices([]LifecycleService{removedLifecycleService})
+	}
+	return nil
+}
+
+func (s mutableServer) servedByAdditionalServers(service Service) bool {
+	for _, additional := range s.additionalServers {
+		for _, served := range additional.routes.Load().services {
+			if sameService(served, service) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// sameService compares the service instances, services of uncomparable types are never the same
+func sameService(a, b Service) bool {
+	if !reflect.ValueOf(a).Comparable() || !reflect.ValueOf(b).Comparable() {
+		return false
+	}
+	return a == b
+}
+
+func (s mutableServer) startedServices() []LifecycleService {
+	s.lifecycle.lock.Lock()
+	defer s.lifecycle.lock.Unlock()
+	return s.lifecycle.services
+}
+
+func indexOfLifecycleService(services []LifecycleService, id string) int {
+	for i, service := range services {
+		if service.Id() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func indexOfService(services []Service, id string) int {
+	for i, service := range services {
+		if service.Id() == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type lifecycleRecorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *lifecycleRecorder) hook(event string) LifecycleHook {
+	return func(ctx context.Context) error {
+		r.lock.Lock()
+		defer r.lock.Unlock()
+		r.events = append(r.events, event)
+		return nil
+	}
+}
+
+func (r *lifecycleRecorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func (r *lifecycleRecorder) service(id, body string) Service {
+	return newTestService(id, "/hello", body).
+		OnInit(r.hook(body + " init")).
+		OnStart(r.hook(body + " start")).
+		OnStop(r.hook(body + " stop")).
+		MustBuild()
+}
+
+func getBody(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestReplaceServiceRestartsTheService(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	builder, url := listenTestServer(t, NewBuilder().WithService(recorder.service("hello", "v1")))
+	svr := builder.MustBuildMutable()
+	runTestServer(t, svr, url)
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"v1 init", "v1 start"}) {
+		t.Fatalf("unexpected start events %v", events)
+	}
+	if err := svr.ReplaceService(recorder.service("hello", "v2")); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"v2 init", "v2 start", "v1 stop"}) {
+		t.Fatalf("unexpected replace events %v", events)
+	}
+	if body := getBody(t, url+"/hello"); body != "v2" {
+		t.Fatalf("expected the replacement to be served, got %q", body)
+	}
+	// replacing a service with itself leaves it running
+	if err := svr.ReplaceService(svr.Services()[0]); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("unexpected events replacing a service with itself %v", events)
+	}
+}
+
+func TestRemoveServiceStopsTheService(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	builder, url := listenTestServer(t, NewBuilder().
+		WithService(recorder.service("hello", "v1")).
+		WithService(newTestService("other", "/other", "other").MustBuild()))
+	svr := builder.MustBuildMutable()
+	runTestServer(t, svr, url)
+	recorder.take()
+	if err := svr.RemoveService("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"v1 stop"}) {
+		t.Fatalf("unexpected remove events %v", events)
+	}
+	resp, err := http.Get(url + "/hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the removed service to be gone, got %d", resp.StatusCode)
+	}
+}
+
+func TestInFlightRequestKeepsItsRoutes(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := NewServiceBuilder().Id("slow").WithRouteHandlers(PathHandlerBuilder("/slow").Get(func(r Request) (Response, ServiceError) {
+		close(entered)
+		<-release
+		return NewPlainTextResponse(http.StatusOK, "v1"), nil
+	})).MustBuild()
+	builder, url := listenTestServer(t, NewBuilder().WithService(slow))
+	svr := builder.MustBuildMutable()
+	runTestServer(t, svr, url)
+	inFlight := make(chan string, 1)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inFlight <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inFlight <- string(body)
+	}()
+	<-entered
+	if err := svr.RemoveService("slow"); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get(url + "/slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the removed route to be gone for new requests, got %d", resp.StatusCode)
+	}
+	close(release)
+	if body := <-inFlight; body != "v1" {
+		t.Fatalf("expected the in-flight request to be served by the removed service, got %q", body)
+	}
+}
+
+func TestMutableServerServiceChanges(t *testing.T) {
+	svr := NewBuilder().WithService(newTestService("hello", "/hello", "hi").MustBuild()).MustBuildMutable()
+	if err := svr.AddService(newTestService("hello", "/other", "hi").MustBuild()); err == nil {
+		t.Fatal("expected a duplicate service id to be rejected")
+	}
+	if err := svr.AddService(newTestService("other", "/hello", "hi").MustBuild()); err != nil {
+		t.Fatalf("expected a shadowing service to be added, got %v", err)
+	}
+	if err := svr.RemoveService("missing"); err == nil {
+		t.Fatal("expected removing a missing service to fail")
+	}
+	strict := NewBuilder().StrictRoutes(true).WithService(newTestService("hello", "/hello", "hi").MustBuild()).MustBuildMutable()
+	if err := strict.AddService(newTestService("other", "/hello", "hi").MustBuild()); err == nil {
+		t.Fatal("expected a shadowing service to be rejected in strict mode")
+	}
+	if services := strict.Services(); len(services) != 1 || services[0].Id() != "hello" {
+		t.Fatalf("expected a rejected change to keep the routes, got %d services", len(services))
+	}
+}
+
+// TestConcurrentRouteSwaps serves requests while the routes are swapped, every request sees a complete route table
+func TestConcurrentRouteSwaps(t *testing.T) {
+	svr := NewBuilder().WithService(newTestService("hello", "/hello", "v0").MustBuild()).MustBuildMutable()
+	handler := svr.(http.Handler)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				recorder := httptest.NewRecorder()
+				handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/hello", nil))
+				if recorder.Code != http.StatusOK || !strings.HasPrefix(recorder.Body.String(), "v") {
+					t.Errorf("unexpected response %d %q", recorder.Code, recorder.Body.String())
+					return
+				}
+			}
+		}()
+	}
+	for i := 1; i <= 200; i++ {
+		if err := svr.ReplaceService(newTestService("hello", "/hello", "v"+strconv.Itoa(i)).MustBuild()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestAddServiceWhileStarting(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	entered, release := make(chan struct{}), make(chan struct{})
+	slow := newTestService("slow", "/slow", "slow").OnStart(func(ctx context.Context) error {
+		close(entered)
+		<-release
+		return nil
+	}).MustBuild()
+	builder, url := listenTestServer(t, NewBuilder().WithService(slow))
+	svr := builder.MustBuildMutable()
+	started := make(chan error, 1)
+	go func() {
+		started <- svr.Start()
+	}()
+	<-entered
+	added := make(chan error, 1)
+	go func() {
+		added <- svr.AddService(newTestService("added", "/added", "added").
+			OnStart(recorder.hook("added start")).
+			OnStop(recorder.hook("added stop")).
+			MustBuild())
+	}()
+	select {
+	case err := <-added:
+		t.Fatalf("the service was added before the started services were recorded: %v", err)
+	case <-time.After(time.Millisecond * 100):
+	}
+	close(release)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if body := getBody(t, url+"/added"); body != "added" {
+		t.Fatalf("expected the added service to be served, got %q", body)
+	}
+	// the added service is among the started services stopped with the server
+	if err := svr.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-started; err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"added start", "added stop"}) {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
@@ -2,27 +2,149 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
-	"runtime/debug"
+	"os"
+	"os/signal"
+	"reflect"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/dlshle/gommon/logging"
 	"github.com/dlshle/gommon/uri_trie"
 )
 
+var (
+	ErrServerStarted = errors.New("server has already been started")
+	ErrServerClosed  = errors.New("server has been shut down")
+)
+
 type Server interface {
 	Start() error
+	// Shutdown stops accepting new connections and drains in-flight requests until ctx is done.
+	Shutdown(ctx context.Context) error
+	// Stop closes the server without draining in-flight requests.
+	Stop() error
+	Routes() []RouteInfo
+	RouteConflicts() []RouteConflict
 }
 
 type immutableServer struct {
-	ctx                   context.Context
-	engine                Engine
-	addr                  string
-	uriTrie               *uri_trie.TrieTree
-	middlewares           []Middleware
-	logger                logging.Logger
-	attachContextForError bool
+	ctx                     context.Context
+	engine                  Engine
+	addr                    string
+	listener                net.Listener
+	routes                  *atomic.Pointer[routeTable]
+	middlewares             []Middleware
+	middlewareNames         []string
+	logger                  logging.Logger
+	attachContextForError   bool
+	shutdownSignals         []os.Signal
+	shutdownTimeout         time.Duration
+	tlsConfig               *tls.Config
+	httpsRedirectAddr       string
+	certManager             *CertificateManager
+	http2Config             *HTTP2Config
+	fastHTTPConfig          *FastHTTPConfig
+	readTimeout             time.Duration
+	readHeaderTimeout       time.Duration
+	writeTimeout            time.Duration
+	idleTimeout             time.Duration
+	maxHeaderBytes          int
+	maxConnsPerIP           int
+	additionalServers       []immutableServer
+	restartSignals          []os.Signal
+	restartDrainTimeout     time.Duration
+	proxyProtocolTrusted    []*net.IPNet
+	serviceStartTimeout     time.Duration
+	serviceStopTimeout      time.Duration
+	strictRoutes            bool
+	notFoundHandler         RequestHandler
+	methodNotAllowedHandler RequestHandler
+	panicHandler            PanicHandler
+	errorReporter           ErrorReporter
+	spanExporter            SpanExporter
+	serverTiming            bool
+	lifecycle               *serverLifecycle
+}
+
+type serverLifecycle struct {
+	lock *sync.Mutex
+	// servicesLock serializes the start of the server with the route updates of a mutable server
+	servicesLock *sync.Mutex
+	started      bool
+	closed       bool
+	servings     []serving
+	// services are the started lifecycle services in start order
+	services []LifecycleService
+	// webSockets are the open websocket connections, nil once the server is closed
+	webSockets map[*WebSocketConn]struct{}
+	// streamCtx is cancelled when the server starts closing, so that long-lived streams don't hold the shutdown
+	streamCtx     context.Context
+	cancelStreams context.CancelFunc
+	done          chan struct{}
+}
+
+type serving struct {
+	// addr is the configured address of the listener, empty for injected listeners
+	addr         string
+	listener     net.Listener
+	engineServer EngineServer
+}
+
+func newServerLifecycle() *serverLifecycle {
+	lifecycle := &serverLifecycle{
+		lock:         new(sync.Mutex),
+		servicesLock: new(sync.Mutex),
+		webSockets:   make(map[*WebSocketConn]struct{}),
+		done:         make(chan struct{}),
+	}
+	lifecycle.streamCtx, lifecycle.cancelStreams = context.WithCancel(context.Background())
+	return lifecycle
+}
+
+func (l *serverLifecycle) claim() error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return ErrServerClosed
+	}
+	if l.started {
+		return ErrServerStarted
+	}
+	l.started = true
+	return nil
+}
+
+// release gives up a failed start so that the server can be started again
+func (l *serverLifecycle) release() {
+	l.lock.Lock()
+	l.started = false
+	l.lock.Unlock()
+}
+
+// setServices updates the started services of a running server, it returns false if the server has been closed
+func (l *serverLifecycle) setServices(services []LifecycleService) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.closed {
+		return false
+	}
+	l.services = services
+	return true
+}
+
+func (l *serverLifecycle) running() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.started && !l.closed
 }
 
 func (s immutableServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -33,121 +155,521 @@ func (s immutableServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 }
 
 func (s immutableServer) HandleHTTP(w http.ResponseWriter, req *http.Request) (err error) {
+	return s.handle(netResponseWriter{w}, req.RequestURI, func(matchCtx *uri_trie.MatchContext) Request {
+		return s.buildRequest(req, matchCtx)
+	})
+}
+
+func (s immutableServer) handle(w responseWriter, uri string, requestBuilder func(*uri_trie.MatchContext) Request) (err error) {
 	defer func() {
-		// in case of any panic
+		// panics while responding
 		if recoveredPanic := recover(); recoveredPanic != nil {
-			err = fmt.Errorf("%v", recoveredPanic)
-			debug.PrintStack()
-			s.respondWithError(w, InternalError(err.Error()), nil, nil)
+			_, serviceErr := s.handlePanic(nil, uri, recoveredPanic)
+			if serviceErr == nil {
+				serviceErr = InternalError(fmt.Sprintf("%v", recoveredPanic))
+			}
+			err = s.respondWithError(w, serviceErr, nil, nil)
 		}
 	}()
-	uri := req.RequestURI
-	matchCtx, err := s.uriTrie.Match(uri)
-	if err != nil {
-		return s.respondWithError(w, NotFoundError(fmt.Sprintf("route %s is undefined", uri)), nil, nil)
+	var (
+		handler RequestHandler
+		service Service
+	)
+	matchCtx, matchErr := s.routes.Load().uriTrie.Match(uri)
+	if matchErr != nil {
+		matchCtx = unmatchedContext(uri)
+		handler = s.notFoundHandler
+	} else {
+		service = matchCtx.Value.(Service)
+		handler = s.serviceHandler(service)
 	}
-	serverRequest := s.buildRequest(req, matchCtx)
-	middlewares := append(s.middlewares, wrapHandlerAsMiddleware(matchCtx.Value.(Service).Handle))
-	resp, serviceErr := runMiddlewares(middlewares, serverRequest)
+	serverRequest := requestBuilder(matchCtx)
+	if holder, ok := serverRequest.(serverContextHolder); ok {
+		holder.setServerContext(s.lifecycle.streamCtx)
+	}
+	spans := s.startSpans(serverRequest)
+	resp, serviceErr := s.runMiddlewares(serverRequest, handler, handlerSpanName(service))
 	defer func() {
 		// matchCtx.Recycle()
-		serverRequest.(*request).recycle()
-		resp.(*response).recycle()
+		if r, ok := serverRequest.(recyclable); ok {
+			r.recycle()
+		}
+		if r, ok := resp.(recyclable); ok {
+			r.recycle()
+		}
 	}()
+	// service handler(core handler) will set middleware.Ctx.response to nil when it doesn't have proper handler operation?
+	if serviceErr == nil && resp == nil {
+		serviceErr = InternalError("invalid handler operation")
+	}
+	s.reportError(serverRequest, resp, serviceErr)
+	if observer, ok := serverRequest.(responseWrittenObserver); ok {
+		if callbacks := observer.takeWrittenCallbacks(); len(callbacks) > 0 {
+			counting := &countingResponseWriter{responseWriter: w, callbacks: callbacks}
+			w = counting
+			defer counting.finish()
+		}
+	}
+	if spans != nil {
+		if s.serverTiming {
+			w.SetHeader(ServerTimingHeader, spans.serverTiming())
+		}
+		writeSpan := spans.start("write")
+		// exported before the request and response are recycled
+		defer func() {
+			spans.end(writeSpan)
+			s.exportSpans(spans, serverRequest, resp, serviceErr)
+		}()
+	}
 	if serviceErr != nil {
 		return s.respondWithError(w, serviceErr, resp, serverRequest.Context())
 	}
-	// service handler(core handler) will set middleware.Ctx.response to nil when it doesn't have proper handler operation?
-	if resp == nil {
-		return s.respondWithError(w, InternalError("invalid handler operation"), resp, serverRequest.Context())
+	if upgrade, ok := resp.(*upgradeResponse); ok {
+		return s.respondWithUpgrade(w, upgrade)
+	}
+	if stream, ok := resp.(*streamResponse); ok {
+		return s.respondWithStream(w, stream, serverRequest.Method() == http.MethodHead)
 	}
-	err = s.respondWithServiceResponse(w, resp)
+	err = s.respondWithServiceResponse(w, resp, serverRequest.Method() == http.MethodHead)
 	if err != nil {
-		return s.respondWithError(w, InternalError(err.Error()), resp, serverRequest.Context())
+		serviceErr = InternalError(err.Error())
+		s.reportError(serverRequest, resp, serviceErr)
+		return s.respondWithError(w, serviceErr, resp, serverRequest.Context())
 	}
 	return err
 }
 
+// runMiddlewares runs the global middlewares followed by handler, global middlewares run for unmatched routes too
+func (s immutableServer) runMiddlewares(request Request, handler RequestHandler, handlerName string) (resp Response, serviceErr ServiceError) {
+	// cap the slice so that concurrent requests never append into the same backing array
+	middlewares := append(s.middlewares[:len(s.middlewares):len(s.middlewares)], wrapHandlerAsMiddleware(handler))
+	var names []string
+	if requestSpanRecorder(request) != nil {
+		names = append(s.middlewareNames[:len(s.middlewareNames):len(s.middlewareNames)], handlerName)
+	}
+	ctx := makeMiddlewareContext(middlewares, names, request)
+	defer ctx.recycle()
+	defer func() {
+		if recoveredPanic := recover(); recoveredPanic != nil {
+			// a global middleware panicked, the rest of the chain is skipped
+			resp, serviceErr = s.handlePanic(request, "", recoveredPanic)
+			resp = keepMiddlewareHeaders(resp, serviceErr, ctx.Response())
+		}
+	}()
+	ctx.Next()
+	return ctx.Response(), ctx.Error()
+}
+
+// keepMiddlewareHeaders copies the headers the middlewares set on middlewareResp, e.g. in a defer while the panic
+// unwound them, to the panic response resp unless resp sets them itself
+func keepMiddlewareHeaders(resp Response, serviceErr ServiceError, middlewareResp Response) Response {
+	if resp == nil {
+		if serviceErr == nil {
+			return nil
+		}
+		// only the headers of the response are sent along with an error
+		resp = NewResponse(serviceErr.Code(), nil)
+	}
+	middlewareResp.IterateHeaders(func(k, v string) {
+		if set, _ := resp.GetHeader(k); !set {
+			resp.SetHeader(k, v)
+		}
+	})
+	return resp
+}
+
 func (s immutableServer) buildRequest(r *http.Request, matchCtx *uri_trie.MatchContext) Request {
-	return NewRequest(r, matchCtx.Value.(Service), matchCtx.UriPattern, matchCtx.QueryParams, matchCtx.PathParams)
+	// the service is nil for unmatched routes
+	service, _ := matchCtx.Value.(Service)
+	return NewRequest(r, service, matchCtx.UriPattern, matchCtx.QueryParams, matchCtx.PathParams)
 }
 
-func (s immutableServer) respondWithError(w http.ResponseWriter, serviceErr ServiceError, resp Response, requestCtx context.Context) (err error) {
+func (s immutableServer) respondWithError(w responseWriter, serviceErr ServiceError, resp Response, requestCtx context.Context) (err error) {
 	if s.attachContextForError {
 		serviceErr.AttachContext(requestCtx)
 	}
-	w.Header().Set("Content-Type", serviceErr.ContentType())
-	w.WriteHeader(serviceErr.Code())
 	if resp != nil {
 		resp.IterateHeaders(func(k, v string) {
-			w.Header().Set(k, v)
+			w.SetHeader(k, v)
 		})
 	}
+	w.SetHeader("Content-Type", serviceErr.ContentType())
+	w.WriteHeader(serviceErr.Code())
 	_, err = w.Write([]byte(serviceErr.Error()))
 	return
 }
 
-func (s immutableServer) respondWithServiceResponse(w http.ResponseWriter, r Response) (err error) {
+// respondWithServiceResponse only sends the headers for HEAD requests, with the length the body would have
+func (s immutableServer) respondWithServiceResponse(w responseWriter, r Response, headOnly bool) (err error) {
 	if r.Code() == 0 {
 		return fmt.Errorf("invalid payload")
 	}
 	if r.ContentType() != "" {
-		w.Header().Set("Content-Type", r.ContentType())
+		w.SetHeader("Content-Type", r.ContentType())
 	}
 	r.IterateHeaders(func(k string, v string) {
-		w.Header().Set(k, v)
+		w.SetHeader(k, v)
 	})
-	w.WriteHeader(r.Code())
 	if r.Code() == http.StatusNoContent {
+		w.WriteHeader(r.Code())
 		return
 	}
 	stream, err := r.PayloadStream()
 	if err != nil {
 		return err
 	}
+	if headOnly {
+		w.SetHeader("Content-Length", strconv.Itoa(len(stream)))
+		w.WriteHeader(r.Code())
+		return
+	}
+	w.WriteHeader(r.Code())
 	_, err = w.Write(stream)
 	return
 }
 
 func (s immutableServer) Start() error {
-	addr := s.addr
-	s.logger.Infof(s.ctx, "starting the server on %s with TCP protocol...", addr)
-	listener, err := net.Listen("tcp", addr)
+	// the route updates of a mutable server wait until the started services are recorded, or they would be overwritten
+	s.lifecycle.servicesLock.Lock()
+	servings, err := s.start()
+	s.lifecycle.servicesLock.Unlock()
 	if err != nil {
-		s.logger.Errorf(s.ctx, "error starting server at addr %s: %s", addr, err.Error())
 		return err
 	}
-	return s.startEngine(listener)
+	return s.serve(servings)
+}
+
+// start binds the listeners and starts the lifecycle services, it returns the servings to serve
+func (s immutableServer) start() ([]serving, error) {
+	if err := s.lifecycle.claim(); err != nil {
+		return nil, err
+	}
+	services, err := s.lifecycleServices()
+	if err != nil {
+		s.lifecycle.release()
+		return nil, err
+	}
+	servings, err := s.listen()
+	if err != nil {
+		s.lifecycle.release()
+		return nil, err
+	}
+	for _, additional := range s.additionalServers {
+		additionalServings, err := additional.listen()
+		if err != nil {
+			closeServings(servings)
+			s.lifecycle.release()
+			return nil, err
+		}
+		servings = append(servings, additionalServings...)
+	}
+	// the listeners are bound first so that start-up fails fast on unavailable addresses, connections queue up meanwhile
+	if err = s.startServices(services); err != nil {
+		s.logger.Errorf(s.ctx, "aborting server start: %s", err.Error())
+		closeServings(servings)
+		s.lifecycle.release()
+		return nil, err
+	}
+	s.lifecycle.lock.Lock()
+	defer s.lifecycle.lock.Unlock()
+	if s.lifecycle.closed {
+		// closed while starting
+		closeServings(servings)
+		s.stopServices(services)
+		return nil, ErrServerClosed
+	}
+	s.lifecycle.servings = servings
+	s.lifecycle.services = services
+	return servings, nil
+}
+
+func (s immutableServer) listen() ([]serving, error) {
+	protocol := "TCP"
+	if s.tlsConfig != nil {
+		protocol = "TLS"
+	}
+	listener := s.listener
+	if listener == nil {
+		addr := s.addr
+		s.logger.Infof(s.ctx, "starting the server on %s with %s protocol...", addr, protocol)
+		var err error
+		listener, err = s.listenAddr(addr)
+		if err != nil {
+			s.logger.Errorf(s.ctx, "error starting server at addr %s: %s", addr, err.Error())
+			return nil, err
+		}
+	} else {
+		s.logger.Infof(s.ctx, "starting the server on listener %s with %s protocol...", listener.Addr().String(), protocol)
+	}
+	if len(s.proxyProtocolTrusted) > 0 {
+		listener = newProxyProtocolListener(listener, s.proxyProtocolTrusted)
+	}
+	// limits the clients behind a proxy by the address of their PROXY header
+	if s.maxConnsPerIP > 0 {
+		listener = newPerIPLimitListener(listener, s.maxConnsPerIP)
+	}
+	servingAddr := s.addr
+	if s.listener != nil {
+		servingAddr = ""
+	}
+	servings := []serving{{servingAddr, listener, s.engine(s, s.engineConfig())}}
+	if s.tlsConfig != nil && s.httpsRedirectAddr != "" {
+		redirectServing, err := s.httpsRedirectServing(listener.Addr())
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		servings = append(servings, redirectServing)
+	}
+	return servings, nil
+}
+
+// listenAddr takes over the listener handed off by the previous process on restart before listening on addr.
+func (s immutableServer) listenAddr(addr string) (net.Listener, error) {
+	listener, err := takeInheritedListener(addr)
+	if listener != nil || err != nil {
+		if err == nil {
+			s.logger.Infof(s.ctx, "took over the listener on %s from the previous process", addr)
+		}
+		return listener, err
+	}
+	return Listen(addr)
+}
+
+func closeServings(servings []serving) {
+	for _, serving := range servings {
+		serving.listener.Close()
+	}
+}
+
+func (s immutableServer) engineConfig() EngineConfig {
+	return EngineConfig{
+		TLSConfig:         s.tlsConfig,
+		ReadTimeout:       s.readTimeout,
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
+		MaxHeaderBytes:    s.maxHeaderBytes,
+		HTTP2:             s.http2Config,
+		FastHTTP:          s.fastHTTPConfig,
+	}
+}
+
+func (s immutableServer) serve(servings []serving) error {
+	if len(s.shutdownSignals) > 0 {
+		go s.shutdownOnSignals()
+	}
+	if len(s.restartSignals) > 0 {
+		go s.restartOnSignals()
+	}
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		if svr.certManager != nil {
+			svr.certManager.startWatching()
+			defer svr.certManager.stopWatching()
+		}
+	}
+	errChan := make(chan error, len(servings))
+	for _, srv := range servings {
+		go func(srv serving) {
+			errChan <- srv.engineServer.Serve(srv.listener)
+		}(srv)
+	}
+	notifyRestartReady()
+	var err error
+	for range servings {
+		if serveErr := <-errChan; serveErr != nil && err == nil {
+			s.logger.Errorf(s.ctx, "server stopped serving due to %s", serveErr.Error())
+			err = serveErr
+			// bring down the rest of the listeners as they share one lifecycle
+			go s.Stop()
+		}
+	}
+	s.lifecycle.lock.Lock()
+	closed := s.lifecycle.closed
+	s.lifecycle.lock.Unlock()
+	if closed {
+		// wait for in-flight requests to be drained before returning
+		<-s.lifecycle.done
+	}
+	return err
+}
+
+func (s immutableServer) shutdownOnSignals() {
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, s.shutdownSignals...)
+	defer signal.Stop(signalChan)
+	select {
+	case sig := <-signalChan:
+		s.logger.Infof(s.ctx, "received signal %s, shutting down the server within %s...", sig.String(), s.shutdownTimeout.String())
+		ctx, cancel := context.WithTimeout(s.ctx, s.shutdownTimeout)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			s.logger.Errorf(s.ctx, "error while shutting down the server: %s", err.Error())
+		}
+	case <-s.lifecycle.done:
+	}
+}
+
+func (s immutableServer) Shutdown(ctx context.Context) error {
+	return s.close(func(engineServer EngineServer) error {
+		return engineServer.Shutdown(ctx)
+	})
+}
+
+func (s immutableServer) Stop() error {
+	return s.close(func(engineServer EngineServer) error {
+		return engineServer.Close()
+	})
+}
+
+func (s immutableServer) close(closeEngine func(EngineServer) error) error {
+	s.lifecycle.lock.Lock()
+	if s.lifecycle.closed {
+		s.lifecycle.lock.Unlock()
+		return ErrServerClosed
+	}
+	s.lifecycle.closed = true
+	servings := s.lifecycle.servings
+	services := s.lifecycle.services
+	s.lifecycle.services = nil
+	s.lifecycle.lock.Unlock()
+	defer close(s.lifecycle.done)
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		svr.lifecycle.cancelStreams()
+	}
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for _, srv := range servings {
+		wg.Add(1)
+		go func(srv serving) {
+			defer wg.Done()
+			s.logger.Infof(s.ctx, "shutting down the server on %s...", srv.listener.Addr().String())
+			if err := closeEngine(srv.engineServer); err != nil {
+				errOnce.Do(func() {
+					firstErr = err
+				})
+			}
+			// in case the engine has not started accepting on the listener yet
+			srv.listener.Close()
+		}(srv)
+	}
+	wg.Wait()
+	// hijacked connections are not closed by the engines
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		svr.lifecycle.closeWebSockets()
+	}
+	s.stopServices(services)
+	// the listeners may share their reporter or exporter
+	closed := make(closerSet)
+	for _, svr := range append([]immutableServer{s}, s.additionalServers...) {
+		svr.closeErrorReporter(closed)
+		svr.closeSpanExporter(closed)
+	}
+	return firstErr
 }
 
-func (s immutableServer) startEngine(listener net.Listener) error {
-	return s.engine(listener, s)
+// closerSet keeps the closers closed already so that each one is closed once
+type closerSet map[io.Closer]bool
+
+// add returns false when closer has been added before, closers of types that can't be compared are always added
+func (c closerSet) add(closer io.Closer) bool {
+	if !reflect.TypeOf(closer).Comparable() {
+		return true
+	}
+	if c[closer] {
+		return false
+	}
+	c[closer] = true
+	return true
 }
 
 type Builder interface {
 	Engine(engine Engine) Builder
 	Context(context.Context) Builder
 	Address(string) Builder
+	Listener(net.Listener) Builder
 	WithServices([]Service) Builder
 	WithService(Service) Builder
 	WithMiddlewares([]Middleware) Builder
 	WithMiddleware(Middleware) Builder
 	Logger(logging.Logger) Builder
 	AttachContextForError(bool) Builder
+	ShutdownOnSignals(timeout time.Duration, signals ...os.Signal) Builder
+	TLS(certFile, keyFile string) Builder
+	TLSConfig(*tls.Config) Builder
+	SelfSignedTLS(hosts ...string) Builder
+	HTTPSRedirect(addr string) Builder
+	CertificateManager(*CertificateManager) Builder
+	HTTP2(HTTP2Config) Builder
+	FastHTTP(FastHTTPConfig) Builder
+	ReadTimeout(time.Duration) Builder
+	ReadHeaderTimeout(time.Duration) Builder
+	WriteTimeout(time.Duration) Builder
+	IdleTimeout(time.Duration) Builder
+	MaxHeaderBytes(int) Builder
+	MaxConnsPerIP(int) Builder
+	AddListener(Builder) Builder
+	RestartOnSignals(drainTimeout time.Duration, signals ...os.Signal) Builder
+	ProxyProtocol(trustedCIDRs ...string) Builder
+	ServiceTimeouts(startTimeout, stopTimeout time.Duration) Builder
+	StrictRoutes(bool) Builder
+	RoutesAdmin(listener Builder, path string) Builder
 	Build() (Server, error)
 	MustBuild() Server
+	BuildMutable() (MutableServer, error)
+	MustBuildMutable() MutableServer
+	NotFoundHandler(RequestHandler) Builder
+	MethodNotAllowedHandler(RequestHandler) Builder
+	PanicHandler(PanicHandler) Builder
+	ErrorReporter(ErrorReporter) Builder
+	SpanExporter(SpanExporter) Builder
+	ServerTiming(bool) Builder
+	WithNamedMiddleware(name string, middleware Middleware) Builder
 }
 
 type serverBuilder struct {
-	ctx                   context.Context
-	engine                Engine
-	addr                  string
-	uriTrie               *uri_trie.TrieTree
-	middlewares           []Middleware
-	logger                logging.Logger
-	attachContextForError bool
-	serviceIdSet          map[string]bool
-	err                   error
+	ctx                     context.Context
+	engine                  Engine
+	addr                    string
+	listener                net.Listener
+	middlewares             []Middleware
+	middlewareNames         []string
+	logger                  logging.Logger
+	attachContextForError   bool
+	shutdownSignals         []os.Signal
+	shutdownTimeout         time.Duration
+	tlsConfig               *tls.Config
+	httpsRedirectAddr       string
+	certManager             *CertificateManager
+	http2Config             *HTTP2Config
+	fastHTTPConfig          *FastHTTPConfig
+	readTimeout             time.Duration
+	readHeaderTimeout       time.Duration
+	writeTimeout            time.Duration
+	idleTimeout             time.Duration
+	maxHeaderBytes          int
+	maxConnsPerIP           int
+	additionalListeners     []Builder
+	restartSignals          []os.Signal
+	restartDrainTimeout     time.Duration
+	proxyProtocolTrusted    []*net.IPNet
+	serviceStartTimeout     time.Duration
+	serviceStopTimeout      time.Duration
+	strictRoutes            bool
+	routesAdminListener     Builder
+	routesAdminPath         string
+	notFoundHandler         RequestHandler
+	methodNotAllowedHandler RequestHandler
+	panicHandler            PanicHandler
+	errorReporter           ErrorReporter
+	spanExporter            SpanExporter
+	serverTiming            bool
+	serviceIdSet            map[string]bool
+	services                []Service
+	err                     error
 }
 
 func (s *serverBuilder) Context(ctx context.Context) Builder {
@@ -165,6 +687,12 @@ func (s *serverBuilder) Address(addr string) Builder {
 	return s
 }
 
+// Listener makes the server serve on an already bound listener instead of listening on the address.
+func (s *serverBuilder) Listener(listener net.Listener) Builder {
+	s.listener = listener
+	return s
+}
+
 func (s *serverBuilder) WithServices(services []Service) Builder {
 	for _, svc := range services {
 		if !s.addService(svc) {
@@ -181,11 +709,13 @@ func (s *serverBuilder) WithService(svc Service) Builder {
 
 func (s *serverBuilder) WithMiddlewares(middlewares []Middleware) Builder {
 	s.middlewares = append(s.middlewares, middlewares...)
+	s.middlewareNames = append(s.middlewareNames, middlewareNames(middlewares, nil)...)
 	return s
 }
 
 func (s *serverBuilder) WithMiddleware(middleware Middleware) Builder {
 	s.middlewares = append(s.middlewares, middleware)
+	s.middlewareNames = append(s.middlewareNames, MiddlewareName(middleware))
 	return s
 }
 
@@ -199,19 +729,158 @@ func (s *serverBuilder) AttachContextForError(attach bool) Builder {
 	return s
 }
 
+// ReadTimeout bounds reading a whole request, header and body. With a ReadHeaderTimeout, FastHTTPEngine starts the
+// ReadTimeout once the header is read instead of when the request begins.
+func (s *serverBuilder) ReadTimeout(timeout time.Duration) Builder {
+	s.readTimeout = timeout
+	return s
+}
+
+// ReadHeaderTimeout bounds reading the request header, ReadTimeout applies to the header when it is 0.
+func (s *serverBuilder) ReadHeaderTimeout(timeout time.Duration) Builder {
+	s.readHeaderTimeout = timeout
+	return s
+}
+
+func (s *serverBuilder) WriteTimeout(timeout time.Duration) Builder {
+	s.writeTimeout = timeout
+	return s
+}
+
+func (s *serverBuilder) IdleTimeout(timeout time.Duration) Builder {
+	s.idleTimeout = timeout
+	return s
+}
+
+// MaxHeaderBytes limits the size of the request header. NetEngine lets 4KB more through as net/http does, FastHTTPEngine
+// limits the header by its read buffer, allocated per connection, so it refuses to serve with more than 64KB and it
+// conflicts with a different FastHTTPConfig.ReadBufferSize.
+func (s *serverBuilder) MaxHeaderBytes(size int) Builder {
+	s.maxHeaderBytes = size
+	return s
+}
+
+// MaxConnsPerIP limits the number of concurrent connections from one remote IP, extra connections are closed right after accept.
+// With ProxyProtocol the limit applies to the client address of the PROXY header, checked when it has been read.
+// Unix socket clients have no IP and are not limited.
+func (s *serverBuilder) MaxConnsPerIP(limit int) Builder {
+	s.maxConnsPerIP = limit
+	return s
+}
+
+// AddListener serves another listener with its own address, services and middlewares built by listenerBuilder.
+// All the listeners start together and shut down together with this server, signals are only handled by this server.
+func (s *serverBuilder) AddListener(listenerBuilder Builder) Builder {
+	s.additionalListeners = append(s.additionalListeners, listenerBuilder)
+	return s
+}
+
+// ShutdownOnSignals makes the server shut down gracefully within timeout on any of the signals(SIGTERM and SIGINT by default).
+func (s *serverBuilder) ShutdownOnSignals(timeout time.Duration, signals ...os.Signal) Builder {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+	}
+	s.shutdownSignals = signals
+	s.shutdownTimeout = timeout
+	return s
+}
+
 func (s *serverBuilder) Build() (Server, error) {
 	if s.err != nil {
 		return nil, s.err
 	}
-	return immutableServer{
-		ctx:                   s.ctx,
-		engine:                s.engine,
-		addr:                  s.addr,
-		uriTrie:               s.uriTrie,
-		middlewares:           s.middlewares,
-		logger:                s.logger,
-		attachContextForError: s.attachContextForError,
-	}, nil
+	tlsConfig := s.tlsConfig
+	if s.certManager != nil {
+		s.certManager.setLogger(s.ctx, s.logger)
+		// the config may be shared by the caller, the server gets its own copy
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		} else {
+			tlsConfig = tlsConfig.Clone()
+		}
+		tlsConfig.GetCertificate = s.certManager.GetCertificate
+	}
+	if s.httpsRedirectAddr != "" && tlsConfig == nil {
+		return nil, fmt.Errorf("https redirect requires TLS to be configured")
+	}
+	var (
+		additionalServers []immutableServer
+		routesAdminServer = new(immutableServer)
+		routesAdminAdded  bool
+	)
+	for _, listenerBuilder := range s.additionalListeners {
+		svr, err := listenerBuilder.Build()
+		if err != nil {
+			return nil, err
+		}
+		additional, ok := svr.(immutableServer)
+		if !ok {
+			return nil, fmt.Errorf("unsupported listener server type %T", svr)
+		}
+		if s.routesAdminListener != nil && listenerBuilder == s.routesAdminListener && !routesAdminAdded {
+			if err = additional.addRoutesAdmin(newRoutesAdminService(routesAdminServer, s.routesAdminPath)); err != nil {
+				return nil, err
+			}
+			routesAdminAdded = true
+		}
+		// additional listeners are served under the lifecycle of this server
+		additionalServers = append(additionalServers, additional)
+		additionalServers = append(additionalServers, additional.additionalServers...)
+	}
+	svr := immutableServer{
+		ctx:                     s.ctx,
+		engine:                  s.engine,
+		addr:                    s.addr,
+		listener:                s.listener,
+		routes:                  new(atomic.Pointer[routeTable]),
+		middlewares:             s.middlewares,
+		middlewareNames:         s.middlewareNames,
+		logger:                  s.logger,
+		attachContextForError:   s.attachContextForError,
+		shutdownSignals:         s.shutdownSignals,
+		shutdownTimeout:         s.shutdownTimeout,
+		tlsConfig:               tlsConfig,
+		httpsRedirectAddr:       s.httpsRedirectAddr,
+		certManager:             s.certManager,
+		http2Config:             s.http2Config,
+		fastHTTPConfig:          s.fastHTTPConfig,
+		readTimeout:             s.readTimeout,
+		readHeaderTimeout:       s.readHeaderTimeout,
+		writeTimeout:            s.writeTimeout,
+		idleTimeout:             s.idleTimeout,
+		maxHeaderBytes:          s.maxHeaderBytes,
+		maxConnsPerIP:           s.maxConnsPerIP,
+		additionalServers:       additionalServers,
+		restartSignals:          s.restartSignals,
+		restartDrainTimeout:     s.restartDrainTimeout,
+		proxyProtocolTrusted:    s.proxyProtocolTrusted,
+		serviceStartTimeout:     s.serviceStartTimeout,
+		serviceStopTimeout:      s.serviceStopTimeout,
+		strictRoutes:            s.strictRoutes,
+		notFoundHandler:         s.notFoundHandler,
+		methodNotAllowedHandler: s.methodNotAllowedHandler,
+		panicHandler:            s.panicHandler,
+		errorReporter:           s.errorReporter,
+		spanExporter:            s.spanExporter,
+		serverTiming:            s.serverTiming,
+		lifecycle:               newServerLifecycle(),
+	}
+	if svr.notFoundHandler == nil {
+		svr.notFoundHandler = defaultNotFoundHandler
+	}
+	if svr.panicHandler == nil {
+		svr.panicHandler = svr.defaultPanicHandler
+	}
+	if s.routesAdminListener != nil && !routesAdminAdded {
+		return nil, fmt.Errorf("routes admin listener must be added with AddListener")
+	}
+	table, err := svr.newRouteTable(s.services)
+	if err != nil {
+		return nil, err
+	}
+	svr.routes.Store(table)
+	*routesAdminServer = svr
+	return svr, nil
 }
 
 func (s *serverBuilder) MustBuild() Server {
@@ -222,20 +891,29 @@ func (s *serverBuilder) MustBuild() Server {
 	return svr
 }
 
+func (s *serverBuilder) BuildMutable() (MutableServer, error) {
+	svr, err := s.Build()
+	if err != nil {
+		return nil, err
+	}
+	return mutableServer{svr.(immutableServer)}, nil
+}
+
+func (s *serverBuilder) MustBuildMutable() MutableServer {
+	svr, err := s.BuildMutable()
+	if err != nil {
+		panic(err)
+	}
+	return svr
+}
+
 func (s *serverBuilder) addService(service Service) bool {
 	if _, exists := s.serviceIdSet[service.Id()]; exists {
 		s.err = fmt.Errorf("service %s already exists", service.Id())
 		return false
 	}
 	s.serviceIdSet[service.Id()] = true
-	for _, pattern := range service.UriPatterns() {
-		err := s.uriTrie.Add(pattern, service, true)
-		if err != nil {
-			s.logger.Errorf(context.Background(), "error while adding route %s from service %s: %s", pattern, service.Id(), err.Error())
-			s.err = err
-			return false
-		}
-	}
+	s.services = append(s.services, service)
 	return true
 }
 
@@ -244,7 +922,6 @@ func NewBuilder() Builder {
 		ctx:          context.Background(),
 		middlewares:  make([]Middleware, 0),
 		serviceIdSet: make(map[string]bool),
-		uriTrie:      uri_trie.NewTrieTree(),
 		logger:       logging.GlobalLogger.WithPrefix("[HTTPServer]"),
 		engine:       NetEngine,
 	}
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenTestServer makes builder serve on a random local port and returns the base url of the server
+func listenTestServer(t *testing.T, builder Builder) (Builder, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// in case the server is never started
+	t.Cleanup(func() {
+		listener.Close()
+	})
+	return builder.Listener(listener), "http://" + listener.Addr().String()
+}
+
+// runTestServer starts svr and waits until it answers on url, the server is stopped when the test ends
+func runTestServer(t *testing.T, svr Server, url string) {
+	runTestServerWithClient(t, svr, http.DefaultClient, url)
+}
+
+// runTestServerWithClient is runTestServer for servers that only client can talk to, e.g. TLS servers
+func runTestServerWithClient(t *testing.T, svr Server, client *http.Client, url string) {
+	started := make(chan error, 1)
+	go func() {
+		started <- svr.Start()
+	}()
+	t.Cleanup(func() {
+		svr.Stop()
+	})
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		select {
+		case err := <-started:
+			t.Fatalf("server stopped while starting: %v", err)
+		default:
+		}
+		if resp, err := client.Get(url); err == nil {
+			resp.Body.Close()
+			return
+		}
+	}
+	t.Fatal("server did not start")
+}
+
+// newSlowTestServer serves GET /slow on builder which blocks until release is closed, entered receives a value once per
+// request. It returns once the server answers client on url, an https url when builder serves TLS.
+func newSlowTestServer(t *testing.T, builder Builder, client *http.Client) (svr Server, url string, entered chan struct{}, release chan struct{}) {
+	entered, release = make(chan struct{}, 8), make(chan struct{})
+	t.Cleanup(func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	})
+	builder, url = listenTestServer(t, builder.WithService(NewServiceBuilder().Id("slow").
+		WithRouteHandlers(PathHandlerBuilder("/slow").Get(func(r Request) (Response, ServiceError) {
+			entered <- struct{}{}
+			<-release
+			return NewPlainTextResponse(http.StatusOK, "done"), nil
+		})).MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svr.(immutableServer).tlsConfig != nil {
+		url = strings.Replace(url, "http://", "https://", 1)
+	}
+	runTestServerWithClient(t, svr, client, url)
+	// the idle connection of the start-up check would hold the drain
+	client.CloseIdleConnections()
+	return svr, url, entered, release
+}
+
+// freshTestClient never reuses a connection, e.g. one closed by the server
+func freshTestClient() *http.Client {
+	return &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+}
+
+type slowResult struct {
+	body string
+	err  error
+}
+
+// getSlow sends GET /slow with client and waits until the request is handled
+func getSlow(client *http.Client, url string, entered chan struct{}) (<-chan slowResult, error) {
+	results := make(chan slowResult, 1)
+	go func() {
+		resp, err := client.Get(url + "/slow")
+		if err != nil {
+			results <- slowResult{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		results <- slowResult{string(body), err}
+	}()
+	select {
+	case <-entered:
+		return results, nil
+	case result := <-results:
+		return nil, result.err
+	case <-time.After(time.Second * 5):
+		return nil, errors.New("the request was not handled")
+	}
+}
+
+// newTestService serves GET path with the plain text body
+func newTestService(id, path, body string) ServiceBuilder {
+	return NewServiceBuilder().Id(id).WithRouteHandlers(PathHandlerBuilder(path).Get(func(r Request) (Response, ServiceError) {
+		return NewPlainTextResponse(http.StatusOK, body), nil
+	}))
+}
+
+// headerMiddleware sets the X-Listener header of the responses
+func headerMiddleware(value string) Middleware {
+	return func(ctx MiddlewareContext) {
+		ctx.Next()
+		if resp := ctx.Response(); resp != nil {
+			resp.SetHeader("X-Listener", value)
+		}
+	}
+}
+
+func TestAdditionalListeners(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	adminBuilder, adminURL := listenTestServer(t, NewBuilder().
+		Engine(FastHTTPEngine).
+		WithMiddleware(headerMiddleware("admin")).
+		WithService(recorder.dependentService("admin", "db")).
+		// the same service served on both listeners is started once
+		WithService(recorder.dependentService("db")))
+	builder, url := listenTestServer(t, NewBuilder().
+		WithMiddleware(headerMiddleware("public")).
+		WithService(recorder.dependentService("public", "db")).
+		WithService(recorder.dependentService("db")).
+		AddListener(adminBuilder))
+	svr := builder.MustBuild()
+	runTestServer(t, svr, url)
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"db init", "public init", "admin init", "db start", "public start", "admin start"}) {
+		t.Fatalf("unexpected start events %v", events)
+	}
+	cases := []struct {
+		url      string
+		status   int
+		listener string
+	}{
+		{url + "/public", http.StatusOK, "public"},
+		{url + "/db", http.StatusOK, "public"},
+		// the global middlewares of the listener run for unmatched routes too
+		{url + "/admin", http.StatusNotFound, "public"},
+		{adminURL + "/admin", http.StatusOK, "admin"},
+		{adminURL + "/db", http.StatusOK, "admin"},
+		{adminURL + "/public", http.StatusNotFound, "admin"},
+	}
+	for _, c := range cases {
+		resp, err := http.Get(c.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != c.status || resp.Header.Get("X-Listener") != c.listener {
+			t.Errorf("expected %d from the %s listener for %s, got %d from %s", c.status, c.listener, c.url, resp.StatusCode, resp.Header.Get("X-Listener"))
+		}
+	}
+	if err := svr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"admin stop", "public stop", "db stop"}) {
+		t.Fatalf("unexpected stop events %v", events)
+	}
+	// every listener shuts down with the server
+	for _, u := range []string{url, adminURL} {
+		if resp, err := http.Get(u + "/db"); err == nil {
+			resp.Body.Close()
+			t.Fatalf("expected %s to be closed", u)
+		}
+	}
+}
+
+func TestAdditionalListenerStartFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+	recorder := &lifecycleRecorder{}
+	builder, url := listenTestServer(t, NewBuilder().
+		WithService(recorder.dependentService("public")).
+		AddListener(NewBuilder().Address(taken.Addr().String()).WithService(recorder.dependentService("admin"))))
+	if err := builder.MustBuild().Start(); err == nil {
+		t.Fatal("expected the start to fail on the taken address")
+	}
+	// no service is started and the listeners bound meanwhile are closed
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("unexpected events %v", events)
+	}
+	if resp, err := http.Get(url); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected the listener of the failed start to be closed")
+	}
+}
@@ -0,0 +1,154 @@
+package servertest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/dlshle/aghs/server"
+)
+
+type RequestBuilder struct {
+	server     *Server
+	method     string
+	target     string
+	header     http.Header
+	query      url.Values
+	body       []byte
+	remoteAddr string
+	uriPattern string
+	pathParams map[string]string
+	service    server.Service
+	err        error
+}
+
+// NewRequest starts building a request for target(path with optional query), use Build for a fake server.Request.
+func NewRequest(method, target string) *RequestBuilder {
+	return &RequestBuilder{
+		method:     method,
+		target:     target,
+		header:     make(http.Header),
+		query:      make(url.Values),
+		pathParams: make(map[string]string),
+	}
+}
+
+func (b *RequestBuilder) Header(key, value string) *RequestBuilder {
+	b.header.Add(key, value)
+	return b
+}
+
+func (b *RequestBuilder) Query(key, value string) *RequestBuilder {
+	b.query.Add(key, value)
+	return b
+}
+
+func (b *RequestBuilder) Body(body []byte) *RequestBuilder {
+	b.body = body
+	return b
+}
+
+func (b *RequestBuilder) Text(body string) *RequestBuilder {
+	if b.header.Get("Content-Type") == "" {
+		b.header.Set("Content-Type", "text/plain")
+	}
+	return b.Body([]byte(body))
+}
+
+// JSON marshals v as the body and sets the JSON content type.
+func (b *RequestBuilder) JSON(v interface{}) *RequestBuilder {
+	body, err := json.Marshal(v)
+	if err != nil {
+		b.err = fmt.Errorf("failed to marshal JSON body: %v", err)
+		return b
+	}
+	b.header.Set("Content-Type", "application/json")
+	return b.Body(body)
+}
+
+func (b *RequestBuilder) RemoteAddr(addr string) *RequestBuilder {
+	b.remoteAddr = addr
+	return b
+}
+
+// PathParam sets a path param of the fake request, requests sent to a Server get their path params by routing.
+func (b *RequestBuilder) PathParam(key, value string) *RequestBuilder {
+	b.pathParams[key] = value
+	return b
+}
+
+// UriPattern sets the matched uri pattern of the fake request.
+func (b *RequestBuilder) UriPattern(pattern string) *RequestBuilder {
+	b.uriPattern = pattern
+	return b
+}
+
+// Service sets the matched service of the fake request.
+func (b *RequestBuilder) Service(service server.Service) *RequestBuilder {
+	b.service = service
+	return b
+}
+
+func (b *RequestBuilder) HTTPRequest() (*http.Request, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	target := b.target
+	if len(b.query) > 0 {
+		separator := "?"
+		if strings.Contains(target, "?") {
+			separator = "&"
+		}
+		target += separator + b.query.Encode()
+	}
+	request := httptest.NewRequest(b.method, target, bytes.NewReader(b.body))
+	for key, values := range b.header {
+		request.Header[key] = values
+	}
+	if b.remoteAddr != "" {
+		request.RemoteAddr = b.remoteAddr
+	}
+	return request, nil
+}
+
+// Build creates a fake server.Request as if it has been routed to the uri pattern, for testing handlers and middlewares directly.
+func (b *RequestBuilder) Build() (server.Request, error) {
+	request, err := b.HTTPRequest()
+	if err != nil {
+		return nil, err
+	}
+	queryParams := make(map[string]string)
+	for key, values := range request.URL.Query() {
+		queryParams[key] = values[0]
+	}
+	uriPattern := b.uriPattern
+	if uriPattern == "" {
+		uriPattern = request.URL.Path
+	}
+	return server.NewRequest(request, b.service, uriPattern, queryParams, b.pathParams), nil
+}
+
+func (b *RequestBuilder) MustBuild() server.Request {
+	request, err := b.Build()
+	if err != nil {
+		panic(err)
+	}
+	return request
+}
+
+// Do sends the request to the Server that created the builder.
+func (b *RequestBuilder) Do() *Response {
+	if b.server == nil {
+		panic("request builder is not created by a servertest.Server")
+	}
+	b.server.t.Helper()
+	request, err := b.HTTPRequest()
+	if err != nil {
+		b.server.t.Fatalf("failed to build request %s %s: %v", b.method, b.target, err)
+	}
+	return b.server.Do(request)
+}
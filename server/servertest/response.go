@@ -0,0 +1,156 @@
+package servertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Response wraps the recorded response, assertions report failures to the test and return the response for chaining.
+type Response struct {
+	t        testing.TB
+	Recorder *httptest.ResponseRecorder
+}
+
+func (r *Response) StatusCode() int {
+	return r.Recorder.Code
+}
+
+func (r *Response) Header() http.Header {
+	return r.Recorder.Header()
+}
+
+func (r *Response) Body() []byte {
+	return r.Recorder.Body.Bytes()
+}
+
+func (r *Response) DecodeJSON(holder interface{}) error {
+	return json.Unmarshal(r.Body(), holder)
+}
+
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.StatusCode() != code {
+		r.t.Errorf("expected status %d but got %d with body %s", code, r.StatusCode(), r.Recorder.Body.String())
+	}
+	return r
+}
+
+func (r *Response) AssertHeader(key, value string) *Response {
+	r.t.Helper()
+	if actual := r.Header().Get(key); actual != value {
+		r.t.Errorf("expected header %s to be %q but got %q", key, value, actual)
+	}
+	return r
+}
+
+func (r *Response) AssertNoHeader(key string) *Response {
+	r.t.Helper()
+	if values, exists := r.Header()[http.CanonicalHeaderKey(key)]; exists {
+		r.t.Errorf("expected no header %s but got %v", key, values)
+	}
+	return r
+}
+
+func (r *Response) AssertBody(body string) *Response {
+	r.t.Helper()
+	if actual := r.Recorder.Body.String(); actual != body {
+		r.t.Errorf("expected body %q but got %q", body, actual)
+	}
+	return r
+}
+
+func (r *Response) AssertBodyContains(substr string) *Response {
+	r.t.Helper()
+	if actual := r.Recorder.Body.String(); !strings.Contains(actual, substr) {
+		r.t.Errorf("expected body to contain %q but got %q", substr, actual)
+	}
+	return r
+}
+
+// AssertJSON compares the JSON body with expected marshalled as JSON, so that structs, maps and numbers compare by value.
+func (r *Response) AssertJSON(expected interface{}) *Response {
+	r.t.Helper()
+	var actual interface{}
+	if err := r.DecodeJSON(&actual); err != nil {
+		r.t.Errorf("failed to decode JSON body %q: %v", r.Recorder.Body.String(), err)
+		return r
+	}
+	r.assertJSONEqual("body", expected, actual)
+	return r
+}
+
+// AssertJSONPath compares the value at path of the JSON body with expected, path is dot separated keys and
+// array indexes, e.g. "data.students.0.name" or "data.students[0].name".
+func (r *Response) AssertJSONPath(path string, expected interface{}) *Response {
+	r.t.Helper()
+	var doc interface{}
+	if err := r.DecodeJSON(&doc); err != nil {
+		r.t.Errorf("failed to decode JSON body %q: %v", r.Recorder.Body.String(), err)
+		return r
+	}
+	actual, err := LookupJSONPath(doc, path)
+	if err != nil {
+		r.t.Errorf("%v in body %s", err, r.Recorder.Body.String())
+		return r
+	}
+	r.assertJSONEqual(path, expected, actual)
+	return r
+}
+
+func (r *Response) assertJSONEqual(name string, expected, actual interface{}) {
+	r.t.Helper()
+	normalized, err := normalizeJSON(expected)
+	if err != nil {
+		r.t.Errorf("failed to marshal expected value of %s: %v", name, err)
+		return
+	}
+	if !reflect.DeepEqual(normalized, actual) {
+		expectedJSON, _ := json.Marshal(normalized)
+		actualJSON, _ := json.Marshal(actual)
+		r.t.Errorf("expected %s to be %s but got %s", name, expectedJSON, actualJSON)
+	}
+}
+
+func normalizeJSON(v interface{}) (normalized interface{}, err error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &normalized)
+	return
+}
+
+// LookupJSONPath finds the value at path in a document decoded by encoding/json.
+func LookupJSONPath(doc interface{}, path string) (interface{}, error) {
+	path = strings.ReplaceAll(strings.ReplaceAll(strings.TrimPrefix(path, "$"), "[", "."), "]", "")
+	path = strings.TrimPrefix(path, ".")
+	if path == "" {
+		return doc, nil
+	}
+	current := doc
+	for _, segment := range strings.Split(path, ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, exists := node[segment]
+			if !exists {
+				return nil, fmt.Errorf("JSON path %s: key %s does not exist", path, segment)
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("JSON path %s: invalid index %s for array of length %d", path, segment, len(node))
+			}
+			current = node[index]
+		default:
+			return nil, fmt.Errorf("JSON path %s: can not find %s in %v", path, segment, node)
+		}
+	}
+	return current, nil
+}
@@ -0,0 +1,105 @@
+package servertest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+)
+
+// Server serves requests with a built server in memory, no port is bound and the server does not need to be started.
+type Server struct {
+	t       testing.TB
+	handler http.Handler
+}
+
+func New(t testing.TB, svr server.Server) *Server {
+	t.Helper()
+	handler, ok := svr.(http.Handler)
+	if !ok {
+		t.Fatalf("server %T can not be served in memory", svr)
+	}
+	return &Server{t, handler}
+}
+
+// NewForService builds a server serving only the service with the global middlewares.
+func NewForService(t testing.TB, service server.Service, middlewares ...server.Middleware) *Server {
+	t.Helper()
+	svr, err := server.NewBuilder().WithService(service).WithMiddlewares(middlewares).Build()
+	if err != nil {
+		t.Fatalf("failed to build server for service %s: %v", service.Id(), err)
+	}
+	return New(t, svr)
+}
+
+func (s *Server) Request(method, target string) *RequestBuilder {
+	builder := NewRequest(method, target)
+	builder.server = s
+	return builder
+}
+
+func (s *Server) Get(target string) *RequestBuilder {
+	return s.Request(http.MethodGet, target)
+}
+
+func (s *Server) Post(target string) *RequestBuilder {
+	return s.Request(http.MethodPost, target)
+}
+
+func (s *Server) Put(target string) *RequestBuilder {
+	return s.Request(http.MethodPut, target)
+}
+
+func (s *Server) Patch(target string) *RequestBuilder {
+	return s.Request(http.MethodPatch, target)
+}
+
+func (s *Server) Delete(target string) *RequestBuilder {
+	return s.Request(http.MethodDelete, target)
+}
+
+func (s *Server) Do(request *http.Request) *Response {
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+	return &Response{t: s.t, Recorder: recorder}
+}
+
+// RunMiddleware runs the middleware against request, next is called when the middleware calls ctx.Next() and may be nil.
+func RunMiddleware(middleware server.Middleware, request server.Request, next server.RequestHandler) (server.Response, server.ServiceError) {
+	return server.RunMiddlewares(request, next, middleware)
+}
+
+// RunMiddlewareWithResponse runs the middleware against request as if the handler behind it responded with resp and err,
+// e.g. to test a middleware rewriting responses.
+func RunMiddlewareWithResponse(middleware server.Middleware, request server.Request, resp server.Response, err server.ServiceError) (server.Response, server.ServiceError) {
+	return RunMiddleware(middleware, request, func(server.Request) (server.Response, server.ServiceError) {
+		return resp, err
+	})
+}
+
+// HandlerRecorder records the requests it handles, use Handle as the next handler of a middleware under test.
+type HandlerRecorder struct {
+	Calls    int
+	Request  server.Request
+	Response server.Response
+	Err      server.ServiceError
+}
+
+// NewHandlerRecorder responds with resp and err to every request, an empty 200 response is used when both are nil.
+func NewHandlerRecorder(resp server.Response, err server.ServiceError) *HandlerRecorder {
+	if resp == nil && err == nil {
+		resp = server.NewResponse(http.StatusOK, nil)
+	}
+	return &HandlerRecorder{Response: resp, Err: err}
+}
+
+func (h *HandlerRecorder) Handle(request server.Request) (server.Response, server.ServiceError) {
+	h.Calls++
+	h.Request = request
+	return h.Response, h.Err
+}
+
+func (h *HandlerRecorder) Called() bool {
+	return h.Calls > 0
+}
@@ -0,0 +1,172 @@
+package servertest
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/dlshle/aghs/server"
+)
+
+// recordingTB records the failures of the assertions under test instead of failing the test
+type recordingTB struct {
+	testing.TB
+	failures []string
+}
+
+func (t *recordingTB) Helper() {}
+
+func (t *recordingTB) Errorf(format string, args ...interface{}) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingTB) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+}
+
+type student struct {
+	Id    string `json:"id"`
+	Name  string `json:"name"`
+	Class string `json:"class"`
+}
+
+func newStudentService() server.Service {
+	return server.NewServiceBuilder().
+		Id("students").
+		WithRouteHandlers(server.PathHandlerBuilder("/students/:sid").
+			Get(func(r server.Request) (server.Response, server.ServiceError) {
+				resp := server.NewResponse(http.StatusOK, []student{{Id: r.PathParams()["sid"], Name: "ann", Class: r.QueryParams()["class"]}})
+				resp.SetHeader("X-Student", r.PathParams()["sid"])
+				return resp, nil
+			})).
+		WithRouteHandlers(server.PathHandlerBuilder("/students").
+			Post(func(r server.Request) (server.Response, server.ServiceError) {
+				var s student
+				if err := r.UnmarshalBody(&s); err != nil {
+					return nil, server.BadRequestError(err.Error())
+				}
+				return server.NewResponse(http.StatusCreated, s), nil
+			})).
+		MustBuild()
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	doc := map[string]interface{}{
+		"data": map[string]interface{}{
+			"students": []interface{}{
+				map[string]interface{}{"name": "ann"},
+				map[string]interface{}{"name": "bob"},
+			},
+		},
+	}
+	cases := []struct {
+		path    string
+		want    interface{}
+		wantErr bool
+	}{
+		{"data.students.1.name", "bob", false},
+		{"data.students[0].name", "ann", false},
+		{"$.data.students[1].name", "bob", false},
+		{"[0]", nil, true},
+		{"", doc, false},
+		{"data.teachers", nil, true},
+		{"data.students.2", nil, true},
+		{"data.students.x", nil, true},
+		{"data.students.0.name.first", nil, true},
+	}
+	for _, c := range cases {
+		t.Run(c.path, func(t *testing.T) {
+			got, err := LookupJSONPath(doc, c.path)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if !c.wantErr && !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expected %v but got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestServerForService(t *testing.T) {
+	svr := NewForService(t, newStudentService())
+	svr.Get("/students/42").Query("class", "a").Do().
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Student", "42").
+		AssertNoHeader("X-Missing").
+		AssertJSONPath("[0].id", "42").
+		AssertJSONPath("0.class", "a").
+		AssertJSON([]student{{Id: "42", Name: "ann", Class: "a"}})
+	svr.Post("/students").JSON(student{Id: "7", Name: "bob"}).Do().
+		AssertStatus(http.StatusCreated).
+		AssertJSONPath("name", "bob")
+	svr.Post("/students").Text("not json").Do().
+		AssertStatus(http.StatusBadRequest)
+	svr.Get("/teachers").Do().
+		AssertStatus(http.StatusNotFound)
+}
+
+func TestAssertionsReportFailures(t *testing.T) {
+	recorder := &recordingTB{TB: t}
+	svr := NewForService(t, newStudentService())
+	resp := svr.Get("/students/42").Do()
+	resp.t = recorder
+	resp.AssertStatus(http.StatusCreated).
+		AssertHeader("X-Student", "43").
+		AssertNoHeader("X-Student").
+		AssertBody("{}").
+		AssertBodyContains("bob").
+		AssertJSONPath("0.id", "43").
+		AssertJSONPath("0.missing", "x").
+		AssertJSON([]student{})
+	if len(recorder.failures) != 8 {
+		t.Fatalf("expected every assertion to fail, got %v", recorder.failures)
+	}
+	recorder.failures = nil
+	resp.AssertStatus(http.StatusOK).AssertHeader("X-Student", "42").AssertJSONPath("0.name", "ann")
+	if len(recorder.failures) != 0 {
+		t.Fatalf("unexpected failures %v", recorder.failures)
+	}
+}
+
+func TestRunMiddleware(t *testing.T) {
+	auth := func(ctx server.MiddlewareContext) {
+		if ctx.Request().Header().Get("Authorization") == "" {
+			ctx.Report(server.NewServiceErrorWithCode(http.StatusUnauthorized, "unauthorized"))
+			return
+		}
+		ctx.Request().RegisterContext("user", "ann")
+		ctx.Next()
+	}
+	next := NewHandlerRecorder(nil, nil)
+	_, err := RunMiddleware(auth, NewRequest(http.MethodGet, "/students").MustBuild(), next.Handle)
+	if err == nil || err.Code() != http.StatusUnauthorized || next.Called() {
+		t.Fatalf("expected the middleware to reject the request, got %v", err)
+	}
+	request := NewRequest(http.MethodGet, "/students").Header("Authorization", "token").MustBuild()
+	resp, err := RunMiddleware(auth, request, next.Handle)
+	if err != nil || resp.Code() != http.StatusOK || next.Calls != 1 || next.Request.GetContext("user") != "ann" {
+		t.Fatalf("expected the middleware to pass the request on, got %v %v", resp, err)
+	}
+}
+
+func TestRunMiddlewareWithResponse(t *testing.T) {
+	poweredBy := func(ctx server.MiddlewareContext) {
+		ctx.Next()
+		if ctx.Response().Code() < http.StatusBadRequest {
+			ctx.Response().SetHeader("X-Powered-By", "aghs")
+		}
+	}
+	request := NewRequest(http.MethodGet, "/students/42").PathParam("sid", "42").UriPattern("/students/:sid").MustBuild()
+	resp, err := RunMiddlewareWithResponse(poweredBy, request, server.NewResponse(http.StatusOK, "ok"), nil)
+	if exists, value := resp.GetHeader("X-Powered-By"); err != nil || !exists || value != "aghs" {
+		t.Fatalf("expected the header on the canned response, got %v %v", value, err)
+	}
+	resp, _ = RunMiddlewareWithResponse(poweredBy, request, server.NewResponse(http.StatusNotFound, nil), nil)
+	if exists, _ := resp.GetHeader("X-Powered-By"); exists || resp.Code() != http.StatusNotFound {
+		t.Fatal("expected the error response untouched")
+	}
+	if request.PathParams()["sid"] != "42" || request.UriPattern() != "/students/:sid" {
+		t.Fatal("unexpected fake request routing")
+	}
+}
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/dlshle/gommon/logging"
 )
@@ -24,10 +27,18 @@ type immutableService struct {
 	ctx                context.Context
 	id                 string
 	uriMap             map[string]map[string]RequestHandler
+	middlewareNames    map[string]map[string][]string
 	asyncHandlerUriMap map[string]map[string]Middleware
 	isAsync            bool
 	logger             logging.Logger
 	middlewares        []Middleware
+	initHook           LifecycleHook
+	startHook          LifecycleHook
+	stopHook           LifecycleHook
+	dependencies       []string
+	autoHead           bool
+	autoOptions        bool
+	allowHeader        bool
 }
 
 func (s immutableService) getRequestHandlingMiddlewares(routePattern, method string) RequestHandler {
@@ -39,14 +50,41 @@ func (s immutableService) getRequestHandlingMiddlewares(routePattern, method str
 }
 
 func (s immutableService) Handle(request Request) (resp Response, err ServiceError) {
-	handler := s.getRequestHandlingMiddlewares(request.UriPattern(), request.Method())
+	pattern, method := request.UriPattern(), request.Method()
+	handler := s.getRequestHandlingMiddlewares(pattern, method)
+	if handler == nil && method == http.MethodHead && s.autoHead {
+		// the server only sends the headers of the GET response
+		handler = s.getRequestHandlingMiddlewares(pattern, http.MethodGet)
+	}
+	if handler == nil && method == http.MethodOptions && s.autoOptions {
+		resp = NewResponse(http.StatusNoContent, nil)
+		resp.SetHeader("Allow", s.allowedMethods(pattern))
+		return
+	}
 	if handler == nil {
-		err = MethodNotAllowedError(fmt.Sprintf("method %s is not allowed for uri pattern %s", request.Method(), request.UriPattern()))
+		err = MethodNotAllowedError(fmt.Sprintf("method %s is not allowed for uri pattern %s", method, pattern))
+		if s.allowHeader {
+			resp = NewResponse(http.StatusMethodNotAllowed, nil)
+			resp.SetHeader("Allow", s.allowedMethods(pattern))
+		}
 		return
 	}
 	return handler(request)
 }
 
+// allowedMethods includes the automatically handled methods
+func (s immutableService) allowedMethods(pattern string) string {
+	methods := s.SupportedMethodsForPattern(pattern)
+	if s.autoHead && s.SupportsMethodForPattern(pattern, http.MethodGet) && !s.SupportsMethodForPattern(pattern, http.MethodHead) {
+		methods = append(methods, http.MethodHead)
+	}
+	if s.autoOptions && !s.SupportsMethodForPattern(pattern, http.MethodOptions) {
+		methods = append(methods, http.MethodOptions)
+	}
+	sort.Strings(methods)
+	return strings.Join(methods, ", ")
+}
+
 func (s immutableService) UriPatterns() []string {
 	var patterns []string
 	for pattern, _ := range s.uriMap {
@@ -75,19 +113,68 @@ func (s immutableService) SupportedMethodsForPattern(pattern string) []string {
 	for method := range s.uriMap[pattern] {
 		supportedMethods = append(supportedMethods, method)
 	}
+	// in a stable order for the headers listing them
+	sort.Strings(supportedMethods)
 	return supportedMethods
 }
 
+func (s immutableService) RouteMiddlewares(pattern, method string) []string {
+	return s.middlewareNames[pattern][method]
+}
+
 func (s immutableService) Logger() logging.Logger {
 	return s.logger
 }
 
+func (s immutableService) Init(ctx context.Context) error {
+	return runLifecycleHook(ctx, s.initHook)
+}
+
+func (s immutableService) Start(ctx context.Context) error {
+	return runLifecycleHook(ctx, s.startHook)
+}
+
+func (s immutableService) Stop(ctx context.Context) error {
+	return runLifecycleHook(ctx, s.stopHook)
+}
+
+func (s immutableService) Dependencies() []string {
+	return s.dependencies
+}
+
+func (s immutableService) hasLifecycleHook(phase string) bool {
+	switch phase {
+	case "init":
+		return s.initHook != nil
+	case "start":
+		return s.startHook != nil
+	case "stop":
+		return s.stopHook != nil
+	}
+	return true
+}
+
+func runLifecycleHook(ctx context.Context, hook LifecycleHook) error {
+	if hook == nil {
+		return nil
+	}
+	return hook(ctx)
+}
+
 type ServiceBuilder interface {
 	Id(string) ServiceBuilder
 	Context(context.Context) ServiceBuilder
 	Middlewares(...Middleware) ServiceBuilder
+	NamedMiddleware(name string, middleware Middleware) ServiceBuilder
 	WithRouteHandlers(path HandlersWithPath) ServiceBuilder
 	LogWriter(io.Writer) ServiceBuilder
+	OnInit(LifecycleHook) ServiceBuilder
+	OnStart(LifecycleHook) ServiceBuilder
+	OnStop(LifecycleHook) ServiceBuilder
+	DependsOn(serviceIds ...string) ServiceBuilder
+	AutoHead(bool) ServiceBuilder
+	AutoOptions(bool) ServiceBuilder
+	AllowHeader(bool) ServiceBuilder
 	Build() (Service, error)
 	MustBuild() Service
 }
@@ -97,8 +184,12 @@ type immutableServiceBuilder struct {
 	s           *immutableService
 	uriMap      map[string]map[string][]Middleware
 	middlewares []Middleware
-	err         error
-	writer      io.Writer
+	// middlewareNames are the names of middlewares
+	middlewareNames []string
+	// routeMiddlewareNames are the names given to the route middlewares by pattern and method
+	routeMiddlewareNames map[string]map[string][]string
+	err                  error
+	writer               io.Writer
 }
 
 func NewServiceBuilder() ServiceBuilder {
@@ -106,14 +197,19 @@ func NewServiceBuilder() ServiceBuilder {
 		s: &immutableService{
 			ctx:                context.Background(),
 			uriMap:             make(map[string]map[string]RequestHandler),
+			middlewareNames:    make(map[string]map[string][]string),
 			middlewares:        make([]Middleware, 0),
 			asyncHandlerUriMap: make(map[string]map[string]Middleware),
 			isAsync:            false,
+			autoHead:           true,
+			autoOptions:        true,
+			allowHeader:        true,
 		},
-		uriMap:      make(map[string]map[string][]Middleware),
-		middlewares: make([]Middleware, 0),
-		err:         nil,
-		writer:      nil,
+		uriMap:               make(map[string]map[string][]Middleware),
+		middlewares:          make([]Middleware, 0),
+		routeMiddlewareNames: make(map[string]map[string][]string),
+		err:                  nil,
+		writer:               nil,
 	}
 }
 
@@ -127,6 +223,18 @@ func (b *immutableServiceBuilder) Middlewares(middlewares ...Middleware) Service
 		return b
 	}
 	b.middlewares = middlewares
+	b.middlewareNames = middlewareNames(middlewares, nil)
+	return b
+}
+
+// NamedMiddleware adds a service middleware named name in spans, Server-Timing and route introspection instead of its
+// function name.
+func (b *immutableServiceBuilder) NamedMiddleware(name string, middleware Middleware) ServiceBuilder {
+	if b.err != nil {
+		return b
+	}
+	b.middlewares = append(b.middlewares[:len(b.middlewares):len(b.middlewares)], middleware)
+	b.middlewareNames = append(b.middlewareNames, name)
 	return b
 }
 
@@ -141,6 +249,9 @@ func (b *immutableServiceBuilder) WithRouteHandlers(handlersWithPath HandlersWit
 		return b
 	}
 	b.uriMap[path] = handlers
+	if namer, ok := handlersWithPath.(middlewareNamer); ok {
+		b.routeMiddlewareNames[path] = namer.middlewareNames()
+	}
 	return b
 }
 
@@ -173,7 +284,7 @@ func (b *immutableServiceBuilder) tryConcatServiceMiddlewaresToRequestHandlerMid
 	}
 	for u, v := range b.uriMap {
 		for k, m := range v {
-			b.uriMap[u][k] = append(b.middlewares, m...)
+			b.uriMap[u][k] = append(b.middlewares[:len(b.middlewares):len(b.middlewares)], m...)
 		}
 	}
 }
@@ -181,10 +292,19 @@ func (b *immutableServiceBuilder) tryConcatServiceMiddlewaresToRequestHandlerMid
 func (b *immutableServiceBuilder) compressRequestHandlerMiddlewares() {
 	for u, v := range b.uriMap {
 		requestHandlerMap := make(map[string]RequestHandler)
+		namesMap := make(map[string][]string)
 		for k, m := range v {
-			requestHandlerMap[k] = middlewaresToRequestHandler(m)
+			var spanNames []string
+			if len(m) > 0 {
+				// the last one is the wrapped request handler
+				names := append(b.middlewareNames[:len(b.middlewareNames):len(b.middlewareNames)], b.routeMiddlewareNames[u][k]...)
+				namesMap[k] = middlewareNames(m[:len(m)-1], names)
+				spanNames = append(namesMap[k][:len(m)-1:len(m)-1], "handler")
+			}
+			requestHandlerMap[k] = middlewaresToRequestHandler(m, spanNames)
 		}
 		b.s.uriMap[u] = requestHandlerMap
+		b.s.middlewareNames[u] = namesMap
 	}
 }
 
@@ -207,3 +327,42 @@ func (b *immutableServiceBuilder) LogWriter(writer io.Writer) ServiceBuilder {
 	b.writer = writer
 	return b
 }
+
+func (b *immutableServiceBuilder) OnInit(hook LifecycleHook) ServiceBuilder {
+	b.s.initHook = hook
+	return b
+}
+
+func (b *immutableServiceBuilder) OnStart(hook LifecycleHook) ServiceBuilder {
+	b.s.startHook = hook
+	return b
+}
+
+func (b *immutableServiceBuilder) OnStop(hook LifecycleHook) ServiceBuilder {
+	b.s.stopHook = hook
+	return b
+}
+
+// DependsOn makes the server start the service after and stop it before the services of serviceIds.
+func (b *immutableServiceBuilder) DependsOn(serviceIds ...string) ServiceBuilder {
+	b.s.dependencies = append(b.s.dependencies, serviceIds...)
+	return b
+}
+
+// AutoHead makes HEAD requests to routes without a HEAD handler served by the GET handler without the body, enabled by default.
+func (b *immutableServiceBuilder) AutoHead(enabled bool) ServiceBuilder {
+	b.s.autoHead = enabled
+	return b
+}
+
+// AutoOptions answers OPTIONS requests to routes without an OPTIONS handler with the allowed methods, enabled by default.
+func (b *immutableServiceBuilder) AutoOptions(enabled bool) ServiceBuilder {
+	b.s.autoOptions = enabled
+	return b
+}
+
+// AllowHeader sends the allowed methods in the Allow header of 405 responses, enabled by default.
+func (b *immutableServiceBuilder) AllowHeader(enabled bool) ServiceBuilder {
+	b.s.allowHeader = enabled
+	return b
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"runtime"
 	"strings"
 
 	"github.com/dlshle/aghs/utils"
@@ -20,14 +21,25 @@ type serviceError struct {
 	code int    // code should correspond to an HTTP error code
 	msg  string // this will be the payload for response
 	ctx  context.Context
+	// callers is where a 5xx error was created, for the ErrorReporter
+	callers []uintptr
 }
 
+// errorStackDepth bounds the frames recorded for 5xx errors
+const errorStackDepth = 32
+
 func NewServiceErrorWithCode(code int, msg string) *serviceError {
 	if code < 400 || code > 600 {
 		code = 500
 	}
 	msg = strings.TrimSpace(msg)
-	return &serviceError{code, msg, nil}
+	err := &serviceError{code: code, msg: msg}
+	if code >= http.StatusInternalServerError {
+		err.callers = make([]uintptr, errorStackDepth)
+		// skip runtime.Callers and NewServiceErrorWithCode
+		err.callers = err.callers[:runtime.Callers(2, err.callers)]
+	}
+	return err
 }
 
 func NewServiceError(msg string) *serviceError {
@@ -64,6 +76,22 @@ func (e *serviceError) AttachContext(ctx context.Context) {
 	e.ctx = ctx
 }
 
+// stack formats the frames where the error was created, it is empty for errors below 500
+func (e *serviceError) stack() string {
+	if len(e.callers) == 0 {
+		return ""
+	}
+	var builder strings.Builder
+	frames := runtime.CallersFrames(e.callers)
+	for {
+		frame, more := frames.Next()
+		fmt.Fprintf(&builder, "%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		if !more {
+			return builder.String()
+		}
+	}
+}
+
 func MethodNotAllowedError(msg string) *serviceError {
 	return NewServiceErrorWithCode(http.StatusMethodNotAllowed, msg)
 }
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// LifecycleService is implemented by services that set up and tear down resources(e.g. DB connections, caches) with the server.
+// Init is called on every service before Start is called on any of them, both before the server accepts requests.
+// Stop is called after the server has drained, on every service whose Init succeeded.
+type LifecycleService interface {
+	Service
+	Init(ctx context.Context) error
+	Start(ctx context.Context) error
+	Stop(ctx context.Context) error
+}
+
+// DependentService declares the ids of the services it depends on, they are started before and stopped after it.
+type DependentService interface {
+	Service
+	Dependencies() []string
+}
+
+type LifecycleHook func(ctx context.Context) error
+
+// ServiceTimeouts bounds each Init/Start and each Stop call of the lifecycle services, 0 means no timeout.
+func (s *serverBuilder) ServiceTimeouts(startTimeout, stopTimeout time.Duration) Builder {
+	s.serviceStartTimeout = startTimeout
+	s.serviceStopTimeout = stopTimeout
+	return s
+}
+
+// lifecycleServices returns the lifecycle services of all the listeners in dependency order.
+func (s immutableServer) lifecycleServices() ([]LifecycleService, error) {
+	return s.orderedLifecycleServices(s.routes.Load().services)
+}
+
+// orderedLifecycleServices orders the lifecycle services among services and the services of the additional listeners.
+func (s immutableServer) orderedLifecycleServices(services []Service) ([]LifecycleService, error) {
+	var all []Service
+	serviceIdSet := make(map[string]bool)
+	tables := [][]Service{services}
+	for _, additional := range s.additionalServers {
+		tables = append(tables, additional.routes.Load().services)
+	}
+	for _, table := range tables {
+		for _, service := range table {
+			// the same service may be served on several listeners
+			if !serviceIdSet[service.Id()] {
+				serviceIdSet[service.Id()] = true
+				all = append(all, service)
+			}
+		}
+	}
+	ordered, err := orderServices(all)
+	if err != nil {
+		return nil, err
+	}
+	var lifecycleServices []LifecycleService
+	for _, service := range ordered {
+		if lifecycleService, ok := service.(LifecycleService); ok {
+			lifecycleServices = append(lifecycleServices, lifecycleService)
+		}
+	}
+	return lifecycleServices, nil
+}
+
+// startServices initializes and then starts the services in order, the initialized services are stopped if any of them fails.
+func (s immutableServer) startServices(services []LifecycleService) error {
+	var initialized []LifecycleService
+	for _, service := range services {
+		if err := s.runServiceHook(service, "init", service.Init, s.serviceStartTimeout); err != nil {
+			s.stopServices(initialized)
+			return err
+		}
+		initialized = append(initialized, service)
+	}
+	for _, service := range services {
+		if err := s.runServiceHook(service, "start", service.Start, s.serviceStartTimeout); err != nil {
+			s.stopServices(initialized)
+			return err
+		}
+	}
+	return nil
+}
+
+// stopServices stops the services in reverse order, errors are logged so that every service gets stopped.
+func (s immutableServer) stopServices(services []LifecycleService) {
+	for i := len(services) - 1; i >= 0; i-- {
+		if err := s.runServiceHook(services[i], "stop", services[i].Stop, s.serviceStopTimeout); err != nil {
+			s.logger.Errorf(s.ctx, "%s", err.Error())
+		}
+	}
+}
+
+// hookedService tells the phases a service has a hook for, the services built by ServiceBuilder have none unless set
+type hookedService interface {
+	hasLifecycleHook(phase string) bool
+}
+
+func (s immutableServer) runServiceHook(service Service, phase string, hook LifecycleHook, timeout time.Duration) error {
+	if hooked, ok := service.(hookedService); ok && !hooked.hasLifecycleHook(phase) {
+		return nil
+	}
+	ctx := s.ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				done <- fmt.Errorf("panic: %v", recovered)
+			}
+		}()
+		done <- hook(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		// the hook keeps running in the background if it ignores ctx
+		err = ctx.Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to %s service %s: %v", phase, service.Id(), err)
+	}
+	s.logger.Infof(s.ctx, "service %s %s completed", service.Id(), phase)
+	return nil
+}
+
+// orderServices sorts the services so that every service comes after its dependencies, the registration order is kept otherwise.
+func orderServices(services []Service) ([]Service, error) {
+	index := make(map[string]int, len(services))
+	for i, service := range services {
+		index[service.Id()] = i
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	states := make([]int, len(services))
+	ordered := make([]Service, 0, len(services))
+	var visit func(i int, path []string) error
+	visit = func(i int, path []string) error {
+		service := services[i]
+		switch states[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("circular service dependency %v", append(path, service.Id()))
+		}
+		states[i] = visiting
+		if dependent, ok := service.(DependentService); ok {
+			for _, dependency := range dependent.Dependencies() {
+				j, exists := index[dependency]
+				if !exists {
+					return fmt.Errorf("service %s depends on unknown service %s", service.Id(), dependency)
+				}
+				if err := visit(j, append(path, service.Id())); err != nil {
+					return err
+				}
+			}
+		}
+		states[i] = visited
+		ordered = append(ordered, service)
+		return nil
+	}
+	for i := range services {
+		if err := visit(i, nil); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
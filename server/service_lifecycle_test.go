@@ -0,0 +1,120 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dlshle/gommon/logging"
+)
+
+func (r *lifecycleRecorder) dependentService(id string, dependencies ...string) Service {
+	return newTestService(id, "/"+id, id).
+		DependsOn(dependencies...).
+		OnInit(r.hook(id + " init")).
+		OnStart(r.hook(id + " start")).
+		OnStop(r.hook(id + " stop")).
+		MustBuild()
+}
+
+func TestServiceLifecycleOrder(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	builder, url := listenTestServer(t, NewBuilder().
+		WithService(recorder.dependentService("api", "cache", "db")).
+		WithService(recorder.dependentService("cache", "db")).
+		WithService(recorder.dependentService("db")))
+	svr := builder.MustBuild()
+	runTestServer(t, svr, url)
+	expected := []string{"db init", "cache init", "api init", "db start", "cache start", "api start"}
+	if events := recorder.take(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected start events %v", events)
+	}
+	if err := svr.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if events := recorder.take(); !reflect.DeepEqual(events, []string{"api stop", "cache stop", "db stop"}) {
+		t.Fatalf("unexpected stop events %v", events)
+	}
+}
+
+func TestServiceLifecycleStartFailure(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	failing := newTestService("api", "/api", "api").
+		DependsOn("db").
+		OnInit(recorder.hook("api init")).
+		OnStart(func(ctx context.Context) error {
+			return errors.New("no connection")
+		}).
+		OnStop(recorder.hook("api stop")).
+		MustBuild()
+	builder, _ := listenTestServer(t, NewBuilder().WithService(failing).WithService(recorder.dependentService("db")))
+	err := builder.MustBuild().Start()
+	if err == nil || !strings.Contains(err.Error(), "no connection") {
+		t.Fatalf("expected the start failure, got %v", err)
+	}
+	// the initialized services are stopped in reverse order
+	expected := []string{"db init", "api init", "db start", "api stop", "db stop"}
+	if events := recorder.take(); !reflect.DeepEqual(events, expected) {
+		t.Fatalf("unexpected events %v", events)
+	}
+}
+
+func TestServiceDependencyErrors(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	cases := map[string][]Service{
+		"circular service dependency": {recorder.dependentService("a", "b"), recorder.dependentService("b", "a")},
+		"unknown service":             {recorder.dependentService("a", "missing")},
+	}
+	for message, services := range cases {
+		builder, _ := listenTestServer(t, NewBuilder().WithServices(services))
+		if err := builder.MustBuild().Start(); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("expected an error about %s, got %v", message, err)
+		}
+	}
+	if events := recorder.take(); len(events) != 0 {
+		t.Fatalf("expected no service to be started, got %v", events)
+	}
+}
+
+func TestServiceLifecycleLogsOnlyHooks(t *testing.T) {
+	recorder := &lifecycleRecorder{}
+	logs := new(syncBuffer)
+	builder, url := listenTestServer(t, NewBuilder().
+		Logger(logging.NewLevelLogger(logs, "", 0, logging.TRACE)).
+		WithService(newTestService("plain", "/plain", "plain").MustBuild()).
+		WithService(newTestService("hooked", "/hooked", "hooked").OnStart(recorder.hook("hooked start")).MustBuild()))
+	svr := builder.MustBuild()
+	runTestServer(t, svr, url)
+	if err := svr.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	output := logs.String()
+	if strings.Contains(output, "service plain") || strings.Contains(output, "service hooked init") || strings.Contains(output, "service hooked stop") {
+		t.Fatalf("expected the phases without hooks not to be logged, got %q", output)
+	}
+	if !strings.Contains(output, "service hooked start completed") {
+		t.Fatalf("expected the start hook to be logged, got %q", output)
+	}
+}
+
+// syncBuffer collects the log lines written from the server goroutines
+type syncBuffer struct {
+	lock   sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buffer.String()
+}
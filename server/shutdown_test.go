@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitRefused waits until no connection can be made to url
+func waitRefused(t *testing.T, url string) {
+	addr := strings.TrimPrefix(url, "http://")
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		conn, err := net.DialTimeout("tcp", addr, time.Millisecond*100)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}
+	t.Fatal("new connections are still accepted")
+}
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			svr, url, entered, release := newSlowTestServer(t, NewBuilder().Engine(e.engine), http.DefaultClient)
+			results, err := getSlow(freshTestClient(), url, entered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shutdown := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+				defer cancel()
+				shutdown <- svr.Shutdown(ctx)
+			}()
+			waitRefused(t, url)
+			select {
+			case err := <-shutdown:
+				t.Fatalf("shutdown returned before the in-flight request completed: %v", err)
+			case <-time.After(time.Millisecond * 100):
+			}
+			close(release)
+			if result := <-results; result.err != nil || result.body != "done" {
+				t.Fatalf("unexpected in-flight response %q %v", result.body, result.err)
+			}
+			select {
+			case err := <-shutdown:
+				if err != nil {
+					t.Fatalf("unexpected shutdown error %v", err)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("shutdown did not return once drained")
+			}
+		})
+	}
+}
+
+func TestShutdownDeadlineClosesConnections(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			svr, url, entered, _ := newSlowTestServer(t, NewBuilder().Engine(e.engine), http.DefaultClient)
+			results, err := getSlow(freshTestClient(), url, entered)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+			defer cancel()
+			started := time.Now()
+			if err := svr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("expected the deadline to be exceeded, got %v", err)
+			}
+			if elapsed := time.Since(started); elapsed > time.Second {
+				t.Fatalf("shutdown took %s past its deadline", elapsed)
+			}
+			select {
+			case result := <-results:
+				if result.err == nil {
+					t.Fatalf("expected the stuck request to fail, got %q", result.body)
+				}
+			case <-time.After(time.Second * 5):
+				t.Fatal("the connection of the stuck request was not closed")
+			}
+			if err := svr.Stop(); err != ErrServerClosed {
+				t.Fatalf("expected the server to be closed, got %v", err)
+			}
+		})
+	}
+}
+
+func TestEngineFromServeFunc(t *testing.T) {
+	engine := EngineFromServeFunc(func(listener net.Listener, handler http.Handler) error {
+		return http.Serve(listener, handler)
+	})
+	builder, url := listenTestServer(t, NewBuilder().Engine(engine).WithService(NewServiceBuilder().Id("ping").
+		WithRouteHandlers(PathHandlerBuilder("/ping").Get(func(r Request) (Response, ServiceError) {
+			return NewPlainTextResponse(http.StatusOK, "pong"), nil
+		})).MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- svr.Start()
+	}()
+	var resp *http.Response
+	for deadline := time.Now().Add(time.Second * 5); time.Now().Before(deadline); time.Sleep(time.Millisecond * 10) {
+		if resp, err = http.Get(url + "/ping"); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "pong" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if err := svr.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Fatalf("expected Start to return nil once shut down, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Start did not return")
+	}
+	waitRefused(t, url)
+}
+
+func TestEngineFromServeFuncTLS(t *testing.T) {
+	engine := EngineFromServeFunc(func(listener net.Listener, handler http.Handler) error {
+		return http.Serve(listener, handler)
+	})
+	builder, url := listenTestServer(t, NewBuilder().Engine(engine).SelfSignedTLS().
+		WithService(newTestService("ping", "/ping", "pong").MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := tlsTestClient()
+	url = strings.Replace(url, "http://", "https://", 1)
+	runTestServerWithClient(t, svr, client, url)
+	resp, err := client.Get(url + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "pong" || resp.TLS == nil {
+		t.Fatalf("unexpected response %q over TLS %v", body, resp.TLS != nil)
+	}
+}
+
+func TestEngineFromServeFuncRejectsTimeouts(t *testing.T) {
+	engine := EngineFromServeFunc(func(listener net.Listener, handler http.Handler) error {
+		return http.Serve(listener, handler)
+	})
+	builder, _ := listenTestServer(t, NewBuilder().Engine(engine).ReadTimeout(time.Second).MaxHeaderBytes(4096))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan error, 1)
+	go func() {
+		started <- svr.Start()
+	}()
+	select {
+	case err := <-started:
+		if err == nil || !strings.Contains(err.Error(), "ReadTimeout, MaxHeaderBytes") {
+			t.Fatalf("expected the ignored settings to be reported, got %v", err)
+		}
+	case <-time.After(time.Second * 5):
+		svr.Stop()
+		t.Fatal("expected Start to fail")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const ServerTimingHeader = "Server-Timing"
+
+// Span is the timing of the request, a middleware, a handler or the response write.
+type Span struct {
+	Name     string
+	Start    time.Time
+	Duration time.Duration
+	// Parent is the index of the enclosing span, -1 for the request span
+	Parent int
+}
+
+// RequestSpans are the spans recorded for a request, Spans[0] is the request span enclosing all the others.
+type RequestSpans struct {
+	Method     string
+	URI        string
+	UriPattern string
+	// ServiceId is empty when the route is not matched
+	ServiceId string
+	Status    int
+	// Context holds the values registered on the request, e.g. the trace and span ids of the tracing middleware
+	Context map[string]string
+	Spans   []Span
+}
+
+// SpanExporter receives the spans of every request synchronously after the response is written, so it should not block.
+// Exporters implementing io.Closer are closed after the server has drained.
+type SpanExporter interface {
+	Export(spans RequestSpans)
+}
+
+type SpanExporterFunc func(spans RequestSpans)
+
+func (f SpanExporterFunc) Export(spans RequestSpans) {
+	f(spans)
+}
+
+// SpanExporter records a span for every middleware, handler and response write and exports them per request.
+func (s *serverBuilder) SpanExporter(exporter SpanExporter) Builder {
+	s.spanExporter = exporter
+	return s
+}
+
+// ServerTiming summarizes the middleware and handler spans in the Server-Timing response header.
+func (s *serverBuilder) ServerTiming(enabled bool) Builder {
+	s.serverTiming = enabled
+	return s
+}
+
+// WithNamedMiddleware adds a global middleware named name in spans, Server-Timing and route introspection instead of
+// its function name, e.g. for middlewares built by closures.
+func (s *serverBuilder) WithNamedMiddleware(name string, middleware Middleware) Builder {
+	s.middlewares = append(s.middlewares, middleware)
+	s.middlewareNames = append(s.middlewareNames, name)
+	return s
+}
+
+type spanRecorder struct {
+	spans   []Span
+	current int
+}
+
+type spanRecording interface {
+	setSpanRecorder(recorder *spanRecorder)
+	spanRecorder() *spanRecorder
+}
+
+func newSpanRecorder(name string) *spanRecorder {
+	recorder := &spanRecorder{
+		spans:   make([]Span, 0, 8),
+		current: -1,
+	}
+	recorder.start(name)
+	return recorder
+}
+
+// start opens a span inside the current one and returns its index
+func (r *spanRecorder) start(name string) int {
+	r.spans = append(r.spans, Span{
+		Name:   name,
+		Start:  time.Now(),
+		Parent: r.current,
+	})
+	r.current = len(r.spans) - 1
+	return r.current
+}
+
+func (r *spanRecorder) end(index int) {
+	r.spans[index].Duration = time.Since(r.spans[index].Start)
+	r.current = r.spans[index].Parent
+}
+
+// serverTiming lists the finished spans with the time spent in themselves rather than in their children
+func (r *spanRecorder) serverTiming() string {
+	selfDurations := make([]time.Duration, len(r.spans))
+	for i, span := range r.spans {
+		selfDurations[i] += span.Duration
+		if span.Parent >= 0 {
+			selfDurations[span.Parent] -= span.Duration
+		}
+	}
+	metrics := make([]string, 0, len(r.spans))
+	for i, span := range r.spans {
+		if i == 0 || span.Duration == 0 {
+			continue
+		}
+		metrics = append(metrics, fmt.Sprintf("%s;dur=%.3f", serverTimingName(span.Name), durationMillis(selfDurations[i])))
+	}
+	metrics = append(metrics, fmt.Sprintf("total;dur=%.3f", durationMillis(time.Since(r.spans[0].Start))))
+	return strings.Join(metrics, ", ")
+}
+
+func durationMillis(duration time.Duration) float64 {
+	return float64(duration.Microseconds()) / 1000
+}
+
+// serverTimingName replaces the characters not allowed in a header token
+func serverTimingName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("!#$%&'*+-.^_`|~", r) {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func requestSpanRecorder(request Request) *spanRecorder {
+	if recording, ok := request.(spanRecording); ok {
+		return recording.spanRecorder()
+	}
+	return nil
+}
+
+func (s immutableServer) recordsSpans() bool {
+	return s.spanExporter != nil || s.serverTiming
+}
+
+// startSpans starts the request span if span recording is enabled, it returns nil otherwise
+func (s immutableServer) startSpans(request Request) *spanRecorder {
+	recording, ok := request.(spanRecording)
+	if !s.recordsSpans() || !ok {
+		return nil
+	}
+	name := request.Method()
+	if request.UriPattern() != "" {
+		name += " " + request.UriPattern()
+	}
+	recorder := newSpanRecorder(name)
+	recording.setSpanRecorder(recorder)
+	return recorder
+}
+
+func (s immutableServer) exportSpans(recorder *spanRecorder, request Request, resp Response, serviceErr ServiceError) {
+	recorder.end(0)
+	if s.spanExporter == nil {
+		return
+	}
+	spans := RequestSpans{
+		Method:     request.Method(),
+		URI:        request.URI(),
+		UriPattern: request.UriPattern(),
+		Context:    request.ContextValues(),
+		Spans:      recorder.spans,
+	}
+	if service := request.MatchedService(); service != nil {
+		spans.ServiceId = service.Id()
+	}
+	if serviceErr != nil {
+		spans.Status = serviceErr.Code()
+	} else if resp != nil {
+		spans.Status = resp.Code()
+	} else {
+		spans.Status = http.StatusInternalServerError
+	}
+	s.spanExporter.Export(spans)
+}
+
+func (s immutableServer) closeSpanExporter(closed closerSet) {
+	if closer, ok := s.spanExporter.(io.Closer); ok && closed.add(closer) {
+		if err := closer.Close(); err != nil {
+			s.logger.Errorf(s.ctx, "failed to close span exporter: %s", err.Error())
+		}
+	}
+}
+
+// handlerSpanName names the span of the handler ending the global middleware chain
+func handlerSpanName(service Service) string {
+	if service == nil {
+		return "notFound"
+	}
+	return service.Id() + ".Handle"
+}
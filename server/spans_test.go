@@ -0,0 +1,145 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func passMiddleware(ctx MiddlewareContext) {
+	ctx.Next()
+}
+
+// closureMiddleware builds a middleware sharing its function name with every other closure it builds
+func closureMiddleware() Middleware {
+	return func(ctx MiddlewareContext) {
+		ctx.Next()
+	}
+}
+
+func newSpanTestServer(t *testing.T, exporter SpanExporter) string {
+	service := NewServiceBuilder().Id("students").
+		NamedMiddleware("auth", closureMiddleware()).
+		WithRouteHandlers(PathHandlerBuilder("/students/:id").
+			GetWithMiddlewares(okHandler, closureMiddleware(), passMiddleware).
+			NameMiddlewares(http.MethodGet, "cache")).
+		MustBuild()
+	builder, url := listenTestServer(t, NewBuilder().
+		WithMiddleware(passMiddleware).
+		WithNamedMiddleware("tracing", closureMiddleware()).
+		WithService(service).
+		SpanExporter(exporter).
+		ServerTiming(true))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestServer(t, svr, url)
+	return url
+}
+
+func TestSpansAndServerTiming(t *testing.T) {
+	exported := make(chan RequestSpans, 8)
+	url := newSpanTestServer(t, SpanExporterFunc(func(spans RequestSpans) {
+		// the requests checking that the server is up are unmatched
+		if spans.ServiceId != "" {
+			exported <- spans
+		}
+	}))
+	resp, err := http.Get(url + "/students/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	timing := resp.Header.Get(ServerTimingHeader)
+	for _, metric := range []string{"server.passMiddleware;dur=", "tracing;dur=", "auth;dur=", "cache;dur=", "students.Handle;dur=", "handler;dur=", "total;dur="} {
+		if !strings.Contains(timing, metric) {
+			t.Errorf("expected %s in %s", metric, timing)
+		}
+	}
+	var spans RequestSpans
+	select {
+	case spans = <-exported:
+	case <-time.After(time.Second):
+		t.Fatal("the spans were not exported")
+	}
+	if spans.Method != http.MethodGet || spans.UriPattern != "/students/:id" || spans.ServiceId != "students" || spans.Status != http.StatusOK {
+		t.Fatalf("unexpected request spans %+v", spans)
+	}
+	// each span is enclosed by the previous one but for the write span enclosed by the request span
+	expected := []struct {
+		name   string
+		parent int
+	}{
+		{"GET /students/:id", -1},
+		{"server.passMiddleware", 0},
+		{"tracing", 1},
+		{"students.Handle", 2},
+		{"auth", 3},
+		{"cache", 4},
+		{"server.passMiddleware", 5},
+		{"handler", 6},
+		{"write", 0},
+	}
+	if len(spans.Spans) != len(expected) {
+		t.Fatalf("unexpected spans %+v", spans.Spans)
+	}
+	for i, span := range spans.Spans {
+		if span.Name != expected[i].name || span.Parent != expected[i].parent {
+			t.Errorf("expected span %d to be %s in %d, got %s in %d", i, expected[i].name, expected[i].parent, span.Name, span.Parent)
+		}
+		if span.Start.Before(spans.Spans[0].Start) || span.Duration > spans.Spans[0].Duration {
+			t.Errorf("unexpected timing of span %+v", span)
+		}
+	}
+}
+
+func TestNamedMiddlewaresInRoutes(t *testing.T) {
+	svr, err := NewBuilder().
+		WithNamedMiddleware("tracing", closureMiddleware()).
+		WithMiddleware(closureMiddleware()).
+		WithService(NewServiceBuilder().Id("students").
+			NamedMiddleware("auth", closureMiddleware()).
+			WithRouteHandlers(PathHandlerBuilder("/students/:id").
+				GetWithMiddlewares(okHandler, closureMiddleware(), passMiddleware).
+				NameMiddlewares(http.MethodGet, "cache")).
+			MustBuild()).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routes := svr.Routes()
+	if len(routes) != 1 {
+		t.Fatalf("unexpected routes %+v", routes)
+	}
+	closureName := MiddlewareName(closureMiddleware())
+	expected := strings.Join([]string{"tracing", closureName, "auth", "cache", "server.passMiddleware"}, ",")
+	if names := strings.Join(routes[0].Middlewares[http.MethodGet], ","); names != expected {
+		t.Fatalf("expected middlewares %s, got %s", expected, names)
+	}
+}
+
+// closingExporter counts how many times it is closed
+type closingExporter struct {
+	closingReporter
+}
+
+func (e *closingExporter) Export(spans RequestSpans) {}
+
+func TestSharedSpanExporterIsClosedOnce(t *testing.T) {
+	exporter := new(closingExporter)
+	adminBuilder, _ := listenTestServer(t, NewBuilder().SpanExporter(exporter))
+	builder, url := listenTestServer(t, NewBuilder().SpanExporter(exporter).AddListener(adminBuilder))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestServer(t, svr, url)
+	if err := svr.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if exporter.closed != 1 {
+		t.Fatalf("expected the shared exporter to be closed once, got %d", exporter.closed)
+	}
+}
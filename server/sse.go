@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SSEContentType      = "text/event-stream"
+	LastEventIDHeader   = "Last-Event-ID"
+	defaultSSEHeartbeat = time.Second * 15
+	sseHeartbeatComment = ": heartbeat\n\n"
+)
+
+// sseFieldReplacer keeps line breaks from ending the id and event fields early
+var sseFieldReplacer = strings.NewReplacer("\r", " ", "\n", " ")
+
+// SSEEvent is one server-sent event, empty fields are not sent.
+type SSEEvent struct {
+	ID    string
+	Event string
+	// Data is sent as one data line per line
+	Data string
+	// Retry tells the client how long to wait before reconnecting
+	Retry time.Duration
+}
+
+type SSEConfig struct {
+	// Heartbeat is the interval of the comments keeping idle connections open, defaults to 15s, negative disables it
+	Heartbeat time.Duration
+	// Retry is sent to the client before any event if set
+	Retry time.Duration
+}
+
+// SSEStream pushes the events to one client, it is safe for concurrent use.
+type SSEStream struct {
+	ctx         context.Context
+	cancel      context.CancelFunc
+	writer      StreamWriter
+	lock        *sync.Mutex
+	lastEventID string
+	pathParams  map[string]string
+	queryParams map[string]string
+	header      http.Header
+}
+
+// SSEHandler pushes the events until it returns or the stream context is done.
+type SSEHandler func(stream *SSEStream) error
+
+// NewSSEHandler serves an event stream with handler. The params and header of the request are copied into the stream
+// as the request is not valid while streaming under FastHTTPEngine.
+func NewSSEHandler(config SSEConfig, handler SSEHandler) RequestHandler {
+	if config.Heartbeat == 0 {
+		config.Heartbeat = defaultSSEHeartbeat
+	}
+	return func(request Request) (Response, ServiceError) {
+		stream := &SSEStream{
+			lock:        new(sync.Mutex),
+			lastEventID: request.Header().Get(LastEventIDHeader),
+			pathParams:  copyParams(request.PathParams()),
+			queryParams: copyParams(request.QueryParams()),
+			header:      request.Header().Clone(),
+		}
+		startContext := streamContext(request)
+		resp := NewStreamResponse(http.StatusOK, SSEContentType, func(w StreamWriter) error {
+			stream.ctx, stream.cancel = startContext()
+			defer stream.cancel()
+			stream.writer = w
+			return stream.run(config, handler)
+		})
+		resp.SetHeader("Cache-Control", "no-cache")
+		// keeps reverse proxies like nginx from buffering the events
+		resp.SetHeader("X-Accel-Buffering", "no")
+		return resp, nil
+	}
+}
+
+func (s *SSEStream) run(config SSEConfig, handler SSEHandler) error {
+	if config.Retry > 0 {
+		if err := s.Send(SSEEvent{Retry: config.Retry}); err != nil {
+			return nil
+		}
+	} else if err := s.flush(); err != nil {
+		return nil
+	}
+	if config.Heartbeat > 0 {
+		heartbeatDone := make(chan struct{})
+		defer func() {
+			s.cancel()
+			<-heartbeatDone
+		}()
+		go s.heartbeat(config.Heartbeat, heartbeatDone)
+	}
+	err := handler(s)
+	if s.ctx.Err() != nil {
+		// the client is gone, the errors of the handler are caused by the closed stream
+		return nil
+	}
+	return err
+}
+
+func (s *SSEStream) heartbeat(interval time.Duration, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+			if s.write(sseHeartbeatComment) != nil {
+				return
+			}
+		}
+	}
+}
+
+// Send writes and flushes the event, the stream context is cancelled if the client is gone.
+func (s *SSEStream) Send(event SSEEvent) error {
+	var builder strings.Builder
+	if event.ID != "" {
+		builder.WriteString("id: " + sseFieldReplacer.Replace(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		builder.WriteString("event: " + sseFieldReplacer.Replace(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		fmt.Fprintf(&builder, "retry: %d\n", event.Retry.Milliseconds())
+	}
+	if event.Data != "" || event.Event != "" || event.ID != "" {
+		for _, line := range strings.Split(strings.ReplaceAll(event.Data, "\r\n", "\n"), "\n") {
+			builder.WriteString("data: " + line + "\n")
+		}
+	}
+	builder.WriteString("\n")
+	return s.write(builder.String())
+}
+
+// SendJSON sends payload encoded as JSON in an event of type event.
+func (s *SSEStream) SendJSON(id, event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return s.Send(SSEEvent{ID: id, Event: event, Data: string(data)})
+}
+
+func (s *SSEStream) write(message string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := s.writer.Write([]byte(message)); err != nil {
+		s.cancel()
+		return err
+	}
+	if err := s.writer.Flush(); err != nil {
+		s.cancel()
+		return err
+	}
+	return nil
+}
+
+func (s *SSEStream) flush() error {
+	return s.write("")
+}
+
+// Context is done when the client disconnects, the server shuts down or the handler returns. Under FastHTTPEngine a
+// disconnection is only noticed when a write fails, e.g. the next heartbeat, so it is never noticed with heartbeats
+// disabled until the handler sends an event.
+func (s *SSEStream) Context() context.Context {
+	return s.ctx
+}
+
+// LastEventID is the id of the last event the client received before reconnecting, empty for new clients.
+func (s *SSEStream) LastEventID() string {
+	return s.lastEventID
+}
+
+func (s *SSEStream) PathParams() map[string]string {
+	return s.pathParams
+}
+
+func (s *SSEStream) QueryParams() map[string]string {
+	return s.queryParams
+}
+
+func (s *SSEStream) Header() http.Header {
+	return s.header
+}
+
+func copyParams(params map[string]string) map[string]string {
+	copied := make(map[string]string, len(params))
+	for key, value := range params {
+		copied[key] = value
+	}
+	return copied
+}
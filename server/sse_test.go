@@ -0,0 +1,123 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readSSEMessage reads the lines up to the blank line ending a message
+func readSSEMessage(t *testing.T, reader *bufio.Reader) string {
+	var message strings.Builder
+	for {
+		line := readLine(t, reader)
+		message.WriteString(line)
+		if line == "\n" || line == "" {
+			return message.String()
+		}
+	}
+}
+
+func getSSE(t *testing.T, url string, header http.Header) *bufio.Reader {
+	request, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request.Header = header
+	resp, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		resp.Body.Close()
+	})
+	if resp.Header.Get("Content-Type") != SSEContentType || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("unexpected headers %v", resp.Header)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func TestSSEEvents(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			url := newStreamTestServer(t, e.engine, NewSSEHandler(SSEConfig{Heartbeat: -1, Retry: time.Second * 3}, func(stream *SSEStream) error {
+				stream.Send(SSEEvent{ID: stream.LastEventID() + "+1", Event: "greeting", Data: "hello " + stream.QueryParams()["name"] + "\r\nbye"})
+				stream.Send(SSEEvent{ID: "multi\nline", Data: "{}"})
+				stream.SendJSON("3", "json", map[string]int{"n": 1})
+				return nil
+			}))
+			reader := getSSE(t, url+"?name=ann", http.Header{LastEventIDHeader: []string{"41"}})
+			expected := []string{
+				"retry: 3000\n\n",
+				"id: 41+1\nevent: greeting\ndata: hello ann\ndata: bye\n\n",
+				"id: multi line\ndata: {}\n\n",
+				"id: 3\nevent: json\ndata: {\"n\":1}\n\n",
+			}
+			for _, message := range expected {
+				if got := readSSEMessage(t, reader); got != message {
+					t.Fatalf("expected %q, got %q", message, got)
+				}
+			}
+		})
+	}
+}
+
+func TestSSEHeartbeat(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			url := newStreamTestServer(t, e.engine, NewSSEHandler(SSEConfig{Heartbeat: time.Millisecond * 20}, func(stream *SSEStream) error {
+				<-stream.Context().Done()
+				return nil
+			}))
+			reader := getSSE(t, url, nil)
+			for i := 0; i < 2; i++ {
+				if message := readSSEMessage(t, reader); message != sseHeartbeatComment {
+					t.Fatalf("expected a heartbeat, got %q", message)
+				}
+			}
+		})
+	}
+}
+
+func TestSSEStreamIsCancelledOnShutdown(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			cancelled := make(chan struct{})
+			builder, url := listenTestServer(t, NewBuilder().Engine(e.engine).WithService(NewServiceBuilder().Id("events").
+				WithRouteHandlers(PathHandlerBuilder("/events").Get(NewSSEHandler(SSEConfig{Heartbeat: -1}, func(stream *SSEStream) error {
+					stream.Send(SSEEvent{Data: "connected"})
+					<-stream.Context().Done()
+					close(cancelled)
+					return nil
+				}))).MustBuild()))
+			svr, err := builder.Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			runTestServer(t, svr, url)
+			reader := getSSE(t, url+"/events", nil)
+			if message := readSSEMessage(t, reader); message != "data: connected\n\n" {
+				t.Fatalf("unexpected message %q", message)
+			}
+			// a connection dialed but never used by the client would hold the shutdown of net/http for 5s
+			http.DefaultClient.CloseIdleConnections()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			start := time.Now()
+			if err := svr.Shutdown(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second*2 {
+				t.Fatalf("shutdown waited %s for the stream", elapsed)
+			}
+			select {
+			case <-cancelled:
+			case <-time.After(time.Second):
+				t.Fatal("the stream context was not cancelled")
+			}
+		})
+	}
+}
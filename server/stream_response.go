@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+const streamChunkSize = 32 * 1024
+
+// StreamWriter writes the body of a streaming response, Flush sends the buffered bytes to the client right away.
+type StreamWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// StreamFunc writes the body of a streaming response, it returns when the body is complete.
+type StreamFunc func(w StreamWriter) error
+
+// streamResponse is written with chunked transfer encoding instead of being buffered by PayloadStream
+type streamResponse struct {
+	code        int
+	contentType string
+	header      map[string]string
+	stream      StreamFunc
+	// closer is closed if the stream never runs, e.g. for HEAD requests
+	closer io.Closer
+}
+
+// NewStreamResponse streams the body written by stream after the middlewares have returned, so the headers they set are
+// still sent. Under FastHTTPEngine the stream runs after the handler has returned and must not use the Request.
+// Errors of stream are logged as the status has been sent by then.
+func NewStreamResponse(code int, contentType string, stream StreamFunc) Response {
+	return &streamResponse{
+		code:        code,
+		contentType: contentType,
+		header:      make(map[string]string),
+		stream:      stream,
+	}
+}
+
+// NewReaderResponse streams reader in chunks flushed as they are read, reader is closed if it is an io.Closer.
+func NewReaderResponse(code int, contentType string, reader io.Reader) Response {
+	closer, _ := reader.(io.Closer)
+	resp := NewStreamResponse(code, contentType, func(w StreamWriter) error {
+		if closer != nil {
+			defer closer.Close()
+		}
+		return copyFlushing(w, reader)
+	}).(*streamResponse)
+	resp.closer = closer
+	return resp
+}
+
+type serverContextHolder interface {
+	setServerContext(ctx context.Context)
+	serverContext() context.Context
+}
+
+// streamContext returns what a stream of request calls when it starts to get the context it waits on. The context is
+// done when the server shuts down, and under net/http also when the client disconnects. The fasthttp request context
+// can't be used once the handler has returned, so there the disconnection is only seen by failing writes.
+func streamContext(request Request) func() (context.Context, context.CancelFunc) {
+	serverCtx := context.Background()
+	if holder, ok := request.(serverContextHolder); ok && holder.serverContext() != nil {
+		serverCtx = holder.serverContext()
+	}
+	requestCtx := serverCtx
+	if _, ok := request.(*fastHTTPRequest); !ok {
+		requestCtx = request.RawCtx()
+	}
+	return func() (context.Context, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(requestCtx)
+		stop := context.AfterFunc(serverCtx, cancel)
+		return ctx, func() {
+			stop()
+			cancel()
+		}
+	}
+}
+
+func copyFlushing(w StreamWriter, reader io.Reader) error {
+	buffer := make([]byte, streamChunkSize)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if _, writeErr := w.Write(buffer[:n]); writeErr != nil {
+				return writeErr
+			}
+			if flushErr := w.Flush(); flushErr != nil {
+				return flushErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *streamResponse) Code() int {
+	return r.code
+}
+
+func (r *streamResponse) SetCode(code int) {
+	r.code = code
+}
+
+// Payload is nil as the body is only produced while it is written.
+func (r *streamResponse) Payload() interface{} {
+	return nil
+}
+
+// SetPayload is ignored, the body of a streaming response can't be replaced.
+func (r *streamResponse) SetPayload(payload interface{}) {}
+
+func (r *streamResponse) SetHeader(key string, value string) {
+	r.header[key] = value
+}
+
+func (r *streamResponse) GetHeader(key string) (bool, string) {
+	value, exists := r.header[key]
+	return exists, value
+}
+
+func (r *streamResponse) IterateHeaders(cb func(k, v string)) {
+	for k, v := range r.header {
+		cb(k, v)
+	}
+}
+
+// PayloadStream is empty as the body is only produced while it is written.
+func (r *streamResponse) PayloadStream() ([]byte, error) {
+	return nil, nil
+}
+
+func (r *streamResponse) ContentType() string {
+	return r.contentType
+}
+
+// respondWithStream only sends the headers for HEAD requests
+func (s immutableServer) respondWithStream(w responseWriter, r *streamResponse, headOnly bool) error {
+	if r.contentType != "" {
+		w.SetHeader("Content-Type", r.contentType)
+	}
+	r.IterateHeaders(func(k string, v string) {
+		w.SetHeader(k, v)
+	})
+	w.WriteHeader(r.code)
+	if headOnly || r.code == http.StatusNoContent || r.code == http.StatusNotModified {
+		if r.closer != nil {
+			r.closer.Close()
+		}
+		return nil
+	}
+	w.WriteStream(func(writer StreamWriter) {
+		s.runStream(r.stream, writer)
+	})
+	return nil
+}
+
+// runStream logs the errors of stream as the status has been sent, panics are recovered as stream may run on a
+// goroutine of the engine
+func (s immutableServer) runStream(stream StreamFunc, writer StreamWriter) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			event := PanicEvent{
+				Time:  time.Now(),
+				Value: recovered,
+				Stack: string(debug.Stack()),
+			}
+			s.logger.Errorf(s.ctx, "%s", event.String())
+			s.reportPanic(event)
+		}
+	}()
+	if err := stream(writer); err != nil {
+		s.logger.Errorf(s.ctx, "failed to stream the response: %s", err.Error())
+	}
+}
+
+func (w netResponseWriter) Flush() error {
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// WriteStream flushes the headers and runs stream right away, net/http uses chunked transfer encoding as there is no
+// Content-Length
+func (w netResponseWriter) WriteStream(stream func(writer StreamWriter)) {
+	// the client may wait for the headers before the stream has anything to write, a failed flush fails the writes of
+	// stream too
+	w.Flush()
+	stream(w)
+}
+
+// WriteStream only registers stream, fasthttp sends the headers and runs it with chunked transfer encoding once the
+// handler has returned
+func (w fastHTTPResponseWriter) WriteStream(stream func(writer StreamWriter)) {
+	w.ctx.Response.ImmediateHeaderFlush = true
+	w.ctx.SetBodyStreamWriter(func(writer *bufio.Writer) {
+		stream(writer)
+	})
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEngines = []struct {
+	name   string
+	engine Engine
+}{
+	{"net", NetEngine},
+	{"fasthttp", FastHTTPEngine},
+}
+
+// newStreamTestServer serves handler on GET /stream with engine and returns the url of the route
+func newStreamTestServer(t *testing.T, engine Engine, handler RequestHandler) string {
+	builder, url := listenTestServer(t, NewBuilder().Engine(engine).
+		WithService(NewServiceBuilder().Id("stream").WithRouteHandlers(PathHandlerBuilder("/stream").Get(handler)).MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	runTestServer(t, svr, url)
+	return url + "/stream"
+}
+
+// readLine fails the test if no line arrives within a second
+func readLine(t *testing.T, reader *bufio.Reader) string {
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := reader.ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		return line
+	case <-time.After(time.Second):
+		t.Fatal("no line arrived")
+		return ""
+	}
+}
+
+func TestStreamResponseFlushesBeforeTheStreamEnds(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			release := make(chan struct{})
+			url := newStreamTestServer(t, e.engine, func(r Request) (Response, ServiceError) {
+				resp := NewStreamResponse(http.StatusOK, "text/plain", func(w StreamWriter) error {
+					io.WriteString(w, "first\n")
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					select {
+					case <-release:
+					case <-time.After(time.Second * 5):
+					}
+					_, err := io.WriteString(w, "second\n")
+					return err
+				})
+				resp.SetHeader("X-Stream", "true")
+				return resp, nil
+			})
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			if resp.Header.Get("X-Stream") != "true" || resp.Header.Get("Content-Type") != "text/plain" {
+				t.Fatalf("unexpected headers %v", resp.Header)
+			}
+			reader := bufio.NewReader(resp.Body)
+			if line := readLine(t, reader); line != "first\n" {
+				t.Fatalf("unexpected first line %q", line)
+			}
+			close(release)
+			rest, err := io.ReadAll(reader)
+			if err != nil || string(rest) != "second\n" {
+				t.Fatalf("unexpected rest %q %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestReaderResponse(t *testing.T) {
+	for _, e := range testEngines {
+		t.Run(e.name, func(t *testing.T) {
+			body := strings.Repeat("x", streamChunkSize*2+10)
+			url := newStreamTestServer(t, e.engine, func(r Request) (Response, ServiceError) {
+				return NewReaderResponse(http.StatusOK, "text/plain", io.NopCloser(strings.NewReader(body))), nil
+			})
+			resp, err := http.Get(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			data, err := io.ReadAll(resp.Body)
+			if err != nil || string(data) != body {
+				t.Fatalf("unexpected body of %d bytes %v", len(data), err)
+			}
+			head, err := http.Head(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			head.Body.Close()
+			if head.StatusCode != http.StatusOK || head.Header.Get("Content-Type") != "text/plain" {
+				t.Fatalf("unexpected HEAD response %d %v", head.StatusCode, head.Header)
+			}
+		})
+	}
+}
+
+func TestReaderResponseClosesTheReaderOfHeadRequests(t *testing.T) {
+	closed := make(chan struct{})
+	url := newStreamTestServer(t, NetEngine, func(r Request) (Response, ServiceError) {
+		return NewReaderResponse(http.StatusOK, "text/plain", closeNotifier{strings.NewReader("body"), closed}), nil
+	})
+	resp, err := http.Head(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("the reader was not closed")
+	}
+}
+
+type closeNotifier struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func (c closeNotifier) Close() error {
+	close(c.closed)
+	return nil
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const selfSignedCertificateTTL = time.Hour * 24 * 365
+
+func (s *serverBuilder) TLS(certFile, keyFile string) Builder {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		s.err = fmt.Errorf("failed to load TLS certificate %s: %v", certFile, err)
+		return s
+	}
+	s.addCertificate(cert)
+	return s
+}
+
+// TLSConfig replaces the TLS config of the server with a copy of config, certificates added before will be dropped.
+func (s *serverBuilder) TLSConfig(config *tls.Config) Builder {
+	// the engines set up ALPN on the config, which may be shared by the caller
+	s.tlsConfig = config.Clone()
+	return s
+}
+
+// SelfSignedTLS serves TLS with a certificate generated on the fly, meant for development only.
+func (s *serverBuilder) SelfSignedTLS(hosts ...string) Builder {
+	cert, err := NewSelfSignedCertificate(hosts...)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	s.addCertificate(cert)
+	return s
+}
+
+// HTTPSRedirect listens for plain HTTP on addr and redirects every request to the TLS listener.
+func (s *serverBuilder) HTTPSRedirect(addr string) Builder {
+	s.httpsRedirectAddr = addr
+	return s
+}
+
+// CertificateManager picks certificates by SNI and hot reloads them while the server is running.
+func (s *serverBuilder) CertificateManager(manager *CertificateManager) Builder {
+	s.certManager = manager
+	return s
+}
+
+// addCertificate adds cert to a copy of the TLS config as the config may be shared by the caller
+func (s *serverBuilder) addCertificate(cert tls.Certificate) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if s.tlsConfig != nil {
+		config = s.tlsConfig.Clone()
+	}
+	certificates := config.Certificates
+	config.Certificates = append(certificates[:len(certificates):len(certificates)], cert)
+	s.tlsConfig = config
+}
+
+// NewSelfSignedCertificate generates a self-signed certificate for the hosts(localhost by default).
+func NewSelfSignedCertificate(hosts ...string) (tls.Certificate, error) {
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate private key: %v", err)
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate serial number: %v", err)
+	}
+	now := time.Now()
+	template := x509.Certificate{
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{Organization: []string{"aghs self-signed"}, CommonName: hosts[0]},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedCertificateTTL),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to create certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func (s immutableServer) httpsRedirectServing(tlsAddr net.Addr) (serving, error) {
+	s.logger.Infof(s.ctx, "starting the https redirect server on %s...", s.httpsRedirectAddr)
+	listener, err := s.listenAddr(s.httpsRedirectAddr)
+	if err != nil {
+		s.logger.Errorf(s.ctx, "error starting https redirect server at addr %s: %s", s.httpsRedirectAddr, err.Error())
+		return serving{}, err
+	}
+	tlsPort := ""
+	if tcpAddr, ok := tlsAddr.(*net.TCPAddr); ok && tcpAddr.Port != 443 {
+		tlsPort = strconv.Itoa(tcpAddr.Port)
+	}
+	// the timeouts of the server also protect the plain HTTP port
+	config := s.engineConfig()
+	config.TLSConfig, config.HTTP2 = nil, nil
+	return serving{s.httpsRedirectAddr, listener, NetEngine(httpsRedirectHandler(tlsPort), config)}, nil
+}
+
+func httpsRedirectHandler(tlsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		}
+		if tlsPort != "" {
+			host = net.JoinHostPort(host, tlsPort)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTLSKeepsCallerTLSConfig(t *testing.T) {
+	config := &tls.Config{MinVersion: tls.VersionTLS13, Certificates: make([]tls.Certificate, 0, 4)}
+	svr, err := NewBuilder().TLSConfig(config).SelfSignedTLS().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// neither the certificates nor their spare capacity
+	if len(config.Certificates) != 0 || len(config.Certificates[:1][0].Certificate) != 0 {
+		t.Fatal("the tls config of the caller was modified")
+	}
+	if served := svr.(immutableServer).tlsConfig; len(served.Certificates) != 1 || served.MinVersion != tls.VersionTLS13 {
+		t.Fatal("the server config lacks the certificate or the caller settings")
+	}
+}
+
+func TestHTTPSRedirectUsesServerTimeouts(t *testing.T) {
+	svr, err := NewBuilder().
+		SelfSignedTLS().
+		HTTPSRedirect("127.0.0.1:0").
+		ReadHeaderTimeout(time.Second).
+		ReadTimeout(time.Second * 2).
+		IdleTimeout(time.Second * 3).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := svr.(immutableServer).httpsRedirectServing(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8443})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer redirect.engineServer.Close()
+	server := redirect.engineServer.(netEngineServer).server
+	if server.ReadHeaderTimeout != time.Second || server.ReadTimeout != time.Second*2 || server.IdleTimeout != time.Second*3 {
+		t.Fatalf("unexpected redirect timeouts %v %v %v", server.ReadHeaderTimeout, server.ReadTimeout, server.IdleTimeout)
+	}
+	if server.TLSConfig != nil {
+		t.Fatal("the redirect server must serve plain HTTP")
+	}
+	go redirect.engineServer.Serve(redirect.listener)
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get("http://" + redirect.listener.Addr().String() + "/students?id=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if location := resp.Header.Get("Location"); resp.StatusCode != http.StatusPermanentRedirect || location != "https://127.0.0.1:8443/students?id=1" {
+		t.Fatalf("unexpected redirect %d to %s", resp.StatusCode, location)
+	}
+}
+
+func TestTLSConfigSharedByListeners(t *testing.T) {
+	cert, err := NewSelfSignedCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}}
+	// configuring HTTP/2 sets up ALPN on the tls config of both listeners
+	adminBuilder, adminURL := listenTestServer(t, NewBuilder().TLSConfig(config).HTTP2(HTTP2Config{}).WithService(newTestService("admin", "/admin", "admin").MustBuild()))
+	builder, url := listenTestServer(t, NewBuilder().TLSConfig(config).HTTP2(HTTP2Config{}).AddListener(adminBuilder).
+		WithService(newTestService("api", "/api", "api").MustBuild()))
+	svr, err := builder.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := tlsTestClient()
+	url, adminURL = strings.Replace(url, "http://", "https://", 1), strings.Replace(adminURL, "http://", "https://", 1)
+	runTestServerWithClient(t, svr, client, url)
+	for _, target := range []string{url + "/api", adminURL + "/admin"} {
+		resp, err := client.Get(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 2 {
+			t.Fatalf("unexpected response %d over %s from %s", resp.StatusCode, resp.Proto, target)
+		}
+	}
+	if config.NextProtos != nil {
+		t.Fatalf("the tls config of the caller was modified, NextProtos is %v", config.NextProtos)
+	}
+}
@@ -0,0 +1,286 @@
+package server
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+const (
+	webSocketGUID                 = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	webSocketVersion              = "13"
+	defaultWebSocketMaxMessage    = 1 << 20
+	defaultWebSocketPingInterval  = time.Second * 30
+	defaultWebSocketPongTimeout   = time.Second * 60
+	defaultWebSocketWriteTimeout  = time.Second * 10
+	defaultWebSocketSendQueueSize = 64
+	defaultWebSocketRecvQueueSize = 64
+)
+
+type WebSocketConfig struct {
+	// MaxMessageSize bounds a message after reassembling its fragments, bigger ones close with CloseMessageTooBig
+	MaxMessageSize int64
+	// PingInterval is the interval of the pings keeping the connection alive, negative disables them
+	PingInterval time.Duration
+	// PongTimeout closes the connection if nothing, not even a pong, is received for that long, negative disables it
+	PongTimeout  time.Duration
+	WriteTimeout time.Duration
+	// Subprotocols are the supported subprotocols in order of preference
+	Subprotocols []string
+	// SendQueueSize bounds the frames waiting to be written, hub subscribers that fall behind are closed
+	SendQueueSize int
+	// ReceiveQueueSize bounds the messages waiting for ReadMessage, pings and the close of the peer are handled meanwhile.
+	// Once it is full nothing more is read until the handler catches up, so TCP slows the peer down.
+	ReceiveQueueSize int
+	// CheckOrigin rejects the upgrade with 403 when it returns false, the default accepts requests without an Origin
+	// header and those whose Origin host is the request host
+	CheckOrigin func(request Request) bool
+}
+
+func (c WebSocketConfig) withDefaults() WebSocketConfig {
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = defaultWebSocketMaxMessage
+	}
+	if c.PingInterval == 0 {
+		c.PingInterval = defaultWebSocketPingInterval
+	}
+	if c.PongTimeout == 0 {
+		c.PongTimeout = defaultWebSocketPongTimeout
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = defaultWebSocketWriteTimeout
+	}
+	if c.SendQueueSize <= 0 {
+		c.SendQueueSize = defaultWebSocketSendQueueSize
+	}
+	if c.ReceiveQueueSize <= 0 {
+		c.ReceiveQueueSize = defaultWebSocketRecvQueueSize
+	}
+	if c.CheckOrigin == nil {
+		c.CheckOrigin = sameOrigin
+	}
+	return c
+}
+
+// WebSocketHandler serves one connection, the connection is closed with CloseNormalClosure when it returns nil and
+// with CloseInternalServerError otherwise.
+type WebSocketHandler func(conn *WebSocketConn) error
+
+// upgradeResponse switches the connection to the WebSocket protocol once the middlewares have returned
+type upgradeResponse struct {
+	*streamResponse
+	config        WebSocketConfig
+	handler       WebSocketHandler
+	accept        string
+	subprotocol   string
+	remoteAddress string
+	pathParams    map[string]string
+	queryParams   map[string]string
+	requestHeader http.Header
+}
+
+// NewWebSocketHandler upgrades the request to a WebSocket connection served by handler. The handshake is validated
+// before the upgrade, so middlewares still see a regular response and may reject it. The params and header of the
+// request are copied into the connection as the request is not valid once upgraded.
+func NewWebSocketHandler(config WebSocketConfig, handler WebSocketHandler) RequestHandler {
+	config = config.withDefaults()
+	return func(request Request) (Response, ServiceError) {
+		header := request.Header()
+		if request.Method() != http.MethodGet || !headerHasToken(header, "Connection", "upgrade") ||
+			!headerHasToken(header, "Upgrade", "websocket") {
+			return nil, BadRequestError("websocket upgrade is required")
+		}
+		if header.Get("Sec-WebSocket-Version") != webSocketVersion {
+			resp := NewPlainTextResponse(http.StatusUpgradeRequired, "unsupported websocket version")
+			resp.SetHeader("Sec-WebSocket-Version", webSocketVersion)
+			return resp, nil
+		}
+		key := header.Get("Sec-WebSocket-Key")
+		if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+			return nil, BadRequestError("invalid Sec-WebSocket-Key")
+		}
+		if !config.CheckOrigin(request) {
+			return nil, ForbiddenError("websocket origin is not allowed")
+		}
+		return &upgradeResponse{
+			streamResponse: NewStreamResponse(http.StatusSwitchingProtocols, "", nil).(*streamResponse),
+			config:         config,
+			handler:        handler,
+			accept:         webSocketAccept(key),
+			subprotocol:    selectSubprotocol(config.Subprotocols, header),
+			remoteAddress:  request.RemoteAddress(),
+			pathParams:     copyParams(request.PathParams()),
+			queryParams:    copyParams(request.QueryParams()),
+			requestHeader:  header.Clone(),
+		}, nil
+	}
+}
+
+func webSocketAccept(key string) string {
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func headerHasToken(header http.Header, key, token string) bool {
+	for _, value := range header.Values(key) {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func selectSubprotocol(supported []string, header http.Header) string {
+	for _, protocol := range supported {
+		if headerHasToken(header, "Sec-WebSocket-Protocol", protocol) {
+			return protocol
+		}
+	}
+	return ""
+}
+
+func sameOrigin(request Request) bool {
+	origin := request.Header().Get("Origin")
+	if origin == "" {
+		return true
+	}
+	parsed, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(parsed.Host, request.Host())
+}
+
+// respondWithUpgrade hands the connection over to the websocket handler, the upgrade fails on connections that can't
+// be hijacked, e.g. HTTP/2 ones
+func (s immutableServer) respondWithUpgrade(w responseWriter, r *upgradeResponse) error {
+	err := w.Upgrade(func(conn net.Conn, rw *bufio.ReadWriter) {
+		s.serveWebSocket(conn, rw, r)
+	})
+	if err != nil {
+		return s.respondWithError(w, InternalError(fmt.Sprintf("websocket upgrade is not supported: %s", err.Error())), nil, nil)
+	}
+	return nil
+}
+
+func (s immutableServer) serveWebSocket(netConn net.Conn, rw *bufio.ReadWriter, r *upgradeResponse) {
+	// the deadlines of the engine are meant for http requests
+	netConn.SetDeadline(time.Time{})
+	if err := writeWebSocketHandshake(rw.Writer, r); err != nil {
+		s.logger.Errorf(s.ctx, "failed to write the websocket handshake: %s", err.Error())
+		netConn.Close()
+		return
+	}
+	conn := newWebSocketConn(s.ctx, netConn, rw, r)
+	conn.run()
+	if !s.lifecycle.trackWebSocket(conn) {
+		conn.Close(CloseGoingAway, "server is shutting down")
+		return
+	}
+	defer s.lifecycle.untrackWebSocket(conn)
+	// the errors of the handler after the connection is closed are caused by the closed connection
+	if err := s.runWebSocketHandler(r.handler, conn); err != nil && conn.ctx.Err() == nil {
+		s.logger.Errorf(s.ctx, "websocket handler failed: %s", err.Error())
+		conn.Close(CloseInternalServerError, "internal error")
+		return
+	}
+	conn.Close(CloseNormalClosure, "")
+}
+
+func (s immutableServer) runWebSocketHandler(handler WebSocketHandler, conn *WebSocketConn) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			event := PanicEvent{
+				Time:  time.Now(),
+				Value: recovered,
+				Stack: string(debug.Stack()),
+			}
+			s.logger.Errorf(s.ctx, "%s", event.String())
+			s.reportPanic(event)
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return handler(conn)
+}
+
+func writeWebSocketHandshake(writer *bufio.Writer, r *upgradeResponse) error {
+	writer.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	writer.WriteString("Sec-WebSocket-Accept: " + r.accept + "\r\n")
+	if r.subprotocol != "" {
+		writer.WriteString("Sec-WebSocket-Protocol: " + r.subprotocol + "\r\n")
+	}
+	// headers set by the middlewares, e.g. the trace id, invalid names are dropped as they would break the handshake
+	r.IterateHeaders(func(k, v string) {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return
+		}
+		switch http.CanonicalHeaderKey(k) {
+		case "Upgrade", "Connection", "Sec-Websocket-Accept", "Sec-Websocket-Protocol", "Content-Length", "Content-Type":
+			return
+		}
+		writer.WriteString(k + ": " + strings.NewReplacer("\r", "", "\n", "").Replace(v) + "\r\n")
+	})
+	writer.WriteString("\r\n")
+	return writer.Flush()
+}
+
+func (l *serverLifecycle) trackWebSocket(conn *WebSocketConn) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.webSockets == nil {
+		return false
+	}
+	l.webSockets[conn] = struct{}{}
+	return true
+}
+
+func (l *serverLifecycle) untrackWebSocket(conn *WebSocketConn) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	delete(l.webSockets, conn)
+}
+
+// closeWebSockets closes the open connections with CloseGoingAway, upgrades afterwards are closed right away
+func (l *serverLifecycle) closeWebSockets() {
+	l.lock.Lock()
+	conns := l.webSockets
+	l.webSockets = nil
+	l.lock.Unlock()
+	done := make(chan struct{}, len(conns))
+	for conn := range conns {
+		go func(conn *WebSocketConn) {
+			conn.Close(CloseGoingAway, "server is shutting down")
+			done <- struct{}{}
+		}(conn)
+	}
+	for range conns {
+		<-done
+	}
+}
+
+// Upgrade hijacks the connection, upgrade runs on its own goroutine as net/http is done with the connection
+func (w netResponseWriter) Upgrade(upgrade func(conn net.Conn, rw *bufio.ReadWriter)) error {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return err
+	}
+	go upgrade(conn, rw)
+	return nil
+}
+
+// Upgrade hijacks the connection once the handler has returned, fasthttp closes the connection when upgrade returns
+func (w fastHTTPResponseWriter) Upgrade(upgrade func(conn net.Conn, rw *bufio.ReadWriter)) error {
+	w.ctx.HijackSetNoResponse(true)
+	w.ctx.Hijack(func(conn net.Conn) {
+		upgrade(conn, bufio.NewReadWriter(bufio.NewReader(conn), bufio.NewWriter(conn)))
+	})
+	return nil
+}
@@ -0,0 +1,519 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// close codes of RFC 6455 section 7.4.1
+const (
+	CloseNormalClosure       = 1000
+	CloseGoingAway           = 1001
+	CloseProtocolError       = 1002
+	CloseUnsupportedData     = 1003
+	CloseNoStatusReceived    = 1005
+	CloseAbnormalClosure     = 1006
+	CloseInvalidPayload      = 1007
+	ClosePolicyViolation     = 1008
+	CloseMessageTooBig       = 1009
+	CloseInternalServerError = 1011
+)
+
+type WebSocketMessageType int
+
+const (
+	TextMessage   WebSocketMessageType = 1
+	BinaryMessage WebSocketMessageType = 2
+)
+
+const (
+	wsOpContinuation    = 0x0
+	wsOpText            = 0x1
+	wsOpBinary          = 0x2
+	wsOpClose           = 0x8
+	wsOpPing            = 0x9
+	wsOpPong            = 0xa
+	wsMaxControlPayload = 125
+	wsCloseTimeout      = time.Second
+)
+
+var ErrWebSocketClosed = errors.New("websocket connection is closed")
+
+// CloseError is returned by ReadMessage once the connection is closed, Code is CloseAbnormalClosure if the connection
+// was lost without a close frame.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket closed with code %d %s", e.Code, e.Reason)
+}
+
+type webSocketMessage struct {
+	messageType WebSocketMessageType
+	data        []byte
+}
+
+type webSocketFrame struct {
+	opcode  byte
+	payload []byte
+	// result receives the write error, nil for frames nobody waits for
+	result chan error
+}
+
+// WebSocketConn is an upgraded connection. Messages are read by ReadMessage from one goroutine and are queued up to
+// WebSocketConfig.ReceiveQueueSize until then, writes are safe for concurrent use and are sent in order by a writer
+// goroutine.
+type WebSocketConn struct {
+	conn          net.Conn
+	reader        *bufio.Reader
+	writer        *bufio.Writer
+	config        WebSocketConfig
+	subprotocol   string
+	remoteAddress string
+	pathParams    map[string]string
+	queryParams   map[string]string
+	header        http.Header
+	ctx           context.Context
+	cancel        context.CancelFunc
+	messages      chan webSocketMessage
+	outbound      chan webSocketFrame
+	// peerClosed is closed when the close frame of the peer is received
+	peerClosed     chan struct{}
+	peerClosedOnce sync.Once
+	lock           sync.Mutex
+	closeSent      bool
+	closeErr       *CloseError
+	// closing is set once a close has been started in the background
+	closing atomic.Bool
+}
+
+func newWebSocketConn(ctx context.Context, conn net.Conn, rw *bufio.ReadWriter, r *upgradeResponse) *WebSocketConn {
+	c := &WebSocketConn{
+		conn:          conn,
+		reader:        rw.Reader,
+		writer:        rw.Writer,
+		config:        r.config,
+		subprotocol:   r.subprotocol,
+		remoteAddress: r.remoteAddress,
+		pathParams:    r.pathParams,
+		queryParams:   r.queryParams,
+		header:        r.requestHeader,
+		messages:      make(chan webSocketMessage, r.config.ReceiveQueueSize),
+		outbound:      make(chan webSocketFrame, r.config.SendQueueSize),
+		peerClosed:    make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(ctx)
+	return c
+}
+
+func (c *WebSocketConn) run() {
+	go c.readLoop()
+	go c.writeLoop()
+	if c.config.PingInterval > 0 {
+		go c.pingLoop()
+	}
+}
+
+// ReadMessage blocks until a text or binary message arrives, it returns a *CloseError once the connection is closed.
+func (c *WebSocketConn) ReadMessage() (WebSocketMessageType, []byte, error) {
+	// messages received before the close are still delivered
+	select {
+	case message := <-c.messages:
+		return message.messageType, message.data, nil
+	default:
+	}
+	select {
+	case message := <-c.messages:
+		return message.messageType, message.data, nil
+	case <-c.ctx.Done():
+		return 0, nil, c.closeError()
+	}
+}
+
+// WriteMessage sends data as one message and waits until it is written.
+func (c *WebSocketConn) WriteMessage(messageType WebSocketMessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("unsupported message type %d", messageType)
+	}
+	return c.write(byte(messageType), data)
+}
+
+func (c *WebSocketConn) WriteText(text string) error {
+	return c.write(wsOpText, []byte(text))
+}
+
+func (c *WebSocketConn) WriteJSON(payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return c.write(wsOpText, data)
+}
+
+// Close sends the close frame and closes the connection once the peer replies or after a second, also when the close
+// frame can't be queued because the peer doesn't read.
+func (c *WebSocketConn) Close(code int, reason string) error {
+	go c.sendClose(code, reason)
+	select {
+	case <-c.peerClosed:
+	case <-c.ctx.Done():
+	case <-time.After(wsCloseTimeout):
+	}
+	c.terminate(&CloseError{Code: code, Reason: reason})
+	return nil
+}
+
+// closeInBackground starts closing the connection unless it is already being closed in the background
+func (c *WebSocketConn) closeInBackground(code int, reason string) {
+	if c.closing.CompareAndSwap(false, true) {
+		go c.Close(code, reason)
+	}
+}
+
+// Context is done once the connection is closed.
+func (c *WebSocketConn) Context() context.Context {
+	return c.ctx
+}
+
+// Subprotocol is the subprotocol selected during the handshake, empty if none.
+func (c *WebSocketConn) Subprotocol() string {
+	return c.subprotocol
+}
+
+func (c *WebSocketConn) RemoteAddress() string {
+	return c.remoteAddress
+}
+
+func (c *WebSocketConn) PathParams() map[string]string {
+	return c.pathParams
+}
+
+func (c *WebSocketConn) QueryParams() map[string]string {
+	return c.queryParams
+}
+
+// Header is the header of the upgrade request.
+func (c *WebSocketConn) Header() http.Header {
+	return c.header
+}
+
+func (c *WebSocketConn) write(opcode byte, payload []byte) error {
+	result := make(chan error, 1)
+	select {
+	case c.outbound <- webSocketFrame{opcode: opcode, payload: payload, result: result}:
+	case <-c.ctx.Done():
+		return ErrWebSocketClosed
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-c.ctx.Done():
+		return ErrWebSocketClosed
+	}
+}
+
+// trySend queues the frame without waiting, it returns false if the queue is full or the connection is closed
+func (c *WebSocketConn) trySend(opcode byte, payload []byte) bool {
+	select {
+	case c.outbound <- webSocketFrame{opcode: opcode, payload: payload}:
+		return true
+	case <-c.ctx.Done():
+	default:
+	}
+	return false
+}
+
+// sendClose sends the close frame once and waits until it is written
+func (c *WebSocketConn) sendClose(code int, reason string) {
+	c.lock.Lock()
+	if c.closeSent {
+		c.lock.Unlock()
+		return
+	}
+	c.closeSent = true
+	c.lock.Unlock()
+	c.write(wsOpClose, closePayload(code, reason))
+}
+
+func (c *WebSocketConn) setCloseError(err *CloseError) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closeErr == nil {
+		c.closeErr = err
+	}
+}
+
+func (c *WebSocketConn) closeError() *CloseError {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closeErr == nil {
+		return &CloseError{Code: CloseAbnormalClosure}
+	}
+	return c.closeErr
+}
+
+// terminate closes the connection without a close frame, err is the close error if none has been recorded
+func (c *WebSocketConn) terminate(err *CloseError) {
+	if err != nil {
+		c.setCloseError(err)
+	}
+	c.cancel()
+	c.conn.Close()
+}
+
+// fail closes the connection after a protocol violation of the peer
+func (c *WebSocketConn) fail(code int, reason string) {
+	c.setCloseError(&CloseError{Code: code, Reason: reason})
+	c.sendClose(code, reason)
+	c.terminate(nil)
+}
+
+func (c *WebSocketConn) readLoop() {
+	var (
+		messageType byte
+		message     []byte
+		fragmented  bool
+	)
+	for {
+		if c.config.PongTimeout > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(c.config.PongTimeout))
+		}
+		fin, opcode, payload, err := readWebSocketFrame(c.reader, c.config.MaxMessageSize-int64(len(message)))
+		if err != nil {
+			var closeErr *CloseError
+			if errors.As(err, &closeErr) {
+				c.fail(closeErr.Code, closeErr.Reason)
+			} else {
+				c.terminate(nil)
+			}
+			return
+		}
+		switch opcode {
+		case wsOpPing:
+			c.trySend(wsOpPong, payload)
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			c.handlePeerClose(payload)
+			return
+		case wsOpContinuation:
+			if !fragmented {
+				c.fail(CloseProtocolError, "unexpected continuation frame")
+				return
+			}
+			message = append(message, payload...)
+		default:
+			if fragmented {
+				c.fail(CloseProtocolError, "expected continuation frame")
+				return
+			}
+			messageType, message, fragmented = opcode, payload, true
+		}
+		if !fin {
+			continue
+		}
+		fragmented = false
+		if messageType == wsOpText && !utf8.Valid(message) {
+			c.fail(CloseInvalidPayload, "invalid utf-8 text")
+			return
+		}
+		select {
+		case c.messages <- webSocketMessage{WebSocketMessageType(messageType), message}:
+		case <-c.ctx.Done():
+			return
+		}
+		message = nil
+	}
+}
+
+func (c *WebSocketConn) handlePeerClose(payload []byte) {
+	code, reason := CloseNoStatusReceived, ""
+	if len(payload) == 1 {
+		c.fail(CloseProtocolError, "invalid close payload")
+		return
+	}
+	if len(payload) >= 2 {
+		code, reason = int(binary.BigEndian.Uint16(payload)), string(payload[2:])
+		if !validCloseCode(code) {
+			c.fail(CloseProtocolError, "invalid close code")
+			return
+		}
+		if !utf8.ValidString(reason) {
+			c.fail(CloseInvalidPayload, "invalid utf-8 close reason")
+			return
+		}
+	}
+	c.setCloseError(&CloseError{Code: code, Reason: reason})
+	c.peerClosedOnce.Do(func() {
+		close(c.peerClosed)
+	})
+	// echo the close code as RFC 6455 requires
+	c.sendClose(code, "")
+	c.terminate(nil)
+}
+
+func (c *WebSocketConn) writeLoop() {
+	closeWritten := false
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case frame := <-c.outbound:
+			var err error
+			if closeWritten {
+				err = ErrWebSocketClosed
+			} else {
+				if c.config.WriteTimeout > 0 {
+					c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+				}
+				err = writeWebSocketFrame(c.writer, frame.opcode, frame.payload)
+				closeWritten = frame.opcode == wsOpClose
+			}
+			if frame.result != nil {
+				frame.result <- err
+			}
+			if err != nil && err != ErrWebSocketClosed {
+				c.terminate(nil)
+				return
+			}
+		}
+	}
+}
+
+func (c *WebSocketConn) pingLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			c.trySend(wsOpPing, nil)
+		}
+	}
+}
+
+// readWebSocketFrame reads one client frame, protocol violations are returned as *CloseError
+func readWebSocketFrame(reader io.Reader, maxPayload int64) (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err = io.ReadFull(reader, header[:]); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+	if header[0]&0x70 != 0 {
+		err = &CloseError{Code: CloseProtocolError, Reason: "reserved bits are set"}
+		return
+	}
+	if header[1]&0x80 == 0 {
+		err = &CloseError{Code: CloseProtocolError, Reason: "client frames must be masked"}
+		return
+	}
+	length := int64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err = io.ReadFull(reader, extended[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err = io.ReadFull(reader, extended[:]); err != nil {
+			return
+		}
+		if extended[0]&0x80 != 0 {
+			err = &CloseError{Code: CloseProtocolError, Reason: "invalid payload length"}
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(extended[:]))
+	}
+	switch {
+	case opcode >= wsOpClose:
+		if opcode > wsOpPong {
+			err = &CloseError{Code: CloseProtocolError, Reason: "unknown opcode"}
+			return
+		}
+		if !fin || length > wsMaxControlPayload {
+			err = &CloseError{Code: CloseProtocolError, Reason: "invalid control frame"}
+			return
+		}
+	case opcode > wsOpBinary:
+		err = &CloseError{Code: CloseProtocolError, Reason: "unknown opcode"}
+		return
+	case length > maxPayload:
+		err = &CloseError{Code: CloseMessageTooBig, Reason: "message is too big"}
+		return
+	}
+	var mask [4]byte
+	if _, err = io.ReadFull(reader, mask[:]); err != nil {
+		return
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(reader, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeWebSocketFrame writes one unfragmented and unmasked server frame
+func writeWebSocketFrame(writer *bufio.Writer, opcode byte, payload []byte) error {
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch length := len(payload); {
+	case length <= 125:
+		header[1] = byte(length)
+	case length <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(length))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(length))
+	}
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	if _, err := writer.Write(payload); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+func closePayload(code int, reason string) []byte {
+	if code == CloseNoStatusReceived {
+		return nil
+	}
+	// control frames are limited to 125 bytes, the reason is cut on a rune boundary to stay valid utf-8
+	if len(reason) > wsMaxControlPayload-2 {
+		cut := wsMaxControlPayload - 2
+		for cut > 0 && !utf8.RuneStart(reason[cut]) {
+			cut--
+		}
+		reason = reason[:cut]
+	}
+	return append(binary.BigEndian.AppendUint16(nil, uint16(code)), reason...)
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014, code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
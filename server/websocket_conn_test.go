@@ -0,0 +1,214 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// clientFrame encodes a masked client frame, header0 carries the fin bit, the reserved bits and the opcode
+func clientFrame(header0 byte, payload []byte) []byte {
+	frame := []byte{header0}
+	switch length := len(payload); {
+	case length <= 125:
+		frame = append(frame, 0x80|byte(length))
+	case length <= 0xffff:
+		frame = binary.BigEndian.AppendUint16(append(frame, 0x80|126), uint16(length))
+	default:
+		frame = binary.BigEndian.AppendUint64(append(frame, 0x80|127), uint64(length))
+	}
+	mask := []byte{0x12, 0x34, 0x56, 0x78}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	return frame
+}
+
+func TestReadWebSocketFrame(t *testing.T) {
+	cases := []struct {
+		name    string
+		header0 byte
+		length  int
+	}{
+		{"empty text", 0x80 | wsOpText, 0},
+		{"short binary", 0x80 | wsOpBinary, 125},
+		{"16 bit length", 0x80 | wsOpBinary, 126},
+		{"longest 16 bit length", 0x80 | wsOpText, 0xffff},
+		{"64 bit length", 0x80 | wsOpBinary, 0x10000},
+		{"first fragment", wsOpText, 10},
+		{"continuation", 0x80 | wsOpContinuation, 10},
+		{"ping", 0x80 | wsOpPing, 125},
+		{"close", 0x80 | wsOpClose, 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			payload := bytes.Repeat([]byte("abcdefg"), c.length/7+1)[:c.length]
+			fin, opcode, got, err := readWebSocketFrame(bytes.NewReader(clientFrame(c.header0, payload)), 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fin != (c.header0&0x80 != 0) || opcode != c.header0&0x0f {
+				t.Fatalf("unexpected fin %v and opcode %d", fin, opcode)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Fatalf("unexpected payload of %d bytes", len(got))
+			}
+		})
+	}
+}
+
+func TestReadWebSocketFrameErrors(t *testing.T) {
+	unmasked := clientFrame(0x80|wsOpText, []byte("hi"))
+	unmasked[1] &= 0x7f
+	hugeLength := append([]byte{0x80 | wsOpBinary, 0x80 | 127}, 0x80, 0, 0, 0, 0, 0, 0, 0)
+	cases := []struct {
+		name  string
+		frame []byte
+		code  int
+	}{
+		{"unmasked", unmasked, CloseProtocolError},
+		{"reserved bits", clientFrame(0xc0|wsOpText, []byte("hi")), CloseProtocolError},
+		{"unknown data opcode", clientFrame(0x80|0x3, nil), CloseProtocolError},
+		{"unknown control opcode", clientFrame(0x80|0xb, nil), CloseProtocolError},
+		{"fragmented control", clientFrame(wsOpPing, nil), CloseProtocolError},
+		{"long control", clientFrame(0x80|wsOpPing, make([]byte, 126)), CloseProtocolError},
+		{"invalid length", hugeLength, CloseProtocolError},
+		{"too big", clientFrame(0x80|wsOpBinary, make([]byte, 11)), CloseMessageTooBig},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, _, _, err := readWebSocketFrame(bytes.NewReader(c.frame), 10)
+			var closeErr *CloseError
+			if !errors.As(err, &closeErr) || closeErr.Code != c.code {
+				t.Fatalf("expected close code %d, got %v", c.code, err)
+			}
+		})
+	}
+	if _, _, _, err := readWebSocketFrame(bytes.NewReader(clientFrame(0x80|wsOpText, []byte("hello"))[:8]), 10); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF for a truncated frame, got %v", err)
+	}
+}
+
+func TestWriteWebSocketFrame(t *testing.T) {
+	cases := []struct {
+		length int
+		header []byte
+	}{
+		{0, []byte{0x81, 0}},
+		{125, []byte{0x81, 125}},
+		{126, []byte{0x81, 126, 0, 126}},
+		{0xffff, []byte{0x81, 126, 0xff, 0xff}},
+		{0x10000, []byte{0x81, 127, 0, 0, 0, 0, 0, 1, 0, 0}},
+	}
+	for _, c := range cases {
+		var buf bytes.Buffer
+		payload := bytes.Repeat([]byte("x"), c.length)
+		if err := writeWebSocketFrame(bufio.NewWriter(&buf), wsOpText, payload); err != nil {
+			t.Fatal(err)
+		}
+		frame := buf.Bytes()
+		if !bytes.Equal(frame[:len(c.header)], c.header) || !bytes.Equal(frame[len(c.header):], payload) {
+			t.Fatalf("unexpected frame header %v for a payload of %d bytes", frame[:len(c.header)], c.length)
+		}
+	}
+}
+
+func TestClosePayload(t *testing.T) {
+	if payload := closePayload(CloseNoStatusReceived, "ignored"); payload != nil {
+		t.Fatalf("expected no payload for CloseNoStatusReceived, got %v", payload)
+	}
+	cases := []struct {
+		name   string
+		reason string
+		want   string
+	}{
+		{"empty", "", ""},
+		{"short", "bye", "bye"},
+		{"longest", strings.Repeat("a", 123), strings.Repeat("a", 123)},
+		{"ascii cut", strings.Repeat("a", 200), strings.Repeat("a", 123)},
+		// 123 bytes end in the middle of the 62nd two byte rune
+		{"two byte runes cut", strings.Repeat("é", 100), strings.Repeat("é", 61)},
+		{"four byte runes cut", strings.Repeat("😀", 40), strings.Repeat("😀", 30)},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			payload := closePayload(CloseGoingAway, c.reason)
+			if len(payload) > wsMaxControlPayload {
+				t.Fatalf("payload of %d bytes exceeds the control frame limit", len(payload))
+			}
+			if code := binary.BigEndian.Uint16(payload); code != CloseGoingAway {
+				t.Fatalf("unexpected close code %d", code)
+			}
+			reason := string(payload[2:])
+			if !utf8.ValidString(reason) || reason != c.want {
+				t.Fatalf("unexpected reason %q", reason)
+			}
+		})
+	}
+}
+
+// newPipeWebSocketConn serves a WebSocketConn on one end of a pipe and returns the other end as the client
+func newPipeWebSocketConn(t *testing.T, config WebSocketConfig) (*WebSocketConn, net.Conn) {
+	server, client := net.Pipe()
+	config.PingInterval = -1
+	conn := newWebSocketConn(context.Background(), server, bufio.NewReadWriter(bufio.NewReader(server), bufio.NewWriter(server)), &upgradeResponse{config: config.withDefaults()})
+	conn.run()
+	t.Cleanup(func() {
+		conn.terminate(nil)
+		client.Close()
+	})
+	client.SetDeadline(time.Now().Add(time.Second * 5))
+	return conn, client
+}
+
+// readServerFrame reads one unmasked server frame of at most 125 bytes
+func readServerFrame(t *testing.T, reader io.Reader) (byte, []byte) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+func TestWebSocketConnAnswersPingsWhileMessagesAreUnread(t *testing.T) {
+	conn, client := newPipeWebSocketConn(t, WebSocketConfig{})
+	for i := 0; i < 3; i++ {
+		client.Write(clientFrame(0x80|wsOpText, []byte("unread")))
+	}
+	client.Write(clientFrame(0x80|wsOpPing, []byte("ping")))
+	if opcode, payload := readServerFrame(t, client); opcode != wsOpPong || string(payload) != "ping" {
+		t.Fatalf("expected the pong, got opcode %d with %q", opcode, payload)
+	}
+	if _, data, err := conn.ReadMessage(); err != nil || string(data) != "unread" {
+		t.Fatalf("expected the queued message, got %q %v", data, err)
+	}
+}
+
+func TestWebSocketConnKeepsMessagesBeyondReceiveQueue(t *testing.T) {
+	conn, client := newPipeWebSocketConn(t, WebSocketConfig{ReceiveQueueSize: 1})
+	messages := []string{"first", "second", "third"}
+	go func() {
+		for _, message := range messages {
+			client.Write(clientFrame(0x80|wsOpText, []byte(message)))
+		}
+	}()
+	time.Sleep(time.Millisecond * 50)
+	for _, message := range messages {
+		if _, data, err := conn.ReadMessage(); err != nil || string(data) != message {
+			t.Fatalf("expected %s, got %q %v", message, data, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// WebSocketHub broadcasts messages to the connections subscribed to a topic. Broadcasts never block, a subscriber whose
+// send queue is full is closed with ClosePolicyViolation.
+type WebSocketHub struct {
+	lock   *sync.RWMutex
+	topics map[string]map[*WebSocketConn]struct{}
+	// conns are the topics of each connection, a connection stays until it is closed so that it is watched once
+	conns map[*WebSocketConn]map[string]struct{}
+}
+
+func NewWebSocketHub() WebSocketHub {
+	return WebSocketHub{
+		lock:   new(sync.RWMutex),
+		topics: make(map[string]map[*WebSocketConn]struct{}),
+		conns:  make(map[*WebSocketConn]map[string]struct{}),
+	}
+}
+
+// Subscribe adds conn to topic until it is unsubscribed or closed.
+func (h WebSocketHub) Subscribe(topic string, conn *WebSocketConn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	subscribers, exists := h.topics[topic]
+	if !exists {
+		subscribers = make(map[*WebSocketConn]struct{})
+		h.topics[topic] = subscribers
+	}
+	subscribers[conn] = struct{}{}
+	topics, watched := h.conns[conn]
+	if !watched {
+		topics = make(map[string]struct{})
+		h.conns[conn] = topics
+		context.AfterFunc(conn.Context(), func() {
+			h.removeConn(conn)
+		})
+	}
+	topics[topic] = struct{}{}
+}
+
+func (h WebSocketHub) Unsubscribe(topic string, conn *WebSocketConn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	delete(h.conns[conn], topic)
+	h.unsubscribe(topic, conn)
+}
+
+func (h WebSocketHub) removeConn(conn *WebSocketConn) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for topic := range h.conns[conn] {
+		h.unsubscribe(topic, conn)
+	}
+	delete(h.conns, conn)
+}
+
+func (h WebSocketHub) unsubscribe(topic string, conn *WebSocketConn) {
+	subscribers := h.topics[topic]
+	delete(subscribers, conn)
+	if len(subscribers) == 0 {
+		delete(h.topics, topic)
+	}
+}
+
+// Broadcast queues the message to the subscribers of topic and returns the number of subscribers it was queued to, the
+// message type must be TextMessage or BinaryMessage.
+func (h WebSocketHub) Broadcast(topic string, messageType WebSocketMessageType, data []byte) (int, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return 0, fmt.Errorf("unsupported message type %d", messageType)
+	}
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	queued := 0
+	for conn := range h.topics[topic] {
+		if conn.Context().Err() != nil || conn.closing.Load() {
+			continue
+		}
+		if conn.trySend(byte(messageType), data) {
+			queued++
+			continue
+		}
+		conn.closeInBackground(ClosePolicyViolation, "subscriber is too slow")
+	}
+	return queued, nil
+}
+
+func (h WebSocketHub) BroadcastJSON(topic string, payload interface{}) (int, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+	return h.Broadcast(topic, TextMessage, data)
+}
+
+// Subscribers is the number of connections subscribed to topic.
+func (h WebSocketHub) Subscribers(topic string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.topics[topic])
+}
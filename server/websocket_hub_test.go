@@ -0,0 +1,50 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWebSocketHubClosesSlowSubscriberOnce(t *testing.T) {
+	hub := NewWebSocketHub()
+	// the client never reads, so the queued frames are never written
+	conn, _ := newPipeWebSocketConn(t, WebSocketConfig{SendQueueSize: 1})
+	hub.Subscribe("news", conn)
+	if queued, _ := hub.Broadcast("news", TextMessage, []byte("first")); queued != 1 {
+		t.Fatalf("expected the first message to be queued, got %d", queued)
+	}
+	// the writer holds the first frame, the second one fills the queue
+	time.Sleep(time.Millisecond * 20)
+	hub.Broadcast("news", TextMessage, []byte("second"))
+	for i := 0; i < 10; i++ {
+		if queued, _ := hub.Broadcast("news", TextMessage, []byte("dropped")); queued != 0 {
+			t.Fatalf("expected the slow subscriber to be skipped, got %d", queued)
+		}
+	}
+	if !conn.closing.Load() {
+		t.Fatal("expected the slow subscriber to be closing")
+	}
+	select {
+	case <-conn.Context().Done():
+	case <-time.After(wsCloseTimeout * 3):
+		t.Fatal("the slow subscriber was not closed")
+	}
+	time.Sleep(time.Millisecond * 20)
+	if subscribers := hub.Subscribers("news"); subscribers != 0 {
+		t.Fatalf("expected the closed subscriber to be removed, got %d", subscribers)
+	}
+}
+
+func TestWebSocketHubRejectsControlMessages(t *testing.T) {
+	hub := NewWebSocketHub()
+	conn, _ := newPipeWebSocketConn(t, WebSocketConfig{})
+	hub.Subscribe("news", conn)
+	for _, messageType := range []WebSocketMessageType{0, 8, 9, 10} {
+		if queued, err := hub.Broadcast("news", messageType, []byte("control")); err == nil || queued != 0 {
+			t.Fatalf("expected message type %d to be rejected, got %d %v", messageType, queued, err)
+		}
+	}
+	if queued, err := hub.Broadcast("news", BinaryMessage, []byte{1, 2}); err != nil || queued != 1 {
+		t.Fatalf("expected the binary message to be queued, got %d %v", queued, err)
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWriteWebSocketHandshake(t *testing.T) {
+	resp := &upgradeResponse{
+		streamResponse: NewStreamResponse(http.StatusSwitchingProtocols, "", nil).(*streamResponse),
+		accept:         webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="),
+		subprotocol:    "chat",
+	}
+	resp.SetHeader("X-Trace-Id", "abc\r\nX-Injected: 1")
+	resp.SetHeader("Bad Header", "dropped")
+	resp.SetHeader("Bad:Header", "dropped")
+	resp.SetHeader("Connection", "close")
+	var buffer bytes.Buffer
+	writer := bufio.NewWriter(&buffer)
+	if err := writeWebSocketHandshake(writer, resp); err != nil {
+		t.Fatal(err)
+	}
+	handshake, err := http.ReadResponse(bufio.NewReader(&buffer), nil)
+	if err != nil {
+		t.Fatalf("invalid handshake %q: %v", buffer.String(), err)
+	}
+	expected := http.Header{
+		"Upgrade":                []string{"websocket"},
+		"Connection":             []string{"Upgrade"},
+		"Sec-Websocket-Accept":   []string{"s3pPLMBiTxaQ9kYGzzhZRbK+xOo="},
+		"Sec-Websocket-Protocol": []string{"chat"},
+		"X-Trace-Id":             []string{"abcX-Injected: 1"},
+	}
+	if handshake.StatusCode != http.StatusSwitchingProtocols || len(handshake.Header) != len(expected) {
+		t.Fatalf("unexpected handshake %d %v", handshake.StatusCode, handshake.Header)
+	}
+	for key, values := range expected {
+		if got := handshake.Header.Values(key); strings.Join(got, ",") != strings.Join(values, ",") {
+			t.Errorf("expected %s: %v, got %v", key, values, got)
+		}
+	}
+}
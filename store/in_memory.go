@@ -121,6 +121,17 @@ func (s InMemoryKVStore) Delete(key interface{}) (success bool, err error) {
 	return
 }
 
+func (s InMemoryKVStore) Size() (size int) {
+	s.withRead(func() {
+		size = len(s.store)
+	})
+	return
+}
+
+func (s InMemoryKVStore) Capacity() int {
+	return s.capacity
+}
+
 func (s InMemoryKVStore) setWithoutLock(key interface{}, value interface{}) {
 	s.store[key] = value
 }
@@ -14,3 +14,9 @@ type AdvancedKVStore interface {
 	BulkGet(keys []interface{}) ([]interface{}, error)
 	BulkPut(bulk map[interface{}]interface{}) (bool, error)
 }
+
+// SizedKVStore reports the number of records and the maximum number of records the store holds.
+type SizedKVStore interface {
+	Size() int
+	Capacity() int
+}